@@ -4,7 +4,6 @@ import (
 	"compress/gzip"
 	"flag"
 	"fmt"
-	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -12,11 +11,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/d00p1/filtrate-backups/internal/dumpgen"
 	"github.com/d00p1/filtrate-backups/pkg/archive"
 )
 
-const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
 func main() {
 	var (
 		output        string
@@ -106,7 +104,7 @@ func generateTarGz(output string, tables []string, targetBytes int64, rowsPerIns
 		return 0, fmt.Errorf("create temp sql: %w", err)
 	}
 
-	sqlBytes, err := writeSQL(sqlFile, tables, targetBytes, rowsPerInsert, valueSize, rng)
+	sqlBytes, err := dumpgen.WriteSQL(sqlFile, tables, targetBytes, rowsPerInsert, valueSize, rng)
 	if closeErr := sqlFile.Close(); closeErr != nil && err == nil {
 		err = closeErr
 	}
@@ -128,65 +126,6 @@ func generateTarGz(output string, tables []string, targetBytes int64, rowsPerIns
 	return sqlBytes, nil
 }
 
-func writeSQL(w io.Writer, tables []string, targetBytes int64, rowsPerInsert, valueSize int, rng *rand.Rand) (int64, error) {
-	var written int64
-	for _, tableName := range tables {
-		base := "CREATE TABLE `" + tableName + "` (id BIGINT PRIMARY KEY, payload TEXT);\n"
-		n, err := io.WriteString(w, base)
-		if err != nil {
-			return written, fmt.Errorf("write ddl: %w", err)
-		}
-		written += int64(n)
-	}
-
-	ids := make([]int64, len(tables))
-	for i := range ids {
-		ids[i] = 1
-	}
-
-	tableIdx := 0
-	for written < targetBytes {
-		tableName := tables[tableIdx%len(tables)]
-		tablePos := tableIdx % len(tables)
-		tableIdx++
-
-		var b strings.Builder
-		b.Grow(rowsPerInsert * (valueSize + 48))
-		b.WriteString("INSERT INTO `")
-		b.WriteString(tableName)
-		b.WriteString("` VALUES ")
-		for i := 0; i < rowsPerInsert; i++ {
-			if i > 0 {
-				b.WriteString(",")
-			}
-			b.WriteString("(")
-			b.WriteString(strconv.FormatInt(ids[tablePos], 10))
-			b.WriteString(",'")
-			b.WriteString(randomString(rng, valueSize))
-			b.WriteString("')")
-			ids[tablePos]++
-		}
-		b.WriteString(";\n")
-
-		line := b.String()
-		n, err := io.WriteString(w, line)
-		if err != nil {
-			return written, fmt.Errorf("write insert: %w", err)
-		}
-		written += int64(n)
-	}
-
-	return written, nil
-}
-
-func randomString(rng *rand.Rand, length int) string {
-	buf := make([]byte, length)
-	for i := range buf {
-		buf[i] = alphabet[rng.Intn(len(alphabet))]
-	}
-	return string(buf)
-}
-
 func parseSize(raw string) (int64, error) {
 	raw = strings.TrimSpace(strings.ToUpper(raw))
 	ordered := []struct {
@@ -1,10 +1,6 @@
 package main
 
-import (
-	"math/rand"
-	"strings"
-	"testing"
-)
+import "testing"
 
 func TestParseSize(t *testing.T) {
 	tests := map[string]int64{
@@ -43,14 +39,3 @@ func TestParseTables(t *testing.T) {
 		t.Fatalf("unexpected table list: %#v", tables)
 	}
 }
-
-func TestRandomString(t *testing.T) {
-	rng := rand.New(rand.NewSource(42))
-	s := randomString(rng, 32)
-	if len(s) != 32 {
-		t.Fatalf("expected len 32, got %d", len(s))
-	}
-	if strings.Count(s, string(s[0])) == len(s) {
-		t.Fatalf("string looks non-random: %q", s)
-	}
-}
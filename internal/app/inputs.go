@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+
+	"github.com/d00p1/filtrate-backups/internal/config"
+	"github.com/d00p1/filtrate-backups/internal/discovery"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// selectInputs returns the inputs of one run, oldest first: cfg.Input
+// itself, or the backups found under it that the selection policy picks.
+// The state is nil unless a state file is configured.
+func selectInputs(ctx context.Context, cfg config.Config, backends storage.Backends) ([]storage.Object, *discovery.State, error) {
+	if cfg.InputSelect == "" {
+		return []storage.Object{{Location: cfg.Input}}, nil, nil
+	}
+
+	var state *discovery.State
+	if cfg.StateFile != "" {
+		var err error
+		if state, err = discovery.LoadState(cfg.StateFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	candidates, err := discovery.Find(ctx, backends, cfg.Input)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy := discovery.Policy{Count: cfg.InputCount, Order: cfg.InputOrder, TimeLayout: cfg.InputTimeLayout}
+	return policy.Select(candidates, state), state, nil
+}
+
+// displayLocation hides the password of a location in logs.
+func displayLocation(location string) string {
+	u, err := storage.Parse(location)
+	if err != nil || u.Scheme == "file" || u.Scheme == "stdio" {
+		return location
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/internal/config"
+	"github.com/d00p1/filtrate-backups/internal/outpath"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// outputFor expands the output path of input within the run described by
+// run, which carries Time, RunID and Profile. glob matches the outputs of
+// the same input across runs.
+func outputFor(ctx context.Context, cfg config.Config, backends storage.Backends, input storage.Object, run outpath.Vars) (output, glob string, err error) {
+	if cfg.OutputTemplate.Static() {
+		return cfg.Output, "", nil
+	}
+	vars := outpath.InputVars(input.Location)
+	vars.Time, vars.RunID, vars.Profile = run.Time, run.RunID, run.Profile
+	vars.InputTime = input.ModTime
+	if vars.InputTime.IsZero() && input.Location != storage.StdioPath {
+		// a fixed input was not listed; the lookup is best effort
+		if info, err := backends.Stat(ctx, input.Location); err == nil {
+			vars.InputTime = info.ModTime
+		}
+	}
+	if output, err = cfg.OutputTemplate.Expand(vars); err != nil {
+		return "", "", err
+	}
+	if glob, err = cfg.OutputTemplate.Glob(vars, cfg.InputTimeLayout); err != nil {
+		return "", "", err
+	}
+	return output, glob, nil
+}
+
+// prune applies the retention policy to the outputs in the directory of
+// output whose names match the last element of glob. output itself, just
+// written, is always kept.
+func prune(ctx context.Context, cfg config.Config, backends storage.Backends, logw io.Writer, output, glob string) error {
+	dir := outputDir(output)
+	objects, err := backends.List(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	pattern, current := path.Base(glob), path.Base(output)
+	var outputs []storage.Object
+	for _, obj := range objects {
+		if ok, _ := path.Match(pattern, obj.Name); ok {
+			outputs = append(outputs, obj)
+		}
+	}
+
+	keep, drop := cfg.Retention.Plan(outputs, time.Now())
+	verb := "pruned"
+	if cfg.RetentionDryRun {
+		verb = "would prune"
+	}
+	var errs []error
+	pruned := 0
+	for _, obj := range drop {
+		if obj.Name == current {
+			keep = append(keep, obj)
+			continue
+		}
+		if !cfg.RetentionDryRun {
+			if err := backends.Remove(ctx, obj.Location); err != nil {
+				errs = append(errs, fmt.Errorf("retention: %w", err))
+				continue
+			}
+		}
+		pruned++
+		fmt.Fprintf(logw, "🧹 %s: %s\n", verb, displayLocation(obj.Location))
+	}
+	fmt.Fprintf(logw, "🧹 retention: kept %d, %s %d in %s\n", len(keep), verb, pruned, displayLocation(dir))
+	return errors.Join(errs...)
+}
+
+// outputDir returns the directory or prefix holding output: "." for a bare
+// relative name, and the bucket for an object at its root.
+func outputDir(output string) string {
+	i := strings.LastIndex(output, "/")
+	switch {
+	case i < 0:
+		return "."
+	case i == 0 || strings.HasSuffix(output[:i], "://"):
+		return output[:i+1]
+	}
+	return output[:i]
+}
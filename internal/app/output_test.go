@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/internal/config"
+	"github.com/d00p1/filtrate-backups/internal/outpath"
+	"github.com/d00p1/filtrate-backups/internal/retention"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+func retentionConfig(t *testing.T, output string, policy retention.Policy) config.Config {
+	t.Helper()
+	tmpl, err := outpath.Parse(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Config{Output: output, OutputTemplate: tmpl, Retention: policy, Profile: "default"}
+}
+
+func writeOutput(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("filtered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// Daily backups carry their date in the name; their outputs still form one
+// series, so that retention prunes across days.
+func TestPruneSeriesOfDailyInputs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cfg := retentionConfig(t, filepath.Join(dir, "{{.InputBase}}.clean.tar.gz"), retention.Policy{Last: 2})
+	backends := storage.Default()
+	writeOutput(t, filepath.Join(dir, "other-2026-10-01.clean.tar.gz"), time.Now().Add(-30*24*time.Hour))
+
+	start := time.Now().Add(-5 * 24 * time.Hour)
+	for day := range 5 {
+		at := start.Add(time.Duration(day) * 24 * time.Hour)
+		input := storage.Object{Location: "/backups/db-" + at.Format("2006-01-02T15-04") + ".tar.gz"}
+		output, glob, err := outputFor(ctx, cfg, backends, input, outpath.Vars{Time: at, RunID: "r", Profile: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeOutput(t, output, at)
+		if err := prune(ctx, cfg, backends, io.Discard, output, glob); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"db-" + start.Add(3*24*time.Hour).Format("2006-01-02T15-04") + ".clean.tar.gz",
+		"db-" + start.Add(4*24*time.Hour).Format("2006-01-02T15-04") + ".clean.tar.gz",
+		"other-2026-10-01.clean.tar.gz",
+	}
+	if got := dirNames(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("kept %v, want %v", got, want)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	for output, want := range map[string]string{
+		"s3://bucket/x.tar.gz":       "s3://bucket",
+		"s3://bucket/daily/x.tar.gz": "s3://bucket/daily",
+		"file:///x.tar.gz":           "file:///",
+		"/x.tar.gz":                  "/",
+		"/srv/out/x.tar.gz":          "/srv/out",
+		"x.tar.gz":                   ".",
+		"./x.tar.gz":                 ".",
+		"out/x.tar.gz":               "out",
+		"sftp://backup@host/x/y.sql": "sftp://backup@host/x",
+	} {
+		if got := outputDir(output); got != want {
+			t.Errorf("outputDir(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name     string
+		relative bool
+		policy   retention.Policy
+		dryRun   bool
+		current  string
+		kept     []string
+		log      []string
+	}{
+		{
+			name:    "prunes beyond the newest",
+			policy:  retention.Policy{Last: 2},
+			current: "db-4.sql",
+			kept:    []string{"db-3.sql", "db-4.sql", "orders-1.sql"},
+			log:     []string{"🧹 pruned: {dir}/db-2.sql", "🧹 pruned: {dir}/db-1.sql", "🧹 retention: kept 2, pruned 2 in {dir}"},
+		},
+		{
+			name:    "never prunes the current output",
+			policy:  retention.Policy{Last: 1},
+			current: "db-1.sql",
+			kept:    []string{"db-1.sql", "db-4.sql", "orders-1.sql"},
+			log:     []string{"🧹 pruned: {dir}/db-3.sql", "🧹 pruned: {dir}/db-2.sql", "🧹 retention: kept 2, pruned 2 in {dir}"},
+		},
+		{
+			name:    "dry run reports without removing",
+			policy:  retention.Policy{Last: 3},
+			dryRun:  true,
+			current: "db-4.sql",
+			kept:    []string{"db-1.sql", "db-2.sql", "db-3.sql", "db-4.sql", "orders-1.sql"},
+			log:     []string{"🧹 would prune: {dir}/db-1.sql", "🧹 retention: kept 3, would prune 1 in {dir}"},
+		},
+		{
+			name:     "relative output",
+			relative: true,
+			policy:   retention.Policy{Within: 36 * time.Hour},
+			current:  "db-4.sql",
+			kept:     []string{"db-3.sql", "db-4.sql", "orders-1.sql"},
+			log:      []string{"🧹 pruned: {dir}/db-2.sql", "🧹 pruned: {dir}/db-1.sql", "🧹 retention: kept 2, pruned 2 in {dir}"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for i, name := range []string{"db-1.sql", "db-2.sql", "db-3.sql", "db-4.sql", "orders-1.sql"} {
+				// db-4 is an hour old, db-3 a day older, and so on
+				writeOutput(t, filepath.Join(dir, name), now.Add(-time.Duration(3-i%4)*24*time.Hour-time.Hour))
+			}
+			base := dir
+			if tc.relative {
+				t.Chdir(filepath.Dir(dir))
+				base = filepath.Base(dir)
+			}
+			cfg := retentionConfig(t, base+"/db-{{.RunID}}.sql", tc.policy)
+			cfg.RetentionDryRun = tc.dryRun
+
+			var log strings.Builder
+			if err := prune(context.Background(), cfg, storage.Default(), &log, base+"/"+tc.current, base+"/db-*.sql"); err != nil {
+				t.Fatal(err)
+			}
+			if got := dirNames(t, dir); !slices.Equal(got, tc.kept) {
+				t.Errorf("kept %v, want %v", got, tc.kept)
+			}
+			var want []string
+			for _, line := range tc.log {
+				want = append(want, strings.ReplaceAll(line, "{dir}", base))
+			}
+			if got := strings.Split(strings.TrimSuffix(log.String(), "\n"), "\n"); !slices.Equal(got, want) {
+				t.Errorf("log %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -3,10 +3,15 @@ package app
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/d00p1/filtrate-backups/internal/config"
+	"github.com/d00p1/filtrate-backups/internal/outpath"
 	"github.com/d00p1/filtrate-backups/internal/pipeline"
+	"github.com/d00p1/filtrate-backups/pkg/archive"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
 )
 
 func Run(ctx context.Context, args []string) error {
@@ -14,21 +19,109 @@ func Run(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	backends, err := storageBackends(ctx, cfg)
+	if err != nil {
+		return err
+	}
 
-	runOnce := func() error {
-		result, err := pipeline.Run(pipeline.Options{
-			InputPath:    cfg.Input,
-			OutputPath:   cfg.Output,
-			TablesSkip:   cfg.TablesSkip,
-			TmpDir:       cfg.TmpDir,
-			MaxLineBytes: cfg.MaxLineBytes,
+	// stdout may carry the output itself
+	var logw io.Writer = os.Stdout
+	if cfg.Output == storage.StdioPath {
+		logw = os.Stderr
+	}
+
+	// runInput processes one input and returns, besides the result, the glob
+	// matching the outputs of the same input in earlier runs.
+	runInput := func(input storage.Object, run outpath.Vars) (pipeline.Result, string, error) {
+		output, glob, err := outputFor(ctx, cfg, backends, input, run)
+		if err != nil {
+			return pipeline.Result{}, "", err
+		}
+		result, err := pipeline.Run(ctx, pipeline.Options{
+			InputPath:     input.Location,
+			OutputPath:    output,
+			Overwrite:     cfg.Overwrite,
+			Storage:       backends,
+			InputChecksum: cfg.InputChecksum,
+			TablesSkip:    cfg.TablesSkip,
+			TablesKeep:    cfg.TablesKeep,
+			TmpDir:        cfg.TmpDir,
+			MaxLineBytes:  cfg.MaxLineBytes,
+			Masks:         cfg.Masks,
+			Samples:       cfg.Samples,
+			RuleSets:      cfg.RuleSets,
+			MaskKey:       []byte(cfg.MaskSecret),
+			Dialect:       cfg.Dialect,
+
+			InputCompression:  cfg.InputCompression,
+			OutputCompression: cfg.OutputCompression,
+			CompressionLevel:  cfg.CompressionLevel,
+			OutputContainer:   cfg.OutputContainer,
+			Layout:            cfg.Layout,
+			ArchiveLimits: archive.Limits{
+				MaxBytes:   cfg.ArchiveMaxBytes,
+				MaxEntries: cfg.ArchiveMaxEntries,
+			},
+			SQLGlobs:      cfg.SQLGlobs,
+			Deterministic: cfg.Deterministic,
+			ModTime:       cfg.MTime,
 		})
 		if err != nil {
-			return err
+			return result, "", err
 		}
 
-		fmt.Printf("✅ filtered lines: %d/%d\n", result.FilteredLines, result.TotalLines)
-		fmt.Printf("✅ output: %s\n", result.OutputPath)
+		fmt.Fprintf(logw, "✅ filtered lines: %d/%d\n", result.FilteredLines, result.TotalLines)
+		if result.MaskedValues > 0 {
+			fmt.Fprintf(logw, "✅ masked values: %d\n", result.MaskedValues)
+		}
+		if result.DroppedFiles > 0 {
+			fmt.Fprintf(logw, "✅ dropped files: %d\n", result.DroppedFiles)
+		}
+		for _, w := range result.Warnings {
+			fmt.Fprintf(logw, "⚠️ %s\n", w)
+		}
+		fmt.Fprintf(logw, "✅ output: %s\n", result.OutputPath)
+		return result, glob, nil
+	}
+
+	runOnce := func() error {
+		now := time.Now().UTC()
+		run := outpath.Vars{Time: now, RunID: outpath.NewRunID(now), Profile: cfg.Profile}
+		inputs, state, err := selectInputs(ctx, cfg, backends)
+		if err != nil {
+			return err
+		}
+		// retention runs once the input is recorded as processed, so that a
+		// failed prune never causes it to be processed again
+		retain := func(result pipeline.Result, glob string) error {
+			if !cfg.Retention.Enabled() {
+				return nil
+			}
+			return prune(ctx, cfg, backends, logw, result.OutputPath, glob)
+		}
+		if cfg.InputSelect == "" {
+			result, glob, err := runInput(inputs[0], run)
+			if err != nil {
+				return err
+			}
+			return retain(result, glob)
+		}
+		fmt.Fprintf(logw, "🔎 %d new input(s) in %s\n", len(inputs), displayLocation(cfg.Input))
+		// oldest first; stop at the first failure, so the next run retries
+		// it before anything newer
+		for _, input := range inputs {
+			fmt.Fprintf(logw, "📦 input: %s\n", displayLocation(input.Location))
+			result, glob, err := runInput(input, run)
+			if err != nil {
+				return fmt.Errorf("%s: %w", displayLocation(input.Location), err)
+			}
+			if err := state.Mark(input, result.OutputPath, time.Now()); err != nil {
+				return err
+			}
+			if err := retain(result, glob); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 
@@ -49,7 +142,7 @@ func Run(ctx context.Context, args []string) error {
 			return nil
 		case <-ticker.C:
 			if err := runOnce(); err != nil {
-				fmt.Printf("run failed: %v\n", err)
+				fmt.Fprintf(logw, "run failed: %v\n", err)
 			}
 		}
 	}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+
+	"github.com/d00p1/filtrate-backups/internal/config"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// storageBackends returns the backends inputs and outputs may live on.
+func storageBackends(ctx context.Context, cfg config.Config) (storage.Backends, error) {
+	s3, err := storage.NewS3(ctx, storage.S3Options{
+		Endpoint:        cfg.S3Endpoint,
+		Region:          cfg.S3Region,
+		PathStyle:       cfg.S3PathStyle,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+		SessionToken:    cfg.S3SessionToken,
+		PartSize:        cfg.S3PartSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	web := storage.NewHTTP(storage.HTTPOptions{Header: cfg.HTTPHeader, Retries: cfg.HTTPRetries})
+	backends := storage.Default()
+	backends["s3"] = s3
+	backends["http"], backends["https"] = web, web
+	backends["sftp"] = storage.NewSFTP(storage.SFTPOptions{
+		User:           cfg.SFTPUser,
+		KeyFile:        cfg.SFTPKeyFile,
+		KeyPassphrase:  cfg.SFTPKeyPassphrase,
+		Password:       cfg.SFTPPassword,
+		KnownHostsFile: cfg.SFTPKnownHosts,
+	})
+	backends["gs"] = storage.NewGCS(storage.GCSOptions{
+		Endpoint:        cfg.GCSEndpoint,
+		CredentialsFile: cfg.GCSCredentialsFile,
+		Anonymous:       cfg.GCSAnonymous,
+		ChunkSize:       cfg.GCSChunkSize,
+	})
+	backends["azblob"] = storage.NewAzure(storage.AzureOptions{
+		Account:          cfg.AzureAccount,
+		Key:              cfg.AzureKey,
+		ConnectionString: cfg.AzureConnectionString,
+		Endpoint:         cfg.AzureEndpoint,
+		BlockSize:        cfg.AzureBlockSize,
+	})
+	return backends, nil
+}
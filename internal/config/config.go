@@ -4,11 +4,23 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/d00p1/filtrate-backups/internal/discovery"
+	"github.com/d00p1/filtrate-backups/internal/dump"
+	"github.com/d00p1/filtrate-backups/internal/filter"
+	"github.com/d00p1/filtrate-backups/internal/outpath"
+	"github.com/d00p1/filtrate-backups/internal/retention"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
 	"github.com/joho/godotenv"
 )
 
@@ -16,11 +28,98 @@ type Config struct {
 	Input            string
 	Output           string
 	TablesSkipRaw    string
+	TablesKeepRaw    string
 	TmpDir           string
 	MaxLineBytes     int
 	ScheduleInterval time.Duration
 	Mode             string
 	TablesSkip       []string
+	TablesKeep       []string
+
+	// InputSelect turns Input into a glob, directory or prefix searched
+	// for backups; empty reads Input itself.
+	InputSelect     string
+	InputCount      int
+	InputOrder      string
+	InputTimeLayout string
+	StateFile       string
+
+	// OutputTemplate is Output parsed as an output path template.
+	OutputTemplate *outpath.Template
+	Overwrite      bool
+	Profile        string
+
+	// Retention prunes the outputs of earlier runs after a successful run.
+	Retention       retention.Policy
+	RetainWithinRaw string
+	RetentionDryRun bool
+
+	InputCompression  string
+	OutputCompression string
+	CompressionLevel  int
+	OutputContainer   string
+	Layout            string
+	Dialect           string
+
+	ArchiveMaxBytes   int64
+	ArchiveMaxEntries int
+
+	S3Endpoint        string
+	S3Region          string
+	S3PathStyle       bool
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3SessionToken    string
+	S3PartSize        int64
+
+	SFTPUser          string
+	SFTPKeyFile       string
+	SFTPKeyPassphrase string
+	SFTPPassword      string
+	SFTPKnownHosts    string
+
+	GCSEndpoint        string
+	GCSCredentialsFile string
+	GCSAnonymous       bool
+	GCSChunkSize       int
+
+	AzureAccount          string
+	AzureKey              string
+	AzureConnectionString string
+	AzureEndpoint         string
+	AzureBlockSize        int64
+
+	InputChecksum string
+	HTTPHeader    http.Header
+	HTTPRetries   int
+
+	SQLGlobsRaw string
+	SQLGlobs    []string
+
+	Deterministic bool
+	MTimeRaw      string
+	MTime         time.Time
+
+	MaskRaw   string
+	Masks     []filter.Mask
+	SampleRaw string
+	Samples   []filter.Sample
+	// MaskSecret keys the hash mask strategy. It is read from the config
+	// file or the environment only, never from flags.
+	MaskSecret string
+	RuleSets   []dump.RuleSet
+
+	ruleSets    map[string]*ruleSetValues
+	ruleSetErrs []error
+}
+
+// ruleSetValues holds the raw RULESET_<NAME>_* keys of one rule set.
+type ruleSetValues struct {
+	Paths  string
+	Skip   string
+	Keep   string
+	Sample string
+	Mask   string
 }
 
 type bootstrapOptions struct {
@@ -60,7 +159,33 @@ func Load(args []string) (Config, error) {
 	}
 
 	cfg.TablesSkip = splitPatterns(cfg.TablesSkipRaw)
-	if err := validate(cfg); err != nil {
+	cfg.TablesKeep = splitPatterns(cfg.TablesKeepRaw)
+	cfg.SQLGlobs = splitPatterns(cfg.SQLGlobsRaw)
+	if cfg.InputSelect == "" && discovery.IsPattern(cfg.Input) {
+		cfg.InputSelect = discovery.SelectNewest
+	}
+	if cfg.InputSelect != "" {
+		if cfg.InputCount, err = discovery.ParseSelect(cfg.InputSelect); err != nil {
+			return Config{}, fmt.Errorf("invalid INPUT_SELECT: %w", err)
+		}
+	}
+	if cfg.Profile == "" {
+		cfg.Profile = profileName(boot.ConfigPath)
+	}
+	if cfg.OutputTemplate, err = outpath.Parse(cfg.Output); err != nil {
+		return Config{}, fmt.Errorf("invalid OUTPUT_FILE template: %w", err)
+	}
+	if cfg.RetainWithinRaw != "" {
+		if cfg.Retention.Within, err = retention.ParseDuration(cfg.RetainWithinRaw); err != nil {
+			return Config{}, fmt.Errorf("invalid RETAIN_WITHIN: %w", err)
+		}
+	}
+	if cfg.MTimeRaw != "" {
+		if cfg.MTime, err = parseTimestamp(cfg.MTimeRaw); err != nil {
+			return Config{}, fmt.Errorf("invalid DETERMINISTIC_MTIME %q: %w", cfg.MTimeRaw, err)
+		}
+	}
+	if err := errors.Join(cfg.resolveRules(), validate(cfg)); err != nil {
 		return Config{}, err
 	}
 
@@ -107,13 +232,74 @@ func parseBootstrap(args []string) (bootstrapOptions, error) {
 
 func applyCLIOverrides(args []string, cfg *Config) error {
 	fs := flag.NewFlagSet("mysql-dump-cleaner", flag.ContinueOnError)
-	fs.StringVar(&cfg.Input, "input", cfg.Input, "input dump path")
-	fs.StringVar(&cfg.Output, "output", cfg.Output, "output archive path")
+	fs.StringVar(&cfg.Input, "input", cfg.Input, "input dump path or URL, e.g. s3://bucket/key; - for stdin")
+	fs.StringVar(&cfg.Output, "output", cfg.Output, "output archive path or URL, e.g. s3://bucket/key; - for stdout")
+	fs.StringVar(&cfg.InputSelect, "input-select", cfg.InputSelect, "pick inputs from a glob, directory or prefix: newest, newest:N or all; default newest for globs")
+	fs.StringVar(&cfg.InputOrder, "input-order", cfg.InputOrder, "rank discovered inputs by mtime or by the timestamp in their name")
+	fs.StringVar(&cfg.InputTimeLayout, "input-time-layout", cfg.InputTimeLayout, "Go time layout of the timestamp in input names, e.g. 2006-01-02T15-04; empty detects common forms")
+	fs.StringVar(&cfg.StateFile, "state-file", cfg.StateFile, "JSON file recording processed inputs, which are skipped later")
+	fs.BoolVar(&cfg.Overwrite, "overwrite", cfg.Overwrite, "replace an existing output instead of failing the run")
+	fs.StringVar(&cfg.Profile, "profile", cfg.Profile, "name of this job in output templates; defaults to the config file name")
+	fs.IntVar(&cfg.Retention.Last, "retain-last", cfg.Retention.Last, "keep the N newest outputs, pruning older ones after each run")
+	fs.StringVar(&cfg.RetainWithinRaw, "retain-within", cfg.RetainWithinRaw, "keep outputs younger than this, e.g. 36h, 30d or 2w")
+	fs.IntVar(&cfg.Retention.Daily, "retain-daily", cfg.Retention.Daily, "keep the newest output of each of the last N days")
+	fs.IntVar(&cfg.Retention.Weekly, "retain-weekly", cfg.Retention.Weekly, "keep the newest output of each of the last N ISO weeks")
+	fs.IntVar(&cfg.Retention.Monthly, "retain-monthly", cfg.Retention.Monthly, "keep the newest output of each of the last N months")
+	fs.BoolVar(&cfg.RetentionDryRun, "retention-dry-run", cfg.RetentionDryRun, "report the outputs retention would prune without deleting them")
 	fs.StringVar(&cfg.TablesSkipRaw, "skip", cfg.TablesSkipRaw, "colon-separated regex list of tables to remove")
+	fs.StringVar(&cfg.TablesKeepRaw, "keep", cfg.TablesKeepRaw, "colon-separated regex list of the only tables whose data is kept")
+	fs.StringVar(&cfg.SQLGlobsRaw, "sql-glob", cfg.SQLGlobsRaw, "colon-separated globs of archive entries to filter; others are copied as is")
 	fs.StringVar(&cfg.TmpDir, "tmp-dir", cfg.TmpDir, "tmp directory")
-	fs.IntVar(&cfg.MaxLineBytes, "max-line-bytes", cfg.MaxLineBytes, "max bytes per SQL line")
+	fs.IntVar(&cfg.MaxLineBytes, "max-line-bytes", cfg.MaxLineBytes, "max bytes of a statement buffered by full-row transforms")
 	fs.DurationVar(&cfg.ScheduleInterval, "every", cfg.ScheduleInterval, "run as scheduler with interval, e.g. 30m")
 	fs.StringVar(&cfg.Mode, "mode", cfg.Mode, "run mode: once or schedule")
+	fs.StringVar(&cfg.InputCompression, "input-compression", cfg.InputCompression, "input codec: auto, "+strings.Join(codec.Names(), ", "))
+	fs.StringVar(&cfg.OutputCompression, "output-compression", cfg.OutputCompression, "output codec: auto (by output extension), "+strings.Join(codec.Names(), ", "))
+	fs.IntVar(&cfg.CompressionLevel, "compression-level", cfg.CompressionLevel, "output compression level, 0 for codec default")
+	fs.Int64Var(&cfg.ArchiveMaxBytes, "archive-max-bytes", cfg.ArchiveMaxBytes, "max total bytes extracted from an input archive, 0 for unlimited")
+	fs.IntVar(&cfg.ArchiveMaxEntries, "archive-max-entries", cfg.ArchiveMaxEntries, "max entries in an input archive, 0 for unlimited")
+	fs.StringVar(&cfg.OutputContainer, "output-container", cfg.OutputContainer, "output container: auto (mirror input), tar, zip, sql or dir")
+	fs.StringVar(&cfg.Layout, "layout", cfg.Layout, "dump layout: auto, generic, mydumper or mysqlsh")
+	fs.StringVar(&cfg.Dialect, "dialect", cfg.Dialect, "SQL dialect: auto (by dump header), mysql, postgres, sqlite or binlog")
+	fs.StringVar(&cfg.MaskRaw, "mask", cfg.MaskRaw, "colon-separated table.column[=null|empty|redact|hash] masks of the default rule set")
+	fs.StringVar(&cfg.SampleRaw, "sample", cfg.SampleRaw, "colon-separated table=percent row samples of the default rule set, PostgreSQL dumps only")
+	fs.Func("ruleset", "rule set field as NAME.paths|skip|keep|sample|mask=VALUE; repeatable", func(v string) error {
+		name, rest, _ := strings.Cut(v, ".")
+		field, value, ok := strings.Cut(rest, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("want NAME.FIELD=VALUE, got %q", v)
+		}
+		cfg.applyKeyValues(map[string]string{"RULESET_" + name + "_" + field: value})
+		return nil
+	})
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", cfg.S3Endpoint, "S3-compatible endpoint URL, e.g. http://minio:9000; empty for AWS")
+	fs.StringVar(&cfg.S3Region, "s3-region", cfg.S3Region, "S3 region")
+	fs.BoolVar(&cfg.S3PathStyle, "s3-path-style", cfg.S3PathStyle, "address S3 objects as endpoint/bucket/key, as MinIO expects")
+	fs.StringVar(&cfg.S3AccessKeyID, "s3-access-key-id", cfg.S3AccessKeyID, "S3 access key id; empty uses the AWS credential chain")
+	fs.StringVar(&cfg.S3SecretAccessKey, "s3-secret-access-key", cfg.S3SecretAccessKey, "S3 secret access key")
+	fs.Int64Var(&cfg.S3PartSize, "s3-part-size", cfg.S3PartSize, "S3 multipart upload part size in bytes, 0 for the default")
+	fs.StringVar(&cfg.SFTPUser, "sftp-user", cfg.SFTPUser, "SFTP user when the URL names none")
+	fs.StringVar(&cfg.SFTPKeyFile, "sftp-key-file", cfg.SFTPKeyFile, "SFTP private key file")
+	fs.StringVar(&cfg.SFTPKnownHosts, "sftp-known-hosts", cfg.SFTPKnownHosts, "known_hosts file verifying SFTP host keys, default ~/.ssh/known_hosts")
+	fs.StringVar(&cfg.GCSEndpoint, "gcs-endpoint", cfg.GCSEndpoint, "GCS JSON API endpoint, e.g. http://localhost:4443/storage/v1/ for fake-gcs-server")
+	fs.StringVar(&cfg.GCSCredentialsFile, "gcs-credentials-file", cfg.GCSCredentialsFile, "GCS service account key file; empty uses Application Default Credentials")
+	fs.BoolVar(&cfg.GCSAnonymous, "gcs-anonymous", cfg.GCSAnonymous, "send no GCS credentials, for emulators and public buckets")
+	fs.IntVar(&cfg.GCSChunkSize, "gcs-chunk-size", cfg.GCSChunkSize, "GCS resumable upload chunk size in bytes, 0 for the default")
+	fs.StringVar(&cfg.AzureAccount, "azure-account", cfg.AzureAccount, "Azure storage account name")
+	fs.StringVar(&cfg.AzureEndpoint, "azure-endpoint", cfg.AzureEndpoint, "Azure blob service URL, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite")
+	fs.Int64Var(&cfg.AzureBlockSize, "azure-block-size", cfg.AzureBlockSize, "Azure upload block size in bytes, 0 for the default")
+	fs.StringVar(&cfg.InputChecksum, "input-checksum", cfg.InputChecksum, "expected checksum of the raw input as algorithm:hex, e.g. sha256:...")
+	fs.Func("http-header", "header sent with HTTP(S) input requests as 'Name: value'; repeatable", func(v string) error {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("want 'Name: value', got %q", v)
+		}
+		cfg.setHTTPHeader(name, value)
+		return nil
+	})
+	fs.IntVar(&cfg.HTTPRetries, "http-retries", cfg.HTTPRetries, "reconnects of an HTTP(S) download, each resuming where the last one dropped")
+	fs.BoolVar(&cfg.Deterministic, "deterministic", cfg.Deterministic, "write byte-identical archives for identical input")
+	fs.StringVar(&cfg.MTimeRaw, "mtime", cfg.MTimeRaw, "timestamp for deterministic entries, unix seconds or RFC 3339; empty derives it from the input")
 
 	var ignored string
 	fs.StringVar(&ignored, "config", "", "")
@@ -135,8 +321,52 @@ func (cfg *Config) applyKeyValues(values map[string]string) {
 			if value != "" {
 				cfg.Output = value
 			}
+		case "OVERWRITE":
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				cfg.Overwrite = parsed
+			}
+		case "PROFILE":
+			cfg.Profile = strings.TrimSpace(value)
+		case "RETAIN_LAST", "KEEP_LAST":
+			if parsed, err := parseInt(value); err == nil && parsed >= 0 {
+				cfg.Retention.Last = parsed
+			}
+		case "RETAIN_WITHIN", "KEEP_WITHIN":
+			cfg.RetainWithinRaw = strings.TrimSpace(value)
+		case "RETAIN_DAILY":
+			if parsed, err := parseInt(value); err == nil && parsed >= 0 {
+				cfg.Retention.Daily = parsed
+			}
+		case "RETAIN_WEEKLY":
+			if parsed, err := parseInt(value); err == nil && parsed >= 0 {
+				cfg.Retention.Weekly = parsed
+			}
+		case "RETAIN_MONTHLY":
+			if parsed, err := parseInt(value); err == nil && parsed >= 0 {
+				cfg.Retention.Monthly = parsed
+			}
+		case "RETENTION_DRY_RUN":
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				cfg.RetentionDryRun = parsed
+			}
+		case "INPUT_SELECT":
+			cfg.InputSelect = strings.ToLower(strings.TrimSpace(value))
+		case "INPUT_ORDER":
+			if value != "" {
+				cfg.InputOrder = strings.ToLower(strings.TrimSpace(value))
+			}
+		case "INPUT_TIME_LAYOUT":
+			cfg.InputTimeLayout = strings.TrimSpace(value)
+		case "INPUT_STATE_FILE", "STATE_FILE":
+			cfg.StateFile = strings.TrimSpace(value)
 		case "TABLE_MAP", "TABLES_SKIP", "SKIP", "SKIP_TABLES":
 			cfg.TablesSkipRaw = normalizePatterns(value)
+		case "TABLE_KEEP", "TABLES_KEEP", "KEEP", "KEEP_TABLES":
+			cfg.TablesKeepRaw = normalizePatterns(value)
+		case "SQL_GLOB", "SQL_GLOBS":
+			if value != "" {
+				cfg.SQLGlobsRaw = normalizePatterns(value)
+			}
 		case "TMP_DIR", "TMPDIR":
 			if value != "" {
 				cfg.TmpDir = value
@@ -153,8 +383,262 @@ func (cfg *Config) applyKeyValues(values map[string]string) {
 			if d, err := time.ParseDuration(value); err == nil {
 				cfg.ScheduleInterval = d
 			}
+		case "INPUT_COMPRESSION":
+			if value != "" {
+				cfg.InputCompression = strings.ToLower(value)
+			}
+		case "OUTPUT_COMPRESSION":
+			if value != "" {
+				cfg.OutputCompression = strings.ToLower(value)
+			}
+		case "COMPRESSION_LEVEL", "OUTPUT_COMPRESSION_LEVEL":
+			if parsed, err := parseInt(value); err == nil {
+				cfg.CompressionLevel = parsed
+			}
+		case "ARCHIVE_MAX_BYTES":
+			if parsed, err := parseInt64(value); err == nil && parsed >= 0 {
+				cfg.ArchiveMaxBytes = parsed
+			}
+		case "ARCHIVE_MAX_ENTRIES":
+			if parsed, err := parseInt(value); err == nil && parsed >= 0 {
+				cfg.ArchiveMaxEntries = parsed
+			}
+		case "OUTPUT_CONTAINER":
+			if value != "" {
+				cfg.OutputContainer = strings.ToLower(value)
+			}
+		case "LAYOUT", "DUMP_LAYOUT":
+			if value != "" {
+				cfg.Layout = strings.ToLower(value)
+			}
+		case "DIALECT", "SQL_DIALECT":
+			if value != "" {
+				cfg.Dialect = strings.ToLower(value)
+			}
+		case "S3_ENDPOINT", "S3_ENDPOINT_URL":
+			cfg.S3Endpoint = strings.TrimSpace(value)
+		case "S3_REGION":
+			cfg.S3Region = strings.TrimSpace(value)
+		case "S3_PATH_STYLE", "S3_FORCE_PATH_STYLE":
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				cfg.S3PathStyle = parsed
+			}
+		case "S3_ACCESS_KEY_ID":
+			cfg.S3AccessKeyID = strings.TrimSpace(value)
+		case "S3_SECRET_ACCESS_KEY":
+			cfg.S3SecretAccessKey = strings.TrimSpace(value)
+		case "S3_SESSION_TOKEN":
+			cfg.S3SessionToken = strings.TrimSpace(value)
+		case "S3_PART_SIZE":
+			if parsed, err := parseInt64(value); err == nil {
+				cfg.S3PartSize = parsed
+			}
+		case "SFTP_USER":
+			cfg.SFTPUser = strings.TrimSpace(value)
+		case "SFTP_KEY_FILE", "SFTP_IDENTITY_FILE":
+			cfg.SFTPKeyFile = strings.TrimSpace(value)
+		case "SFTP_KEY_PASSPHRASE":
+			cfg.SFTPKeyPassphrase = value
+		case "SFTP_PASSWORD":
+			cfg.SFTPPassword = value
+		case "MASK_SECRET":
+			cfg.MaskSecret = value
+		case "SFTP_KNOWN_HOSTS":
+			cfg.SFTPKnownHosts = strings.TrimSpace(value)
+		case "GCS_ENDPOINT":
+			cfg.GCSEndpoint = strings.TrimSpace(value)
+		case "GCS_CREDENTIALS_FILE":
+			cfg.GCSCredentialsFile = strings.TrimSpace(value)
+		case "GCS_ANONYMOUS":
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				cfg.GCSAnonymous = parsed
+			}
+		case "GCS_CHUNK_SIZE":
+			if parsed, err := parseInt(value); err == nil {
+				cfg.GCSChunkSize = parsed
+			}
+		case "AZURE_STORAGE_ACCOUNT":
+			cfg.AzureAccount = strings.TrimSpace(value)
+		case "AZURE_STORAGE_KEY":
+			cfg.AzureKey = strings.TrimSpace(value)
+		case "AZURE_STORAGE_CONNECTION_STRING":
+			cfg.AzureConnectionString = strings.TrimSpace(value)
+		case "AZURE_BLOCK_SIZE":
+			if parsed, err := parseInt64(value); err == nil {
+				cfg.AzureBlockSize = parsed
+			}
+		case "AZURE_STORAGE_ENDPOINT":
+			cfg.AzureEndpoint = strings.TrimSpace(value)
+		case "INPUT_CHECKSUM", "CHECKSUM":
+			cfg.InputChecksum = strings.TrimSpace(value)
+		case "HTTP_RETRIES":
+			if parsed, err := parseInt(value); err == nil && parsed >= 0 {
+				cfg.HTTPRetries = parsed
+			}
+		case "DETERMINISTIC":
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+				cfg.Deterministic = parsed
+			}
+		case "DETERMINISTIC_MTIME":
+			cfg.MTimeRaw = strings.TrimSpace(value)
+		case "SOURCE_DATE_EPOCH":
+			// the reproducible-builds convention; an explicit mtime wins
+			if cfg.MTimeRaw == "" {
+				cfg.MTimeRaw = strings.TrimSpace(value)
+			}
+		case "MASK", "MASKS":
+			cfg.MaskRaw = normalizePatterns(value)
+		case "SAMPLE", "SAMPLES":
+			cfg.SampleRaw = normalizePatterns(value)
+		default:
+			if strings.HasPrefix(norm, ruleSetPrefix) {
+				cfg.setRuleSetKey(norm, value)
+			} else if name, ok := strings.CutPrefix(norm, httpHeaderPrefix); ok && name != "" {
+				cfg.setHTTPHeader(strings.ReplaceAll(name, "_", "-"), value)
+			}
+		}
+	}
+}
+
+const (
+	ruleSetPrefix    = "RULESET_"
+	httpHeaderPrefix = "HTTP_HEADER_"
+)
+
+// setHTTPHeader replaces one header of HTTP(S) input requests.
+func (cfg *Config) setHTTPHeader(name, value string) {
+	if cfg.HTTPHeader == nil {
+		cfg.HTTPHeader = http.Header{}
+	}
+	cfg.HTTPHeader.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+}
+
+// setRuleSetKey stores one RULESET_<NAME>_PATHS, _SKIP, _KEEP, _SAMPLE or
+// _MASK value.
+func (cfg *Config) setRuleSetKey(key, value string) {
+	rest := strings.TrimPrefix(key, ruleSetPrefix)
+	var name, field string
+	for _, suffix := range []string{"_PATHS", "_SKIP", "_KEEP", "_SAMPLE", "_MASK"} {
+		if n, ok := strings.CutSuffix(rest, suffix); ok && n != "" {
+			name, field = strings.ToLower(n), suffix
+			break
+		}
+	}
+	if name == "" {
+		cfg.ruleSetErrs = append(cfg.ruleSetErrs, fmt.Errorf("%s: rule set keys are RULESET_<NAME>_PATHS, _SKIP, _KEEP, _SAMPLE or _MASK", key))
+		return
+	}
+
+	if cfg.ruleSets == nil {
+		cfg.ruleSets = map[string]*ruleSetValues{}
+	}
+	rs := cfg.ruleSets[name]
+	if rs == nil {
+		rs = &ruleSetValues{}
+		cfg.ruleSets[name] = rs
+	}
+	switch field {
+	case "_PATHS":
+		rs.Paths = normalizePatterns(value)
+	case "_SKIP":
+		rs.Skip = normalizePatterns(value)
+	case "_KEEP":
+		rs.Keep = normalizePatterns(value)
+	case "_SAMPLE":
+		rs.Sample = normalizePatterns(value)
+	case "_MASK":
+		rs.Mask = normalizePatterns(value)
+	}
+}
+
+// resolveRules parses the default masks and samples and the rule sets,
+// which are tried in name order.
+func (cfg *Config) resolveRules() error {
+	allErrs := cfg.ruleSetErrs
+
+	var err error
+	if cfg.Masks, err = parseMasks(cfg.MaskRaw); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("MASK: %w", err))
+	}
+	if cfg.Samples, err = parseSamples(cfg.SampleRaw); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("SAMPLE: %w", err))
+	}
+
+	names := make([]string, 0, len(cfg.ruleSets))
+	for name := range cfg.ruleSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cfg.RuleSets = nil
+	for _, name := range names {
+		raw := cfg.ruleSets[name]
+		key := ruleSetPrefix + strings.ToUpper(name)
+		rs := dump.RuleSet{
+			Name:  name,
+			Paths: splitPatterns(raw.Paths),
+			Rules: filter.Rules{SkipTables: splitPatterns(raw.Skip), KeepTables: splitPatterns(raw.Keep)},
+		}
+		if len(rs.Paths) == 0 {
+			allErrs = append(allErrs, fmt.Errorf("%s_PATHS must name at least one glob", key))
+		}
+		for _, glob := range rs.Paths {
+			if err := dump.ValidateGlob(glob); err != nil {
+				allErrs = append(allErrs, fmt.Errorf("invalid %s_PATHS glob %q: %w", key, glob, err))
+			}
+		}
+		for _, pat := range rs.Rules.SkipTables {
+			if _, err := regexp.Compile(pat); err != nil {
+				allErrs = append(allErrs, fmt.Errorf("invalid %s_SKIP pattern %q: %w", key, pat, err))
+			}
+		}
+		for _, pat := range rs.Rules.KeepTables {
+			if _, err := regexp.Compile(pat); err != nil {
+				allErrs = append(allErrs, fmt.Errorf("invalid %s_KEEP pattern %q: %w", key, pat, err))
+			}
 		}
+		if rs.Rules.Samples, err = parseSamples(raw.Sample); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("%s_SAMPLE: %w", key, err))
+		}
+		if rs.Rules.Masks, err = parseMasks(raw.Mask); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("%s_MASK: %w", key, err))
+		}
+		cfg.RuleSets = append(cfg.RuleSets, rs)
+	}
+
+	return errors.Join(allErrs...)
+}
+
+func parseMasks(raw string) ([]filter.Mask, error) {
+	var (
+		masks []filter.Mask
+		errs  []error
+	)
+	for _, spec := range splitPatterns(raw) {
+		m, err := filter.ParseMask(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		masks = append(masks, m)
 	}
+	return masks, errors.Join(errs...)
+}
+
+func parseSamples(raw string) ([]filter.Sample, error) {
+	var (
+		samples []filter.Sample
+		errs    []error
+	)
+	for _, spec := range splitPatterns(raw) {
+		s, err := filter.ParseSample(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, errors.Join(errs...)
 }
 
 func defaultConfig() Config {
@@ -164,6 +648,16 @@ func defaultConfig() Config {
 		MaxLineBytes:     8 * 1024 * 1024,
 		ScheduleInterval: 0,
 		Mode:             "once",
+		InputOrder:       discovery.OrderMTime,
+
+		InputCompression:  "auto",
+		OutputCompression: "auto",
+		OutputContainer:   "auto",
+		Layout:            dump.LayoutAuto,
+		Dialect:           filter.DialectAuto,
+		HTTPRetries:       5,
+		ArchiveMaxEntries: 1_000_000,
+		SQLGlobsRaw:       dump.DefaultSQLGlob,
 	}
 }
 
@@ -202,24 +696,138 @@ func validate(cfg Config) error {
 	if cfg.Mode != "once" && cfg.Mode != "schedule" {
 		allErrs = append(allErrs, fmt.Errorf("MODE must be once or schedule, got %q", cfg.Mode))
 	}
+	if cfg.ArchiveMaxBytes < 0 || cfg.ArchiveMaxEntries < 0 {
+		allErrs = append(allErrs, errors.New("ARCHIVE_MAX_BYTES and ARCHIVE_MAX_ENTRIES must be >= 0"))
+	}
+	if cfg.S3PartSize != 0 && cfg.S3PartSize < 5*1024*1024 {
+		allErrs = append(allErrs, errors.New("S3_PART_SIZE must be 0 or >= 5 MiB"))
+	}
+	if cfg.GCSChunkSize < 0 {
+		allErrs = append(allErrs, errors.New("GCS_CHUNK_SIZE must be >= 0"))
+	}
+	if cfg.AzureBlockSize < 0 || cfg.AzureBlockSize > 4000*1024*1024 {
+		allErrs = append(allErrs, errors.New("AZURE_BLOCK_SIZE must be between 0 and 4000 MiB"))
+	}
+	if cfg.InputChecksum != "" {
+		if _, _, err := storage.ParseChecksum(cfg.InputChecksum); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("INPUT_CHECKSUM: %w", err))
+		}
+	}
 	if cfg.MaxLineBytes < 1024 {
 		allErrs = append(allErrs, errors.New("MAX_LINE_BYTES must be >= 1024"))
 	}
+	if cfg.Mode == "schedule" && (cfg.Input == storage.StdioPath || cfg.Output == storage.StdioPath) {
+		allErrs = append(allErrs, errors.New("stdin and stdout (\"-\") can only be used with MODE=once"))
+	}
+	if cfg.InputSelect != "" {
+		if cfg.Input == storage.StdioPath || cfg.InputChecksum != "" {
+			allErrs = append(allErrs, errors.New("INPUT_SELECT can't be combined with stdin input or INPUT_CHECKSUM"))
+		}
+		if cfg.Mode == "schedule" && cfg.StateFile == "" {
+			allErrs = append(allErrs, fmt.Errorf("INPUT_SELECT=%s needs INPUT_STATE_FILE in schedule mode, or every run reprocesses the same inputs", cfg.InputSelect))
+		}
+	}
+	if cfg.InputCount != 1 && cfg.InputSelect != "" && cfg.OutputTemplate.Static() {
+		allErrs = append(allErrs, errors.New("OUTPUT_FILE must be a template, e.g. ./output/{{.InputBase}}.tar.gz, when several inputs are selected"))
+	}
+	if cfg.Retention.Last < 0 || cfg.Retention.Within < 0 || cfg.Retention.Daily < 0 || cfg.Retention.Weekly < 0 || cfg.Retention.Monthly < 0 {
+		allErrs = append(allErrs, errors.New("RETAIN_* values must be >= 0"))
+	}
+	if cfg.Retention.Enabled() {
+		if err := validateRetentionOutput(cfg); err != nil {
+			allErrs = append(allErrs, err)
+		}
+	}
+	if err := discovery.ValidateOrder(cfg.InputOrder); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("INPUT_ORDER: %w", err))
+	}
 	if cfg.Mode == "schedule" && cfg.ScheduleInterval <= 0 {
 		allErrs = append(allErrs, errors.New("SCHEDULE_EVERY (or --every) is required for schedule mode"))
 	}
 	if err := ensureDir(cfg.TmpDir); err != nil {
 		allErrs = append(allErrs, fmt.Errorf("TMP_DIR error: %w", err))
 	}
+	switch cfg.OutputContainer {
+	case dump.ContainerAuto, dump.ContainerTar, dump.ContainerZip, dump.ContainerSQL, dump.ContainerDir:
+	default:
+		allErrs = append(allErrs, fmt.Errorf("OUTPUT_CONTAINER must be auto, tar, zip, sql or dir, got %q", cfg.OutputContainer))
+	}
+	if err := dump.ValidateLayout(cfg.Layout); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("LAYOUT: %w", err))
+	}
+	if err := filter.ValidateDialect(cfg.Dialect); err != nil {
+		allErrs = append(allErrs, fmt.Errorf("DIALECT: %w", err))
+	}
+	if cfg.InputCompression != "auto" {
+		if _, err := codec.Lookup(cfg.InputCompression); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("INPUT_COMPRESSION: %w", err))
+		}
+	}
+	if cfg.OutputCompression != "auto" {
+		if c, err := codec.Lookup(cfg.OutputCompression); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("OUTPUT_COMPRESSION: %w", err))
+		} else if !c.CanWrite() {
+			allErrs = append(allErrs, fmt.Errorf("OUTPUT_COMPRESSION: codec %s is decompress-only", c.Name))
+		} else if err := c.ValidateLevel(cfg.CompressionLevel); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("COMPRESSION_LEVEL: %w", err))
+		}
+	}
+	if len(cfg.SQLGlobs) == 0 {
+		allErrs = append(allErrs, errors.New("SQL_GLOB must name at least one glob"))
+	}
+	for _, glob := range cfg.SQLGlobs {
+		if err := dump.ValidateGlob(glob); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("invalid SQL_GLOB %q: %w", glob, err))
+		}
+	}
 	for _, pat := range cfg.TablesSkip {
 		if _, err := regexp.Compile(pat); err != nil {
 			allErrs = append(allErrs, fmt.Errorf("invalid TABLE_MAP pattern %q: %w", pat, err))
 		}
 	}
+	for _, pat := range cfg.TablesKeep {
+		if _, err := regexp.Compile(pat); err != nil {
+			allErrs = append(allErrs, fmt.Errorf("invalid TABLE_KEEP pattern %q: %w", pat, err))
+		}
+	}
 
 	return errors.Join(allErrs...)
 }
 
+// validateRetentionOutput checks that the outputs of different runs share
+// a directory and differ in their file names, which retention lists and
+// matches. The input name stands for a dated backup, whose timestamp varies
+// between runs like the run fields do.
+func validateRetentionOutput(cfg Config) error {
+	if cfg.Output == storage.StdioPath || cfg.OutputContainer == dump.ContainerDir {
+		return errors.New("retention needs file or object outputs, not stdout or directories")
+	}
+	stamp := "2006-01-02"
+	if cfg.InputTimeLayout != "" {
+		stamp = time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC).Format(cfg.InputTimeLayout)
+	}
+	vars := outpath.Vars{InputName: "db-" + stamp + ".sql", InputBase: "db-" + stamp, Profile: cfg.Profile}
+	glob, err := cfg.OutputTemplate.Glob(vars, cfg.InputTimeLayout)
+	if err != nil {
+		return fmt.Errorf("OUTPUT_FILE: %w", err)
+	}
+	dir, name := path.Split(glob)
+	if strings.Contains(dir, "*") || !strings.Contains(name, "*") {
+		return errors.New("retention needs OUTPUT_FILE to vary between runs in its file name only, through the input name or a run field, e.g. ./output/{{.InputBase}}-{{.RunID}}.tar.gz")
+	}
+	return nil
+}
+
+// profileName names a job after its config file, "nightly" for
+// ./nightly.yaml.
+func profileName(configPath string) string {
+	if configPath == "" {
+		return "default"
+	}
+	base := filepath.Base(configPath)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
 func ensureDir(dir string) error {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return err
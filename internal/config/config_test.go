@@ -3,7 +3,13 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/internal/filter"
+	"github.com/d00p1/filtrate-backups/internal/retention"
 )
 
 func TestLoadFromJSONAndEnvOverride(t *testing.T) {
@@ -64,3 +70,214 @@ func TestLoadFromYAMLWithCLIOverride(t *testing.T) {
 		t.Fatalf("cli override failed, got %s", cfg.Input)
 	}
 }
+
+func TestLoadDeterministicMTime(t *testing.T) {
+	t.Setenv("DUMPFILE", "/data/in.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	t.Setenv("DETERMINISTIC", "true")
+	t.Setenv("DETERMINISTIC_MTIME", "")
+	t.Setenv("SOURCE_DATE_EPOCH", "1767225600")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !cfg.Deterministic || cfg.MTime.Unix() != 1767225600 {
+		t.Fatalf("SOURCE_DATE_EPOCH not applied: %v %v", cfg.Deterministic, cfg.MTime)
+	}
+
+	cfg, err = Load([]string{"--mtime", "2026-10-17T03:00:00Z"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if want := "2026-10-17T03:00:00Z"; cfg.MTime.Format(time.RFC3339) != want {
+		t.Fatalf("--mtime should win, got %v", cfg.MTime)
+	}
+
+	if _, err := Load([]string{"--mtime", "yesterday"}); err == nil {
+		t.Fatal("expected an invalid mtime to fail")
+	}
+}
+
+func TestLoadRuleSets(t *testing.T) {
+	t.Setenv("DUMPFILE", "/data/in.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	t.Setenv("MASK", "users.email=hash")
+	t.Setenv("MASK_SECRET", "pepper")
+	t.Setenv("RULESET_ANALYTICS_PATHS", "analytics/*.sql")
+	t.Setenv("RULESET_ANALYTICS_SKIP", ".*")
+
+	cfg, err := Load([]string{"--ruleset", "auth.paths=auth.sql", "--ruleset", "auth.mask=accounts.password=redact"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(cfg.Masks) != 1 || cfg.Masks[0].Strategy != "hash" {
+		t.Fatalf("unexpected default masks: %+v", cfg.Masks)
+	}
+	if cfg.MaskSecret != "pepper" {
+		t.Fatalf("unexpected mask secret %q", cfg.MaskSecret)
+	}
+	if len(cfg.RuleSets) != 2 || cfg.RuleSets[0].Name != "analytics" || cfg.RuleSets[1].Name != "auth" {
+		t.Fatalf("unexpected rule sets: %+v", cfg.RuleSets)
+	}
+	if auth := cfg.RuleSets[1]; auth.Paths[0] != "auth.sql" || auth.Rules.Masks[0].Column != "password" {
+		t.Fatalf("unexpected auth rule set: %+v", auth)
+	}
+
+	if _, err := Load([]string{"--ruleset", "orphan.skip=^tmp_"}); err == nil {
+		t.Fatal("expected a rule set without paths to fail")
+	}
+	t.Setenv("RULESET_AUTH_MASKS", "x.y")
+	if _, err := Load(nil); err == nil {
+		t.Fatal("expected an unknown rule set key to fail")
+	}
+}
+
+func TestLoadKeepAndSamples(t *testing.T) {
+	t.Setenv("DUMPFILE", "/data/in.sql.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	t.Setenv("TABLE_KEEP", "^public\\.:^users$")
+	t.Setenv("RULESET_PG_PATHS", "pg/*.sql")
+	t.Setenv("RULESET_PG_SAMPLE", "events=5%")
+
+	cfg, err := Load([]string{"--sample", "public.logs=10:audit=0.5"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !slices.Equal(cfg.TablesKeep, []string{`^public\.`, "^users$"}) {
+		t.Fatalf("unexpected keep list: %q", cfg.TablesKeep)
+	}
+	if len(cfg.Samples) != 2 || cfg.Samples[0] != (filter.Sample{Table: "public.logs", Percent: 10}) || cfg.Samples[1].Percent != 0.5 {
+		t.Fatalf("unexpected samples: %+v", cfg.Samples)
+	}
+	if pg := cfg.RuleSets[0]; len(pg.Rules.Samples) != 1 || pg.Rules.Samples[0].Percent != 5 {
+		t.Fatalf("unexpected pg rule set: %+v", pg)
+	}
+
+	if _, err := Load([]string{"--sample", "events=200"}); err == nil {
+		t.Fatal("expected a sample above 100% to fail")
+	}
+	if _, err := Load([]string{"--keep", "("}); err == nil {
+		t.Fatal("expected an invalid keep pattern to fail")
+	}
+}
+
+func TestLoadS3Options(t *testing.T) {
+	t.Setenv("DUMPFILE", "s3://backups/in.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	t.Setenv("S3_ENDPOINT", "http://minio:9000")
+	t.Setenv("S3_FORCE_PATH_STYLE", "true")
+	t.Setenv("S3_ACCESS_KEY_ID", "minio")
+
+	cfg, err := Load([]string{"--s3-region", "eu-central-1"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.S3Endpoint != "http://minio:9000" || !cfg.S3PathStyle || cfg.S3AccessKeyID != "minio" || cfg.S3Region != "eu-central-1" {
+		t.Fatalf("S3 options not applied: %+v", cfg)
+	}
+
+	if _, err := Load([]string{"--s3-part-size", "1024"}); err == nil {
+		t.Fatal("expected a part size below 5 MiB to fail")
+	}
+}
+
+func TestLoadCloudUploadSizes(t *testing.T) {
+	t.Setenv("DUMPFILE", "gs://backups/in.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	t.Setenv("GCS_CHUNK_SIZE", "8388608")
+
+	cfg, err := Load([]string{"--azure-block-size", "4194304"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.GCSChunkSize != 8<<20 || cfg.AzureBlockSize != 4<<20 {
+		t.Fatalf("upload sizes not applied: %+v", cfg)
+	}
+
+	if _, err := Load([]string{"--azure-block-size", "5000000000"}); err == nil {
+		t.Fatal("expected a block size above 4000 MiB to fail")
+	}
+}
+
+func TestLoadInputSelection(t *testing.T) {
+	t.Setenv("DUMPFILE", "s3://backups/daily/db-*.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.InputSelect != "newest" || cfg.InputCount != 1 || cfg.InputOrder != "mtime" {
+		t.Fatalf("glob input did not default to the newest match: %+v", cfg)
+	}
+
+	cfg, err = Load([]string{"--input-select", "newest:3", "--input-order", "name", "--output", "./out/{{.InputBase}}.tar.gz"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.InputCount != 3 || cfg.InputOrder != "name" {
+		t.Fatalf("selection flags not applied: %+v", cfg)
+	}
+
+	for _, policy := range []string{"all", "newest", "newest:2"} {
+		if _, err := Load([]string{"--input-select", policy, "--output", "./out/{{.InputBase}}.tar.gz", "--mode", "schedule", "--every", "1h"}); err == nil || !strings.Contains(err.Error(), "INPUT_STATE_FILE") {
+			t.Fatalf("expected scheduled %s without a state file to fail, got %v", policy, err)
+		}
+	}
+	if _, err := Load([]string{"--input-select", "oldest"}); err == nil {
+		t.Fatal("expected an unknown policy to fail")
+	}
+	if _, err := Load([]string{"--input-select", "all"}); err == nil {
+		t.Fatal("expected several inputs into one fixed output to fail")
+	}
+}
+
+func TestLoadOutputTemplate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DUMPFILE", "./in.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	cfgPath := filepath.Join(dir, "nightly.yaml")
+	if err := os.WriteFile(cfgPath, []byte("OUTPUT_FILE: s3://clean/{{.Profile}}/{{.InputBase}}.tar.gz\nOVERWRITE: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load([]string{"--config", cfgPath})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.Profile != "nightly" || !cfg.Overwrite || cfg.OutputTemplate.Static() {
+		t.Fatalf("output options not applied: %+v", cfg)
+	}
+
+	if _, err := Load([]string{"--output", "./out/{{.Nope}}.sql"}); err == nil {
+		t.Fatal("expected an unknown template field to fail")
+	}
+}
+
+func TestLoadRetention(t *testing.T) {
+	t.Setenv("DUMPFILE", "./in.tar.gz")
+	t.Setenv("TMP_DIR", t.TempDir())
+	t.Setenv("OUTPUT_FILE", "s3://clean/{{.InputBase}}-{{.RunID}}.tar.gz")
+	t.Setenv("RETAIN_WITHIN", "30d")
+	t.Setenv("RETAIN_WEEKLY", "8")
+
+	cfg, err := Load([]string{"--retain-last", "3", "--retention-dry-run"})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	want := retention.Policy{Last: 3, Within: 30 * 24 * time.Hour, Weekly: 8}
+	if cfg.Retention != want || !cfg.RetentionDryRun {
+		t.Fatalf("retention not applied: %+v", cfg.Retention)
+	}
+
+	// the README example: dated inputs vary the name through InputBase
+	if _, err := Load([]string{"--output", "s3://clean/{{.InputBase}}.clean.tar.gz", "--input-time-layout", "20060102"}); err != nil {
+		t.Errorf("retention keyed on the input name failed: %v", err)
+	}
+	for _, output := range []string{"./out/fixed.tar.gz", "./out/{{.RunID}}/db.tar.gz", "./out/{{.InputBase}}/db.tar.gz", "-"} {
+		if _, err := Load([]string{"--output", output}); err == nil {
+			t.Errorf("expected retention into %q to fail", output)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type LoadStrategy interface {
@@ -52,13 +53,33 @@ func ResolveStrategy(format, path string) (LoadStrategy, error) {
 }
 
 func readKnownEnv() map[string]string {
-	keys := []string{"DUMPFILE", "OUTPUT_FILE", "TABLE_MAP", "TMP_DIR", "MAX_LINE_BYTES", "MODE", "SCHEDULE_EVERY"}
+	keys := []string{
+		"DUMPFILE", "OUTPUT_FILE", "TABLE_MAP", "TABLE_KEEP", "TMP_DIR", "MAX_LINE_BYTES", "MODE", "SCHEDULE_EVERY",
+		"INPUT_SELECT", "INPUT_ORDER", "INPUT_TIME_LAYOUT", "INPUT_STATE_FILE", "OVERWRITE", "PROFILE",
+		"RETAIN_LAST", "RETAIN_WITHIN", "RETAIN_DAILY", "RETAIN_WEEKLY", "RETAIN_MONTHLY", "RETENTION_DRY_RUN",
+		"INPUT_COMPRESSION", "OUTPUT_COMPRESSION", "COMPRESSION_LEVEL", "OUTPUT_CONTAINER",
+		"ARCHIVE_MAX_BYTES", "ARCHIVE_MAX_ENTRIES", "SQL_GLOB",
+		"DETERMINISTIC", "DETERMINISTIC_MTIME", "SOURCE_DATE_EPOCH", "MASK", "MASK_SECRET", "SAMPLE", "LAYOUT",
+		"DIALECT", "S3_ENDPOINT", "S3_REGION", "S3_PATH_STYLE", "S3_FORCE_PATH_STYLE",
+		"S3_ACCESS_KEY_ID", "S3_SECRET_ACCESS_KEY", "S3_SESSION_TOKEN", "S3_PART_SIZE",
+		"INPUT_CHECKSUM", "HTTP_RETRIES",
+		"SFTP_USER", "SFTP_KEY_FILE", "SFTP_KEY_PASSPHRASE", "SFTP_PASSWORD", "SFTP_KNOWN_HOSTS",
+		"GCS_ENDPOINT", "GCS_CREDENTIALS_FILE", "GCS_ANONYMOUS", "GCS_CHUNK_SIZE",
+		"AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_KEY", "AZURE_STORAGE_CONNECTION_STRING", "AZURE_STORAGE_ENDPOINT", "AZURE_BLOCK_SIZE",
+	}
 	res := make(map[string]string, len(keys))
 	for _, k := range keys {
 		if v, ok := os.LookupEnv(k); ok && strings.TrimSpace(v) != "" {
 			res[k] = v
 		}
 	}
+	// rule sets and headers are open-ended: RULESET_<NAME>_PATHS, _SKIP,
+	// _KEEP, _SAMPLE and _MASK, HTTP_HEADER_<NAME>
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && (strings.HasPrefix(k, ruleSetPrefix) || strings.HasPrefix(k, httpHeaderPrefix)) && strings.TrimSpace(v) != "" {
+			res[k] = v
+		}
+	}
 	return res
 }
 
@@ -66,6 +87,19 @@ func parseInt(v string) (int, error) {
 	return strconv.Atoi(strings.TrimSpace(v))
 }
 
+func parseInt64(v string) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+}
+
+// parseTimestamp accepts unix seconds, as in SOURCE_DATE_EPOCH, or RFC 3339.
+func parseTimestamp(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
 func normalizeKey(k string) string {
 	k = strings.TrimSpace(strings.ToUpper(k))
 	k = strings.ReplaceAll(k, ".", "_")
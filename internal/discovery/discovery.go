@@ -0,0 +1,199 @@
+// Package discovery finds the backups an input pattern matches and picks
+// the ones a run processes.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+const (
+	// SelectNewest picks the newest candidate; "newest:N" the N newest.
+	SelectNewest = "newest"
+	// SelectAll picks every candidate.
+	SelectAll = "all"
+
+	// OrderMTime ranks candidates by modification time, OrderName by the
+	// timestamp in their names.
+	OrderMTime = "mtime"
+	OrderName  = "name"
+)
+
+// Policy selects the inputs of a run among the discovered candidates.
+type Policy struct {
+	// Count is how many of the newest candidates are considered, 0 for all.
+	Count int
+	// Order is OrderMTime or OrderName.
+	Order string
+	// TimeLayout is the Go time layout of the timestamp in names. Empty
+	// detects dates like 2026-10-17, 20261017, 2026-10-17T03-00 and
+	// 20261017_030000.
+	TimeLayout string
+}
+
+// ParseSelect returns the Policy.Count of "newest", "newest:N" or "all".
+func ParseSelect(s string) (int, error) {
+	switch s {
+	case SelectAll:
+		return 0, nil
+	case SelectNewest:
+		return 1, nil
+	}
+	if n, ok := strings.CutPrefix(s, SelectNewest+":"); ok {
+		if count, err := strconv.Atoi(n); err == nil && count > 0 {
+			return count, nil
+		}
+	}
+	return 0, fmt.Errorf("want newest, newest:N or all, got %q", s)
+}
+
+// ValidateOrder reports an unknown candidate order.
+func ValidateOrder(order string) error {
+	if order != OrderMTime && order != OrderName {
+		return fmt.Errorf("want %s or %s, got %q", OrderMTime, OrderName, order)
+	}
+	return nil
+}
+
+// IsPattern reports whether the last element of location is a glob. The
+// "?" of HTTP(S) URLs starts a query, so they are never patterns.
+func IsPattern(location string) bool {
+	if u, err := storage.Parse(location); err != nil || u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "stdio" {
+		return false
+	}
+	_, name := split(location)
+	return strings.ContainsAny(name, "*?[")
+}
+
+// Find lists the candidates pattern names: the objects matching a glob in
+// its last element ("s3://bucket/daily/db-*.tar.gz"), or every object in a
+// directory or under a prefix ("/backups", "s3://bucket/daily/").
+// Subdirectories are not searched.
+func Find(ctx context.Context, backends storage.Backends, pattern string) ([]storage.Object, error) {
+	dir, glob := split(pattern)
+	if !IsPattern(pattern) {
+		dir, glob = strings.TrimSuffix(pattern, "/"), "*"
+		if dir == "" {
+			dir = "/"
+		}
+	}
+	if strings.ContainsAny(dir, "*?[") {
+		return nil, fmt.Errorf("input pattern %q: only the last element may be a glob", pattern)
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("input pattern %q: %w", pattern, err)
+	}
+	objects, err := backends.List(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("list inputs: %w", err)
+	}
+	matched := objects[:0]
+	for _, obj := range objects {
+		if ok, _ := path.Match(glob, obj.Name); ok {
+			matched = append(matched, obj)
+		}
+	}
+	return matched, nil
+}
+
+// split cuts location at its last slash. A bare file name lives in ".".
+func split(location string) (dir, name string) {
+	i := strings.LastIndex(location, "/")
+	if i < 0 {
+		return ".", location
+	}
+	if i == 0 || strings.HasSuffix(location[:i], "://") {
+		// keep the root of "/x" and "s3://bucket" listable
+		return location[:i+1], location[i+1:]
+	}
+	return location[:i], location[i+1:]
+}
+
+// Select returns the candidates to process, oldest first: the Count newest
+// ones, less those state records as processed. Under OrderName, candidates
+// without a timestamp in their names are ignored.
+func (p Policy) Select(candidates []storage.Object, state *State) []storage.Object {
+	type ranked struct {
+		obj storage.Object
+		at  time.Time
+	}
+	var list []ranked
+	for _, obj := range candidates {
+		at := obj.ModTime
+		if p.Order == OrderName {
+			var ok bool
+			if at, ok = NameTime(obj.Name, p.TimeLayout); !ok {
+				continue
+			}
+		}
+		list = append(list, ranked{obj, at})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if !list[i].at.Equal(list[j].at) {
+			return list[i].at.Before(list[j].at)
+		}
+		return list[i].obj.Location < list[j].obj.Location
+	})
+	if p.Count > 0 && len(list) > p.Count {
+		list = list[len(list)-p.Count:]
+	}
+
+	var selected []storage.Object
+	for _, r := range list {
+		if !state.Done(r.obj) {
+			selected = append(selected, r.obj)
+		}
+	}
+	return selected
+}
+
+var nameTimeRe = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})(?:[T_ .-]?(\d{2})[-:.]?(\d{2})(?:[-:.]?(\d{2}))?)?`)
+
+// NameTime finds a timestamp in a file name, in layout or, when layout is
+// empty, in one of the forms Policy.TimeLayout lists. Times without a zone
+// are UTC.
+func NameTime(name, layout string) (time.Time, bool) {
+	t, loc := nameTime(name, layout)
+	return t, loc != nil
+}
+
+// NameTimeIndex returns the start and end of the timestamp NameTime finds in
+// name, or nil if there is none.
+func NameTimeIndex(name, layout string) []int {
+	_, loc := nameTime(name, layout)
+	return loc
+}
+
+func nameTime(name, layout string) (time.Time, []int) {
+	if layout != "" {
+		// numeric layouts format to their own length
+		for i := 0; i+len(layout) <= len(name); i++ {
+			if t, err := time.Parse(layout, name[i:i+len(layout)]); err == nil {
+				return t, []int{i, i + len(layout)}
+			}
+		}
+		return time.Time{}, nil
+	}
+	for _, m := range nameTimeRe.FindAllStringSubmatchIndex(name, -1) {
+		parts := make([]string, 7)
+		for i := 1; i <= 6; i++ {
+			parts[i] = "00"
+			if m[2*i] >= 0 {
+				parts[i] = name[m[2*i]:m[2*i+1]]
+			}
+		}
+		s := fmt.Sprintf("%s-%s-%sT%s:%s:%s", parts[1], parts[2], parts[3], parts[4], parts[5], parts[6])
+		if t, err := time.Parse("2006-01-02T15:04:05", s); err == nil {
+			return t, m[:2]
+		}
+	}
+	return time.Time{}, nil
+}
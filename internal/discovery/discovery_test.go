@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+func TestNameTime(t *testing.T) {
+	cases := []struct {
+		name, layout string
+		want         time.Time
+		ok           bool
+	}{
+		{"db-2026-10-17T03-00.tar.gz", "", time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC), true},
+		{"db_20261017_030405.sql.zst", "", time.Date(2026, 10, 17, 3, 4, 5, 0, time.UTC), true},
+		{"db-2026-10-17.tar.gz", "", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), true},
+		{"db-2026-13-45.tar.gz", "", time.Time{}, false},
+		{"latest.tar.gz", "", time.Time{}, false},
+		{"db.17.10.2026.gz", "02.01.2006", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, tc := range cases {
+		got, ok := NameTime(tc.name, tc.layout)
+		if ok != tc.ok || !got.Equal(tc.want) {
+			t.Errorf("NameTime(%q, %q) = %v, %v", tc.name, tc.layout, got, ok)
+		}
+	}
+}
+
+func TestParseSelect(t *testing.T) {
+	for in, want := range map[string]int{"newest": 1, "newest:3": 3, "all": 0} {
+		if got, err := ParseSelect(in); err != nil || got != want {
+			t.Errorf("ParseSelect(%q) = %d, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "oldest", "newest:0", "newest:x"} {
+		if _, err := ParseSelect(in); err == nil {
+			t.Errorf("ParseSelect(%q) succeeded", in)
+		}
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	for in, want := range map[string]bool{
+		"/backups/db-*.tar.gz":            true,
+		"s3://bucket/daily/db-??.sql":     true,
+		"/backups/[ab]/db.sql":            false,
+		"/backups/":                       false,
+		"https://example.com/db.sql?x=1*": false,
+	} {
+		if got := IsPattern(in); got != want {
+			t.Errorf("IsPattern(%q) = %v", in, got)
+		}
+	}
+}
+
+func TestFindAndSelect(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	// modification times run against the names' order
+	names := []string{"db-2026-10-15.tar.gz", "db-2026-10-16.tar.gz", "db-2026-10-17.tar.gz", "notes.txt"}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	backends := storage.Default()
+
+	candidates, err := Find(ctx, backends, filepath.Join(dir, "db-*.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 3 {
+		t.Fatalf("found %+v", candidates)
+	}
+	if all, err := Find(ctx, backends, dir+"/"); err != nil || len(all) != 4 {
+		t.Fatalf("found %d in directory: %v", len(all), err)
+	}
+
+	newest := Policy{Count: 1, Order: OrderMTime}.Select(candidates, nil)
+	if len(newest) != 1 || newest[0].Name != "db-2026-10-15.tar.gz" {
+		t.Fatalf("newest by mtime = %+v", newest)
+	}
+	byName := Policy{Count: 2, Order: OrderName}.Select(candidates, nil)
+	if len(byName) != 2 || byName[0].Name != "db-2026-10-16.tar.gz" || byName[1].Name != "db-2026-10-17.tar.gz" {
+		t.Fatalf("two newest by name = %+v", byName)
+	}
+
+	statePath := filepath.Join(dir, "state", "inputs.json")
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := Policy{Order: OrderName}
+	if err := state.Mark(byName[0], "/out/a.tar.gz", base); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = LoadState(statePath); err != nil {
+		t.Fatal(err)
+	}
+	left := all.Select(candidates, state)
+	if len(left) != 2 || left[0].Name != "db-2026-10-15.tar.gz" || left[1].Name != "db-2026-10-17.tar.gz" {
+		t.Fatalf("unprocessed = %+v", left)
+	}
+
+	// a rewritten backup is processed again
+	changed := byName[0]
+	changed.Size++
+	if state.Done(changed) {
+		t.Fatal("changed input reported as processed")
+	}
+}
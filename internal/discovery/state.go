@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// State records the processed inputs in a local JSON file, so that runs
+// skip them. An input is processed again once its size or modification
+// time changes. A nil *State records nothing.
+type State struct {
+	path   string
+	Inputs map[string]Processed `json:"inputs"`
+}
+
+// Processed describes one processed input.
+type Processed struct {
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Output      string    `json:"output"`
+}
+
+// LoadState reads the state file at path; a missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Inputs: map[string]Processed{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+	if s.Inputs == nil {
+		s.Inputs = map[string]Processed{}
+	}
+	return s, nil
+}
+
+// Done reports whether obj was processed in its current version.
+func (s *State) Done(obj storage.Object) bool {
+	if s == nil {
+		return false
+	}
+	p, ok := s.Inputs[obj.Location]
+	return ok && p.Size == obj.Size && p.ModTime.Equal(obj.ModTime)
+}
+
+// Mark records obj as processed into output and saves the state.
+func (s *State) Mark(obj storage.Object, output string, at time.Time) error {
+	if s == nil {
+		return nil
+	}
+	s.Inputs[obj.Location] = Processed{Size: obj.Size, ModTime: obj.ModTime, ProcessedAt: at.UTC(), Output: output}
+	return s.save()
+}
+
+// save replaces the state file through a rename, so a crash never leaves
+// it half written.
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("save state: %w", err)
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("save state: %w", err)
+	}
+	return nil
+}
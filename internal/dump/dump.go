@@ -0,0 +1,52 @@
+// Package dump names the parts of a dump that the configuration selects and
+// the pipeline acts on: its layout, its container, the entries treated as
+// SQL and the rule sets filtering them.
+package dump
+
+import (
+	"fmt"
+
+	"github.com/d00p1/filtrate-backups/internal/filter"
+)
+
+// Layouts name the file structure of a dump. LayoutGeneric filters every
+// entry matching the SQL globs; the others know the files of one dump tool.
+const (
+	LayoutAuto     = "auto"
+	LayoutGeneric  = "generic"
+	LayoutMydumper = "mydumper"
+	LayoutMysqlsh  = "mysqlsh"
+)
+
+// Containers describe the layout of a decompressed stream: an archive or a
+// single plain SQL dump. ContainerDir is an unpacked directory tree on disk.
+// ContainerAuto mirrors the input on output.
+const (
+	ContainerAuto = "auto"
+	ContainerTar  = "tar"
+	ContainerZip  = "zip"
+	ContainerSQL  = "sql"
+	ContainerDir  = "dir"
+)
+
+// DefaultSQLGlob selects the archive entries treated as SQL dumps.
+const DefaultSQLGlob = "*.sql"
+
+// RuleSet binds filter rules to the SQL entries whose path matches one of
+// Paths, with the syntax of Globs. A plain input is matched under its entry
+// name, e.g. "db.sql" for "db.sql.gz".
+type RuleSet struct {
+	Name  string
+	Paths []string
+	Rules filter.Rules
+}
+
+// ValidateLayout reports an unknown layout name.
+func ValidateLayout(name string) error {
+	switch name {
+	case "", LayoutAuto, LayoutGeneric, LayoutMydumper, LayoutMysqlsh:
+		return nil
+	default:
+		return fmt.Errorf("unknown dump layout %q", name)
+	}
+}
@@ -0,0 +1,61 @@
+package dump
+
+import (
+	"path"
+	"strings"
+)
+
+// Globs match archive entry paths against shell-style globs. A glob without
+// a slash matches the base name at any depth ("*.sql"); a glob with a slash
+// matches the whole slash-separated path, where "**" spans any number of
+// directories ("db/**/*.sql").
+type Globs []string
+
+func (g Globs) Match(name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	for _, pattern := range g {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGlob reports a malformed glob pattern.
+func ValidateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,28 @@
+package dump
+
+import "testing"
+
+func TestGlobListMatch(t *testing.T) {
+	tests := []struct {
+		globs Globs
+		name  string
+		want  bool
+	}{
+		{Globs{"*.sql"}, "dump.sql", true},
+		{Globs{"*.sql"}, "db/table.sql", true},
+		{Globs{"*.sql"}, "metadata", false},
+		{Globs{"*.sql"}, "db/manifest.json", false},
+		{Globs{"analytics/*.sql"}, "analytics/events.sql", true},
+		{Globs{"analytics/*.sql"}, "billing/events.sql", false},
+		{Globs{"analytics/*.sql"}, "analytics/2026/events.sql", false},
+		{Globs{"db/**/*.sql"}, "db/events.sql", true},
+		{Globs{"db/**/*.sql"}, "db/a/b/events.sql", true},
+		{Globs{"metadata", "*.sql"}, "./metadata", true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.globs.Match(tt.name); got != tt.want {
+			t.Fatalf("%v.Match(%q) = %v, want %v", tt.globs, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package dumpgen
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// WriteSQL writes CREATE TABLE statements for tables followed by batched
+// INSERT statements in round-robin order until targetBytes of SQL are written.
+func WriteSQL(w io.Writer, tables []string, targetBytes int64, rowsPerInsert, valueSize int, rng *rand.Rand) (int64, error) {
+	var written int64
+	for _, tableName := range tables {
+		base := "CREATE TABLE `" + tableName + "` (id BIGINT PRIMARY KEY, payload TEXT);\n"
+		n, err := io.WriteString(w, base)
+		if err != nil {
+			return written, fmt.Errorf("write ddl: %w", err)
+		}
+		written += int64(n)
+	}
+
+	ids := make([]int64, len(tables))
+	for i := range ids {
+		ids[i] = 1
+	}
+
+	tableIdx := 0
+	for written < targetBytes {
+		tableName := tables[tableIdx%len(tables)]
+		tablePos := tableIdx % len(tables)
+		tableIdx++
+
+		var b strings.Builder
+		b.Grow(rowsPerInsert * (valueSize + 48))
+		b.WriteString("INSERT INTO `")
+		b.WriteString(tableName)
+		b.WriteString("` VALUES ")
+		for i := 0; i < rowsPerInsert; i++ {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString("(")
+			b.WriteString(strconv.FormatInt(ids[tablePos], 10))
+			b.WriteString(",'")
+			b.WriteString(RandomString(rng, valueSize))
+			b.WriteString("')")
+			ids[tablePos]++
+		}
+		b.WriteString(";\n")
+
+		line := b.String()
+		n, err := io.WriteString(w, line)
+		if err != nil {
+			return written, fmt.Errorf("write insert: %w", err)
+		}
+		written += int64(n)
+	}
+
+	return written, nil
+}
+
+// RandomString returns a random alphanumeric string of the given length.
+func RandomString(rng *rand.Rand, length int) string {
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(buf)
+}
@@ -0,0 +1,485 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mysqlbinlog -v prints every event as a "# at <pos>" line, a header comment
+// naming the event, and its body:
+//
+//	# at 313
+//	#260101 10:00:00 server id 1  end_log_pos 372 ... Table_map: `shop`.`users` mapped to number 92
+//	# at 372
+//	#260101 10:00:00 server id 1  end_log_pos 425 ... Write_rows: table id 92 flags: STMT_END_F
+//
+//	BINLOG '
+//	<base64 Table_map event>
+//	<base64 Write_rows event>
+//	'/*!*/;
+//	### INSERT INTO `shop`.`users`
+//	### SET
+//	###   @1=1
+//
+// The base64 of a Table_map event is printed with the rows events of its
+// statement, so a BINLOG block may carry events of several tables. Tables
+// match skip patterns and masks by bare name or as "db.table".
+//
+// Statement-based events print their SQL up to a "/*!*/;" line end. A DML,
+// DDL or LOAD DATA statement is dropped when any table it names is skipped:
+// its target, the sources of INSERT ... SELECT, both sides of RENAME TABLE,
+// or any table of a DROP TABLE list.
+var (
+	binlogAt         = []byte("# at ")
+	binlogStart      = []byte("BINLOG '")
+	binlogEnd        = []byte("'/*!*/;")
+	binlogVerbose    = []byte("### ")
+	binlogStmtEnd    = []byte("/*!*/;")
+	binlogTableMap   = regexp.MustCompile("Table_map: `([^`]*)`\\.`([^`]*)` mapped to number (\\d+)")
+	binlogRowsEvent  = regexp.MustCompile(`_rows(?:_v1)?: table id (\d+)`)
+	binlogVerboseDML = regexp.MustCompile("^### (?:INSERT INTO|UPDATE|DELETE FROM) `([^`]*)`\\.`([^`]*)`")
+	binlogStatement  = regexp.MustCompile(`(?i)^\s*(INSERT|REPLACE|UPDATE|DELETE|LOAD|ALTER|TRUNCATE|DROP|CREATE|RENAME)\b`)
+	binlogTableRefs  = regexp.MustCompile("(?i)\\b(?:INTO|UPDATE|FROM|JOIN|TABLE|TRUNCATE|TO|LIKE|REFERENCES)\\s+" +
+		"(?:(?:TABLE|LOW_PRIORITY|IGNORE|IF\\s+(?:NOT\\s+)?EXISTS)\\s+)*" +
+		"(" + binlogQualified + "(?:\\s*,\\s*" + binlogQualified + ")*)")
+	binlogTableName   = regexp.MustCompile("(?:(" + binlogIdent + ")\\s*\\.\\s*)?(" + binlogIdent + ")")
+	binlogOnDuplicate = regexp.MustCompile(`(?i)\bON\s+DUPLICATE\s+KEY\s+UPDATE\b`)
+)
+
+const (
+	binlogIdent     = "`[^`]+`|[\\w$]+"
+	binlogQualified = "(?:" + binlogIdent + ")(?:\\s*\\.\\s*(?:" + binlogIdent + "))?"
+)
+
+// Binary log event types that carry a table id right after the common header.
+const (
+	binlogHeaderLen     = 19
+	binlogTableMapEvent = 19
+	binlogStmtEndFlag   = 0x0001 // STMT_END_F in a rows event's flags
+	binlogChecksumLen   = 4
+)
+
+var binlogRowsEventTypes = map[byte]bool{
+	23: true, 24: true, 25: true, // WRITE/UPDATE/DELETE_ROWS_EVENT_V1
+	30: true, 31: true, 32: true, // WRITE/UPDATE/DELETE_ROWS_EVENT
+	39: true, // PARTIAL_UPDATE_ROWS_EVENT
+}
+
+// binlogFilter drops the events of skipped tables from mysqlbinlog output:
+// the "# at" and header lines of their Table_map and rows events, their
+// verbose "###" rows, statement-based writes to them, and their events
+// inside BINLOG blocks. A block that carries a dropped table's events is
+// decoded and written again without them.
+type binlogFilter struct {
+	matcher *tableMatcher
+	masked  map[string]bool
+
+	dropped     map[uint64]bool // table ids whose Table_map was dropped
+	pending     bool            // a dropped Table_map may sit in the next BINLOG block
+	at          []byte          // "# at" line waiting for its event header
+	skipping    bool            // the current event is dropped
+	inBlock     bool            // inside a BINLOG '...' block
+	block       [][]byte        // buffered lines of a block being rewritten
+	dropVerbose bool            // the current "###" row belongs to a dropped table
+	stmt        [][]byte        // buffered lines of a statement being judged
+	stmtBytes   int
+	inStmt      bool // streaming the rest of a statement too long to buffer
+	dropStmt    bool // the statement being streamed is dropped
+}
+
+func applyBinlog(reader *lineReader, w io.Writer, matcher *tableMatcher, rules Rules, maxLineBytes int) (Stats, error) {
+	f := &binlogFilter{matcher: matcher, masked: map[string]bool{}, dropped: map[uint64]bool{}}
+	for _, m := range rules.Masks {
+		f.masked[m.Table] = true
+	}
+	writer := bufio.NewWriterSize(w, ioBufferSize)
+	defer writer.Flush()
+
+	var (
+		stats      Stats
+		blockBytes int
+	)
+	for {
+		line, complete, err := reader.head()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.TotalLines++
+		trimmed := trimEOL(line)
+
+		if complete && !f.inBlock && bytes.HasPrefix(trimmed, binlogAt) {
+			if _, err := f.flushStmt(writer, &stats); err != nil {
+				return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+			}
+			f.inStmt, f.dropStmt = false, false
+			if err := f.flushAt(writer, &stats); err != nil {
+				return stats, err
+			}
+			f.at = bytes.Clone(line)
+			f.skipping, f.dropVerbose = false, false
+			continue
+		}
+		if f.at != nil {
+			if err := f.header(trimmed); err != nil {
+				return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+			}
+			if err := f.flushAt(writer, &stats); err != nil {
+				return stats, err
+			}
+		}
+
+		drop := f.skipping
+		switch {
+		case complete && !f.inBlock && bytes.Equal(trimmed, binlogStart):
+			f.inBlock = true
+			if f.pending {
+				f.block, blockBytes = [][]byte{bytes.Clone(line)}, len(line)
+				continue
+			}
+		case f.block != nil:
+			blockBytes += len(line)
+			if !complete || blockBytes > maxLineBytes {
+				return stats, fmt.Errorf("line %d: BINLOG block to rewrite exceeds %d bytes", stats.TotalLines, maxLineBytes)
+			}
+			f.block = append(f.block, bytes.Clone(line))
+			if bytes.Equal(trimmed, binlogEnd) {
+				kept, err := f.rewriteBlock(writer)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+				}
+				stats.FilteredLines += len(f.block) - kept
+				f.inBlock, f.pending, f.block = false, false, nil
+			}
+			continue
+		case f.inBlock:
+			if bytes.Equal(trimmed, binlogEnd) {
+				f.inBlock, f.pending = false, false
+			}
+		case bytes.HasPrefix(trimmed, binlogVerbose):
+			// verbose rows may follow the header of another event of the
+			// same statement, so they are judged by their own table
+			if m := binlogVerboseDML.FindSubmatch(trimmed); m != nil {
+				if f.dropVerbose, err = f.dropTable(m[1], m[2]); err != nil {
+					return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+				}
+			}
+			drop = f.dropVerbose
+		case f.inStmt:
+			drop = f.dropStmt
+		case f.stmt != nil || (!f.skipping && binlogStatement.Match(trimmed)):
+			f.stmt = append(f.stmt, bytes.Clone(line))
+			f.stmtBytes += len(line)
+			ended := complete && bytes.HasSuffix(trimmed, binlogStmtEnd)
+			if complete && !ended && f.stmtBytes <= maxLineBytes {
+				continue
+			}
+			// judged on what is buffered when the statement outgrows it
+			if drop, err = f.flushStmt(writer, &stats); err != nil {
+				return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+			}
+			f.inStmt, f.dropStmt = !ended, drop
+			if !complete {
+				var out io.Writer = writer
+				if drop {
+					out = nil
+				}
+				if _, err := reader.copyRest(out, 0); err != nil {
+					return stats, err
+				}
+			}
+			continue
+		}
+
+		var out io.Writer = writer
+		if drop {
+			out = nil
+			stats.FilteredLines++
+		} else if _, err := writer.Write(line); err != nil {
+			return stats, fmt.Errorf("write output: %w", err)
+		}
+		if !complete {
+			if _, err := reader.copyRest(out, 0); err != nil {
+				return stats, err
+			}
+		}
+		if f.inStmt && complete && bytes.HasSuffix(trimmed, binlogStmtEnd) {
+			f.inStmt, f.dropStmt = false, false
+		}
+	}
+
+	if _, err := f.flushStmt(writer, &stats); err != nil {
+		return stats, err
+	}
+	return stats, f.flushAt(writer, &stats)
+}
+
+// flushStmt judges the buffered statement, writes it unless it is dropped,
+// and reports whether it was.
+func (f *binlogFilter) flushStmt(w io.Writer, stats *Stats) (bool, error) {
+	if f.stmt == nil {
+		return false, nil
+	}
+	defer func() { f.stmt, f.stmtBytes = nil, 0 }()
+	drop, err := f.dropStatement(bytes.Join(f.stmt, nil))
+	if err != nil {
+		return false, err
+	}
+	if drop {
+		stats.FilteredLines += len(f.stmt)
+		return true, nil
+	}
+	for _, line := range f.stmt {
+		if _, err := w.Write(line); err != nil {
+			return false, fmt.Errorf("write output: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// dropStatement reports whether a statement names a skipped table. The
+// first table a DML or LOAD DATA statement names is the one it writes to.
+func (f *binlogFilter) dropStatement(stmt []byte) (bool, error) {
+	stmt = blankSQLStrings(stmt)
+	if loc := binlogOnDuplicate.FindIndex(stmt); loc != nil {
+		stmt = stmt[:loc[0]] // assignments, not tables
+	}
+	var writes bool
+	switch strings.ToUpper(string(binlogStatement.FindSubmatch(stmt)[1])) {
+	case "INSERT", "REPLACE", "UPDATE", "DELETE", "LOAD":
+		writes = true
+	}
+	for i, refs := range binlogTableRefs.FindAllSubmatch(stmt, -1) {
+		for _, name := range binlogTableName.FindAllSubmatch(refs[1], -1) {
+			db, table := bytes.Trim(name[1], "`"), bytes.Trim(name[2], "`")
+			if i == 0 && writes {
+				if drop, err := f.dropTable(db, table); drop || err != nil {
+					return drop, err
+				}
+			} else if f.skipsTable(db, table) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// blankSQLStrings empties the quoted strings of a statement, so that their
+// content is not taken for table names.
+func blankSQLStrings(stmt []byte) []byte {
+	out := make([]byte, 0, len(stmt))
+	var quote byte
+	for i := 0; i < len(stmt); i++ {
+		c := stmt[i]
+		switch {
+		case quote == 0:
+			if c == '\'' || c == '"' {
+				quote = c
+			}
+			out = append(out, c)
+		case c == '\\':
+			i++
+		case c == quote && i+1 < len(stmt) && stmt[i+1] == quote:
+			i++
+		case c == quote:
+			quote = 0
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// flushAt writes the held "# at" line unless its event is dropped.
+func (f *binlogFilter) flushAt(w io.Writer, stats *Stats) error {
+	if f.at == nil {
+		return nil
+	}
+	defer func() { f.at = nil }()
+	if f.skipping {
+		stats.FilteredLines++
+		return nil
+	}
+	if _, err := w.Write(f.at); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	return nil
+}
+
+// header decides from an event's header comment whether the event is
+// dropped.
+func (f *binlogFilter) header(line []byte) error {
+	if m := binlogTableMap.FindSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(string(m[3]), 10, 64)
+		drop, err := f.dropTable(m[1], m[2])
+		if err != nil {
+			return err
+		}
+		f.dropped[id] = drop
+		f.pending = f.pending || drop
+		f.skipping = drop
+		return nil
+	}
+	if m := binlogRowsEvent.FindSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(string(m[1]), 10, 64)
+		f.skipping = f.dropped[id]
+	}
+	return nil
+}
+
+// dropTable reports whether db.table, which an event writes rows to, is
+// skipped. Row data of kept tables with masks cannot be rewritten in binlog
+// events, so it is an error.
+func (f *binlogFilter) dropTable(db, table []byte) (bool, error) {
+	if f.skipsTable(db, table) {
+		return true, nil
+	}
+	qualified := qualifiedName(db, table)
+	if f.masked[string(table)] || f.masked[string(qualified)] {
+		return false, fmt.Errorf("binlog event writes to %s, which has masks; masking binlog rows is not supported, skip the table instead", qualified)
+	}
+	return false, nil
+}
+
+// skipsTable reports whether db.table is skipped, by either name.
+func (f *binlogFilter) skipsTable(db, table []byte) bool {
+	return f.matcher.skipEither(qualifiedName(db, table), table)
+}
+
+func qualifiedName(db, table []byte) []byte {
+	if len(db) == 0 {
+		return table
+	}
+	return append(append(append([]byte(nil), db...), '.'), table...)
+}
+
+// rewriteBlock writes the buffered BINLOG block without the events of
+// dropped tables and returns the number of lines written.
+func (f *binlogFilter) rewriteBlock(w io.Writer) (int, error) {
+	var encoded []byte
+	for _, line := range f.block[1 : len(f.block)-1] {
+		encoded = append(encoded, bytes.TrimSpace(line)...)
+	}
+	events, err := decodeBinlogBase64(encoded)
+	if err != nil {
+		return 0, fmt.Errorf("decode BINLOG block: %w", err)
+	}
+
+	var (
+		kept     [][]byte
+		lastRows = -1 // index in kept of the last rows event
+		stmtEnd  bool // a dropped rows event ended the statement
+	)
+	for _, ev := range events {
+		if len(ev) >= binlogHeaderLen+6 && (ev[4] == binlogTableMapEvent || binlogRowsEventTypes[ev[4]]) {
+			var id [8]byte
+			copy(id[:], ev[binlogHeaderLen:binlogHeaderLen+6])
+			if f.dropped[binary.LittleEndian.Uint64(id[:])] {
+				if binlogRowsEventTypes[ev[4]] && len(ev) >= binlogHeaderLen+8 {
+					stmtEnd = stmtEnd || binary.LittleEndian.Uint16(ev[binlogHeaderLen+6:])&binlogStmtEndFlag != 0
+				}
+				continue
+			}
+			if binlogRowsEventTypes[ev[4]] {
+				lastRows = len(kept)
+			}
+		}
+		kept = append(kept, ev)
+	}
+	// the statement now ends with the last rows event kept
+	if stmtEnd && lastRows >= 0 {
+		kept[lastRows] = setStmtEnd(kept[lastRows])
+	}
+
+	switch {
+	case len(kept) == len(events):
+		for _, line := range f.block {
+			if _, err := w.Write(line); err != nil {
+				return 0, fmt.Errorf("write output: %w", err)
+			}
+		}
+		return len(f.block), nil
+	case len(kept) == 0:
+		return 0, nil
+	}
+
+	lines := [][]byte{f.block[0]}
+	for _, ev := range kept {
+		text := base64.StdEncoding.EncodeToString(ev)
+		for len(text) > 76 {
+			lines = append(lines, []byte(text[:76]+"\n"))
+			text = text[76:]
+		}
+		lines = append(lines, []byte(text+"\n"))
+	}
+	lines = append(lines, f.block[len(f.block)-1])
+	for _, line := range lines {
+		if _, err := w.Write(line); err != nil {
+			return 0, fmt.Errorf("write output: %w", err)
+		}
+	}
+	return len(lines), nil
+}
+
+// setStmtEnd returns a copy of a rows event with STMT_END_F set. A CRC32
+// trailer, present when the server writes checksums, is recomputed.
+func setStmtEnd(ev []byte) []byte {
+	if len(ev) < binlogHeaderLen+8 {
+		return ev
+	}
+	body := len(ev) - binlogChecksumLen
+	checksum := body >= binlogHeaderLen+8 && crc32.ChecksumIEEE(ev[:body]) == binary.LittleEndian.Uint32(ev[body:])
+	ev = bytes.Clone(ev)
+	flags := ev[binlogHeaderLen+6:]
+	binary.LittleEndian.PutUint16(flags, binary.LittleEndian.Uint16(flags)|binlogStmtEndFlag)
+	if checksum {
+		binary.LittleEndian.PutUint32(ev[body:], crc32.ChecksumIEEE(ev[:body]))
+	}
+	return ev
+}
+
+// decodeBinlogBase64 decodes the concatenated, separately padded base64
+// chunks of a BINLOG statement and splits the result into events.
+func decodeBinlogBase64(encoded []byte) ([][]byte, error) {
+	var raw []byte
+	for len(encoded) > 0 {
+		end := bytes.IndexByte(encoded, '=')
+		if end < 0 {
+			end = len(encoded)
+		} else {
+			for end < len(encoded) && encoded[end] == '=' {
+				end++
+			}
+		}
+		chunk := make([]byte, base64.StdEncoding.DecodedLen(end))
+		n, err := base64.StdEncoding.Decode(chunk, encoded[:end])
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, chunk[:n]...)
+		encoded = encoded[end:]
+	}
+
+	var events [][]byte
+	for len(raw) > 0 {
+		if len(raw) < binlogHeaderLen {
+			return nil, fmt.Errorf("truncated event header")
+		}
+		size := int(binary.LittleEndian.Uint32(raw[9:13]))
+		if size < binlogHeaderLen || size > len(raw) {
+			return nil, fmt.Errorf("event size %d out of range", size)
+		}
+		events = append(events, raw[:size])
+		raw = raw[size:]
+	}
+	return events, nil
+}
@@ -0,0 +1,202 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+// binlogEvent builds a minimal event of the given type for table id.
+func binlogEvent(typ byte, tableID uint64, payload string) string {
+	ev := make([]byte, binlogHeaderLen+6, binlogHeaderLen+6+len(payload))
+	ev[4] = typ
+	var id [8]byte
+	binary.LittleEndian.PutUint64(id[:], tableID)
+	copy(ev[binlogHeaderLen:], id[:6])
+	ev = append(ev, payload...)
+	binary.LittleEndian.PutUint32(ev[9:13], uint32(len(ev)))
+	return base64.StdEncoding.EncodeToString(ev) + "\n"
+}
+
+func TestApplyBinlogDropsEvents(t *testing.T) {
+	fde := binlogEvent(15, 0, "format description")
+	tmSessions := binlogEvent(19, 91, "sessions")
+	tmUsers := binlogEvent(19, 92, "users")
+	rowsSessions := binlogEvent(30, 91, "tok")
+	rowsUsers := binlogEvent(30, 92, "row 1")
+
+	head := "/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;\n" +
+		"DELIMITER /*!*/;\n" +
+		"# at 4\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 126 CRC32 0x00000000 \tStart: binlog v 4, server v 8.0.36\n" +
+		"BINLOG '\n" + fde + "'/*!*/;\n" +
+		"# at 236\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 313 CRC32 0x00000000 \tQuery\tthread_id=8\texec_time=0\terror_code=0\n" +
+		"SET TIMESTAMP=1767261600/*!*/;\n" +
+		"BEGIN\n/*!*/;\n"
+	tail := "# at 500\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 560 CRC32 0x00000000 \tQuery\tthread_id=8\texec_time=0\terror_code=0\n" +
+		"SET TIMESTAMP=1767261600/*!*/;\n" +
+		"insert into sessions values ('x',\n'y')\n/*!*/;\n" +
+		"# at 560\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 591 CRC32 0x00000000 \tXid = 10\n" +
+		"COMMIT/*!*/;\n"
+	input := head +
+		"# at 313\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 360 CRC32 0x00000000 \tTable_map: `shop`.`sessions` mapped to number 91\n" +
+		"# at 360\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 400 CRC32 0x00000000 \tTable_map: `shop`.`users` mapped to number 92\n" +
+		"# at 400\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 450 CRC32 0x00000000 \tWrite_rows: table id 91\n" +
+		"# at 450\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 500 CRC32 0x00000000 \tWrite_rows: table id 92 flags: STMT_END_F\n" +
+		"\n" +
+		"BINLOG '\n" + tmSessions + tmUsers + rowsSessions + rowsUsers + "'/*!*/;\n" +
+		"### INSERT INTO `shop`.`sessions`\n" +
+		"### SET\n" +
+		"###   @1='tok'\n" +
+		"### INSERT INTO `shop`.`users`\n" +
+		"### SET\n" +
+		"###   @1=1\n" +
+		tail
+
+	var out bytes.Buffer
+	stats, err := Apply(strings.NewReader(input), &out, Rules{SkipTables: []string{"^sessions$"}}, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := head +
+		"# at 360\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 400 CRC32 0x00000000 \tTable_map: `shop`.`users` mapped to number 92\n" +
+		"# at 450\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 500 CRC32 0x00000000 \tWrite_rows: table id 92 flags: STMT_END_F\n" +
+		"\n" +
+		"BINLOG '\n" + tmUsers + rowsUsers + "'/*!*/;\n" +
+		"### INSERT INTO `shop`.`users`\n" +
+		"### SET\n" +
+		"###   @1=1\n" +
+		strings.Replace(tail, "insert into sessions values ('x',\n'y')\n/*!*/;\n", "", 1)
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if stats.FilteredLines != 12 {
+		t.Fatalf("expected 12 filtered lines, got %d", stats.FilteredLines)
+	}
+}
+
+func TestApplyBinlogRefusesMaskedTables(t *testing.T) {
+	input := "DELIMITER /*!*/;\n# at 313\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 360 CRC32 0x00000000 \tTable_map: `shop`.`users` mapped to number 92\n"
+	rules := Rules{Masks: []Mask{{Table: "users", Column: "email", Strategy: MaskNull}}}
+	if _, err := Apply(strings.NewReader(input), &bytes.Buffer{}, rules, 1024); err == nil {
+		t.Fatal("expected rows of a masked table to fail")
+	}
+}
+
+func TestApplyBinlogDropsStatements(t *testing.T) {
+	query := func(stmt string) string {
+		return "# at 236\n" +
+			"#260101 10:00:00 server id 1  end_log_pos 313 CRC32 0x00000000 \tQuery\tthread_id=8\texec_time=0\terror_code=0\n" +
+			"SET TIMESTAMP=1767261600/*!*/;\n" +
+			stmt + "\n/*!*/;\n"
+	}
+	cases := []struct {
+		stmt string
+		drop bool
+	}{
+		{"ALTER TABLE `sessions` ADD COLUMN `ip` varchar(45)", true},
+		{"TRUNCATE TABLE sessions", true},
+		{"truncate `shop`.`sessions`", true},
+		{"DROP TABLE IF EXISTS `users`,`sessions` /* generated by server */", true},
+		{"CREATE TABLE `sessions` (\n  `id` int\n)", true},
+		{"CREATE TABLE sessions_copy LIKE sessions", true},
+		{"RENAME TABLE `users` TO `old_users`, `sessions_new` TO `sessions`", true},
+		{"LOAD DATA LOCAL INFILE '/tmp/SQL_LOAD_MB-1-0' INTO TABLE `sessions` FIELDS TERMINATED BY '\\t'", true},
+		{"INSERT INTO users (id)\nSELECT user_id FROM shop.sessions", true},
+		{"ALTER TABLE orders ADD CONSTRAINT fk FOREIGN KEY (s) REFERENCES sessions (id)", true},
+		{"ALTER TABLE `users` ADD COLUMN `ip` varchar(45)", false},
+		{"INSERT INTO users VALUES (1, 'copied from sessions', 'it''s into sessions')", false},
+		{"INSERT INTO users VALUES (1) ON DUPLICATE KEY UPDATE sessions = sessions + 1", false},
+		{"CREATE DATABASE sessions", false},
+	}
+	for _, tc := range cases {
+		input := "DELIMITER /*!*/;\n" + query(tc.stmt) + "COMMIT/*!*/;\n"
+		var out bytes.Buffer
+		if _, err := Apply(strings.NewReader(input), &out, Rules{SkipTables: []string{"^sessions$"}}, 1024); err != nil {
+			t.Fatalf("%q: %v", tc.stmt, err)
+		}
+		want := input
+		if tc.drop {
+			want = strings.Replace(input, tc.stmt+"\n/*!*/;\n", "", 1)
+		}
+		if out.String() != want {
+			t.Errorf("%q: unexpected output:\n%s", tc.stmt, out.String())
+		}
+	}
+
+	// a statement longer than the buffer is judged by its beginning
+	long := "INSERT INTO sessions VALUES\n" + strings.Repeat("('tok', 1),\n", 20) + "('tok', 1)"
+	var out bytes.Buffer
+	if _, err := Apply(strings.NewReader(query(long)+"COMMIT/*!*/;\n"), &out, Rules{SkipTables: []string{"^sessions$"}}, 64); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "tok") || !strings.HasSuffix(out.String(), "SET TIMESTAMP=1767261600/*!*/;\nCOMMIT/*!*/;\n") {
+		t.Fatalf("long statement not dropped:\n%s", out.String())
+	}
+}
+
+// checksummedRowsEvent builds a rows event with flags and a CRC32 trailer.
+func checksummedRowsEvent(tableID uint64, flags uint16, payload string) []byte {
+	ev := make([]byte, binlogHeaderLen+8)
+	ev[4] = 30
+	var id [8]byte
+	binary.LittleEndian.PutUint64(id[:], tableID)
+	copy(ev[binlogHeaderLen:], id[:6])
+	binary.LittleEndian.PutUint16(ev[binlogHeaderLen+6:], flags)
+	ev = append(ev, payload...)
+	binary.LittleEndian.PutUint32(ev[9:13], uint32(len(ev)+binlogChecksumLen))
+	return binary.LittleEndian.AppendUint32(ev, crc32.ChecksumIEEE(ev))
+}
+
+func TestApplyBinlogMovesStatementEnd(t *testing.T) {
+	tmUsers := binlogEvent(19, 92, "users")
+	tmSessions := binlogEvent(19, 91, "sessions")
+	rowsUsers := checksummedRowsEvent(92, 0, "row 1")
+	rowsSessions := checksummedRowsEvent(91, binlogStmtEndFlag, "tok")
+	encode := func(ev []byte) string { return base64.StdEncoding.EncodeToString(ev) + "\n" }
+
+	input := "DELIMITER /*!*/;\n" +
+		"# at 313\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 360 CRC32 0x00000000 \tTable_map: `shop`.`users` mapped to number 92\n" +
+		"# at 360\n" +
+		"#260101 10:00:00 server id 1  end_log_pos 400 CRC32 0x00000000 \tTable_map: `shop`.`sessions` mapped to number 91\n" +
+		"BINLOG '\n" + tmUsers + tmSessions + encode(rowsUsers) + encode(rowsSessions) + "'/*!*/;\n"
+	var out bytes.Buffer
+	if _, err := Apply(strings.NewReader(input), &out, Rules{SkipTables: []string{"^sessions$"}}, 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	block := out.String()[strings.Index(out.String(), "BINLOG '\n")+len("BINLOG '\n"):]
+	events, err := decodeBinlogBase64([]byte(strings.ReplaceAll(strings.TrimSuffix(block, "'/*!*/;\n"), "\n", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected the users Table_map and rows events, got %d events", len(events))
+	}
+	rows := events[1]
+	body := len(rows) - binlogChecksumLen
+	if binary.LittleEndian.Uint16(rows[binlogHeaderLen+6:])&binlogStmtEndFlag == 0 {
+		t.Fatal("the last kept rows event should end the statement")
+	}
+	if crc32.ChecksumIEEE(rows[:body]) != binary.LittleEndian.Uint32(rows[body:]) {
+		t.Fatal("checksum not recomputed")
+	}
+	if string(rows[binlogHeaderLen+8:body]) != "row 1" {
+		t.Fatalf("rows payload changed: %q", rows[binlogHeaderLen+8:body])
+	}
+}
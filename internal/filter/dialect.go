@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Dialects name the SQL flavour of a dump. DialectAuto looks at the header
+// comments the dump tools write and falls back to MySQL. DialectBinlog is
+// the text mysqlbinlog decodes binary logs into.
+const (
+	DialectAuto     = "auto"
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+	DialectBinlog   = "binlog"
+)
+
+// dialectSniffBytes is how much of a dump detectDialect looks at.
+const dialectSniffBytes = 4096
+
+var postgresMarkers = [][]byte{
+	[]byte("-- PostgreSQL database dump"),
+	[]byte("\nSET standard_conforming_strings = "),
+}
+
+// mysqlbinlog switches the delimiter before its first event.
+var binlogMarker = []byte("DELIMITER /*!*/;\n# at ")
+
+// sqlite3's .dump wraps everything in one transaction, after a PRAGMA when
+// foreign keys are off.
+var sqliteMarkers = [][]byte{
+	[]byte("BEGIN TRANSACTION;\n"),
+	[]byte("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n"),
+}
+
+// ValidateDialect reports an unknown dialect name.
+func ValidateDialect(name string) error {
+	switch name {
+	case "", DialectAuto, DialectMySQL, DialectPostgres, DialectSQLite, DialectBinlog:
+		return nil
+	default:
+		return fmt.Errorf("unknown SQL dialect %q", name)
+	}
+}
+
+// detectDialect guesses the dialect from the start of a dump.
+func detectDialect(head []byte) string {
+	if bytes.Contains(head, binlogMarker) {
+		return DialectBinlog
+	}
+	for _, marker := range postgresMarkers {
+		if bytes.Contains(head, marker) {
+			return DialectPostgres
+		}
+	}
+	if hasCopyFromStdin(head) {
+		return DialectPostgres
+	}
+	for _, marker := range sqliteMarkers {
+		if bytes.HasPrefix(head, marker) {
+			return DialectSQLite
+		}
+	}
+	return DialectMySQL
+}
+
+// hasCopyFromStdin reports whether head has a whole line that is a COPY ...
+// FROM stdin; statement, which starts the data of a table in pg_dump's plain
+// format. The same text inside a MySQL string value is not on a line of its
+// own.
+func hasCopyFromStdin(head []byte) bool {
+	for len(head) > 0 {
+		line, rest, complete := bytes.Cut(head, []byte("\n"))
+		if complete && bytes.HasPrefix(line, copyPrefix) && bytes.HasSuffix(line, copyFromStdin) {
+			return true
+		}
+		head = rest
+	}
+	return false
+}
+
+// quotedStatementTable returns the table named after prefix in line, where
+// identifiers are double-quoted as in PostgreSQL and SQLite, both as written
+// with quotes removed and without its schema. The slices alias line unless
+// the name is quoted.
+func quotedStatementTable(line, prefix []byte) (qualified, bare []byte, ok bool) {
+	if !bytes.HasPrefix(line, prefix) {
+		return nil, nil, false
+	}
+	rest := line[len(prefix):]
+	end, quoted := 0, false
+	for ; end < len(rest); end++ {
+		c := rest[end]
+		if c == '"' {
+			quoted = !quoted
+		} else if !quoted && (c == ' ' || c == '(') {
+			break
+		}
+	}
+	ident := rest[:end]
+	if bytes.IndexByte(ident, '"') < 0 {
+		return ident, ident[bytes.LastIndexByte(ident, '.')+1:], true
+	}
+
+	out := make([]byte, 0, len(ident))
+	part := 0
+	quoted = false
+	for i := 0; i < len(ident); i++ {
+		c := ident[i]
+		switch {
+		case c == '"' && quoted && i+1 < len(ident) && ident[i+1] == '"':
+			out = append(out, '"')
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			out = append(out, c)
+			part = len(out)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, out[part:], true
+}
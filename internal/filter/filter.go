@@ -5,34 +5,151 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"maps"
 	"regexp"
+	"slices"
+)
+
+const ioBufferSize = 64 * 1024
+
+var (
+	insertPrefix      = []byte("INSERT INTO ")
+	createTablePrefix = []byte("CREATE TABLE ")
 )
 
 type Stats struct {
 	TotalLines    int
 	FilteredLines int
+	MaskedValues  int
 }
 
-func InsertFilter(r io.Reader, w io.Writer, skipTables []string, maxLineBytes int) (Stats, error) {
-	patterns := make([]*regexp.Regexp, 0, len(skipTables))
-	for _, pat := range skipTables {
-		re, err := regexp.Compile(pat)
+// Rules is what the filter does to one dump: INSERT data of tables matching
+// a SkipTables pattern is dropped, as is, when KeepTables is set, data of
+// tables matching none of its patterns. Samples thin out the rows of single
+// tables, and Masks rewrite single columns of the rows that are kept. Columns seeds the column order of tables whose CREATE
+// TABLE lives outside the dump, as in mydumper's per-table schema files.
+// Dialect selects the statement syntax; empty means DialectAuto. MaskKey,
+// when set, keys the hash mask strategy: values are hashed with
+// HMAC-SHA256 instead of plain SHA-256, so that they cannot be recovered by
+// hashing guesses.
+type Rules struct {
+	SkipTables []string
+	KeepTables []string
+	Samples    []Sample
+	Masks      []Mask
+	Columns    map[string][]string
+	Dialect    string
+	MaskKey    []byte
+}
+
+// SamplesTable reports whether r keeps only a sample of table's rows.
+func (r Rules) SamplesTable(table string) bool {
+	for _, s := range r.Samples {
+		if s.Table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// MasksTable reports whether r masks a column of table.
+func (r Rules) MasksTable(table string) bool {
+	for _, m := range r.Masks {
+		if m.Table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanColumns returns the column order of every CREATE TABLE in a DDL stream.
+func ScanColumns(r io.Reader) (map[string][]string, error) {
+	s := &schema{columns: map[string][]string{}}
+	reader := newLineReader(r)
+	for {
+		line, complete, err := reader.head()
+		if err == io.EOF {
+			return s.columns, nil
+		}
 		if err != nil {
-			return Stats{}, fmt.Errorf("invalid pattern %q: %w", pat, err)
+			return nil, err
+		}
+		s.observe(trimEOL(line), complete)
+		if !complete {
+			if _, err := reader.copyRest(nil, 0); err != nil {
+				return nil, err
+			}
 		}
-		patterns = append(patterns, re)
 	}
+}
 
-	reInsert := regexp.MustCompile(`^INSERT INTO ` + "`?" + `([^` + "`" + ` ]*)` + "`?")
-	reader := bufio.NewReaderSize(r, 64*1024)
-	writer := bufio.NewWriterSize(w, 64*1024)
+// InsertFilter copies a SQL dump from r to w, dropping INSERT statements for
+// tables that match skipTables. Lines are streamed in chunks, so pass-through
+// and dropped statements may be arbitrarily long; maxLineBytes only bounds
+// transforms that need a whole row in memory.
+func InsertFilter(r io.Reader, w io.Writer, skipTables []string, maxLineBytes int) (Stats, error) {
+	return Apply(r, w, Rules{SkipTables: skipTables}, maxLineBytes)
+}
+
+// Apply is InsertFilter with the full rule set. Masked columns are located
+// through the column list of the INSERT or, without one, through the
+// preceding CREATE TABLE statement; an INSERT into a masked table whose
+// columns are unknown fails the run rather than leak the values.
+func Apply(r io.Reader, w io.Writer, rules Rules, maxLineBytes int) (Stats, error) {
+	matcher, err := newTableMatcher(rules.SkipTables, rules.KeepTables)
+	if err != nil {
+		return Stats{}, err
+	}
+	if err := ValidateDialect(rules.Dialect); err != nil {
+		return Stats{}, err
+	}
+
+	reader := newLineReader(r)
+	dialect := rules.Dialect
+	if dialect == "" || dialect == DialectAuto {
+		dialect = detectDialect(reader.peek(dialectSniffBytes))
+	}
+	if len(rules.Samples) > 0 && dialect != DialectPostgres {
+		return Stats{}, fmt.Errorf("row sampling is only supported for %s dumps, this one is %s", DialectPostgres, dialect)
+	}
+	switch dialect {
+	case DialectPostgres:
+		return applyPostgres(reader, w, matcher, rules)
+	case DialectSQLite:
+		return applyInserts(reader, w, matcher, rules, sqliteSyntax)
+	case DialectBinlog:
+		return applyBinlog(reader, w, matcher, rules, maxLineBytes)
+	default:
+		return applyInserts(reader, w, matcher, rules, mysqlSyntax)
+	}
+}
+
+// insertSyntax is what tells the dialects whose data is INSERT statements
+// apart.
+type insertSyntax struct {
+	insertTable        func(line []byte) ([]byte, bool)
+	observe            func(s *schema, line []byte, complete bool)
+	noBackslashEscapes bool
+}
+
+var mysqlSyntax = insertSyntax{
+	insertTable: insertTable,
+	observe:     (*schema).observe,
+}
+
+func applyInserts(reader *lineReader, w io.Writer, matcher *tableMatcher, rules Rules, syntax insertSyntax) (Stats, error) {
+	schema := newSchema(rules.Masks, rules.Columns, rules.MaskKey)
+	writer := bufio.NewWriterSize(w, ioBufferSize)
 	defer writer.Flush()
 
-	var stats Stats
-	insideInsertBlock := false
+	var (
+		stats             Stats
+		insideInsertBlock bool
+		active            *masker // masked INSERT continuing past its first line
+	)
 
 	for {
-		line, err := readLine(reader, maxLineBytes)
+		line, complete, err := reader.head()
 		if err == io.EOF {
 			break
 		}
@@ -41,61 +158,341 @@ func InsertFilter(r io.Reader, w io.Writer, skipTables []string, maxLineBytes in
 		}
 
 		stats.TotalLines++
-		trimmed := bytes.TrimSuffix(line, []byte("\n"))
-		trimmed = bytes.TrimSuffix(trimmed, []byte("\r"))
-
-		if matches := reInsert.FindSubmatch(trimmed); matches != nil {
-			tableName := string(matches[1])
-			skipThis := false
-			for _, re := range patterns {
-				if re.MatchString(tableName) {
-					skipThis = true
-					break
+		trimmed := trimEOL(line)
+		last := lastByte(trimmed, 0)
+
+		drop := insideInsertBlock
+		tableName, isInsert := syntax.insertTable(trimmed)
+		if isInsert && matcher.skip(tableName) {
+			drop = true
+		}
+
+		var out io.Writer
+		if !drop {
+			out = writer
+			rest := line
+			switch {
+			case active != nil:
+				out = active
+			case isInsert:
+				m, body, err := schema.masker(writer, tableName, line)
+				if err != nil {
+					return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+				}
+				if m != nil {
+					m.noBackslashEscapes = syntax.noBackslashEscapes
+					if _, err := writer.Write(line[:body]); err != nil {
+						return stats, fmt.Errorf("write output: %w", err)
+					}
+					active, out, rest = m, m, line[body:]
 				}
+			default:
+				syntax.observe(schema, trimmed, complete)
 			}
-			if skipThis {
-				stats.FilteredLines++
-				insideInsertBlock = !bytes.HasSuffix(trimmed, []byte(";"))
-				continue
+			if _, err := out.Write(rest); err != nil {
+				return stats, fmt.Errorf("write output: %w", err)
 			}
 		}
-
-		if insideInsertBlock {
-			stats.FilteredLines++
-			if bytes.HasSuffix(trimmed, []byte(";")) {
-				insideInsertBlock = false
+		if !complete {
+			if last, err = reader.copyRest(out, last); err != nil {
+				return stats, err
 			}
-			continue
 		}
 
-		if _, err := writer.Write(line); err != nil {
-			return stats, fmt.Errorf("write output: %w", err)
+		if drop {
+			stats.FilteredLines++
+			insideInsertBlock = last != ';'
+		}
+		if active != nil && active.done {
+			stats.MaskedValues += active.masked
+			active = nil
 		}
 	}
 
 	return stats, nil
 }
 
-func readLine(r *bufio.Reader, maxLineBytes int) ([]byte, error) {
-	var buf []byte
-	for {
-		chunk, err := r.ReadSlice('\n')
-		buf = append(buf, chunk...)
-		if len(buf) > maxLineBytes {
-			return nil, fmt.Errorf("line exceeds MAX_LINE_BYTES=%d", maxLineBytes)
+// schema remembers the column order of the tables that have masks, as
+// declared by CREATE TABLE statements seen so far. A schema with a nil masks
+// map, as used by ScanColumns, tracks every table.
+type schema struct {
+	masks    map[string]map[string]string // table -> column -> strategy
+	maskKey  []byte
+	columns  map[string][]string
+	creating string // table whose column definitions are being read
+
+	definition []byte // CREATE TABLE statement collected so far, for SQLite
+}
+
+func newSchema(masks []Mask, columns map[string][]string, maskKey []byte) *schema {
+	s := &schema{masks: map[string]map[string]string{}, maskKey: maskKey, columns: maps.Clone(columns)}
+	if s.columns == nil {
+		s.columns = map[string][]string{}
+	}
+	for _, m := range masks {
+		if s.masks[m.Table] == nil {
+			s.masks[m.Table] = map[string]string{}
 		}
-		if err == nil {
-			return buf, nil
+		s.masks[m.Table][m.Column] = m.Strategy
+	}
+	return s
+}
+
+// observe tracks "CREATE TABLE `t` (" and the "  `col` type," lines after it.
+func (s *schema) observe(line []byte, complete bool) {
+	if (s.masks != nil && len(s.masks) == 0) || !complete {
+		return
+	}
+	if s.creating != "" {
+		switch {
+		case bytes.HasPrefix(line, []byte("  `")):
+			name, _, _ := bytes.Cut(line[3:], []byte("`"))
+			s.columns[s.creating] = append(s.columns[s.creating], string(name))
+		case bytes.HasPrefix(line, []byte(")")):
+			s.creating = ""
 		}
-		if err == bufio.ErrBufferFull {
-			continue
+		return
+	}
+	if !bytes.HasPrefix(line, createTablePrefix) {
+		return
+	}
+	rest := bytes.TrimPrefix(line[len(createTablePrefix):], []byte("IF NOT EXISTS "))
+	name, _, _ := bytes.Cut(bytes.TrimPrefix(rest, []byte("`")), []byte("`"))
+	if _, ok := s.masks[string(name)]; ok || s.masks == nil {
+		s.creating = string(name)
+		s.columns[s.creating] = nil
+	}
+}
+
+// masker returns a masker for an INSERT into table, or nil when the table
+// has no masks, along with the offset in line where its rows start.
+func (s *schema) masker(w io.Writer, table, line []byte) (*masker, int, error) {
+	if _, ok := s.masks[string(table)]; !ok {
+		return nil, 0, nil
+	}
+	columns, body, err := columnList(line)
+	if err != nil {
+		return nil, 0, err
+	}
+	fromSchema := columns == nil
+	if fromSchema {
+		columns = s.columns[string(table)]
+	}
+	byIndex, err := s.maskIndexes(string(table), columns, fromSchema)
+	if err != nil {
+		return nil, 0, err
+	}
+	return newMasker(w, byIndex, s.maskKey), body, nil
+}
+
+// maskIndexes maps the masks of table to positions in columns. When
+// complete, columns lists every column of the table, as CREATE TABLE does,
+// and a masked column missing from it is an error.
+func (s *schema) maskIndexes(table string, columns []string, complete bool) (map[int]string, error) {
+	if columns == nil {
+		return nil, fmt.Errorf("table %s has masked columns but no CREATE TABLE or column list precedes its data", table)
+	}
+	masks := s.masks[table]
+	byIndex := make(map[int]string, len(masks))
+	for i, name := range columns {
+		if strategy, ok := masks[name]; ok {
+			byIndex[i] = strategy
 		}
-		if err == io.EOF {
-			if len(buf) == 0 {
-				return nil, io.EOF
+	}
+	// an explicit column list may leave a masked column to its default
+	if complete && len(byIndex) < len(masks) {
+		for name := range masks {
+			if !slices.Contains(columns, name) {
+				return nil, fmt.Errorf("masked column %s.%s does not exist", table, name)
 			}
-			return buf, nil
 		}
-		return nil, fmt.Errorf("read line: %w", err)
+	}
+	return byIndex, nil
+}
+
+// TableMatcher reports which tables a rule set drops all INSERT data of.
+// It compiles the SkipTables and KeepTables patterns once, so callers deciding table by
+// table should build one per rule set and reuse it. It is not safe for
+// concurrent use.
+type TableMatcher struct {
+	matcher *tableMatcher
+}
+
+// NewTableMatcher compiles the SkipTables and KeepTables patterns of r.
+func (r Rules) NewTableMatcher() (*TableMatcher, error) {
+	matcher, err := newTableMatcher(r.SkipTables, r.KeepTables)
+	if err != nil {
+		return nil, err
+	}
+	return &TableMatcher{matcher: matcher}, nil
+}
+
+// Skips reports whether table's INSERT data is dropped.
+func (m *TableMatcher) Skips(table string) bool {
+	return m.matcher.skip([]byte(table))
+}
+
+// tableMatcher answers "should this table's data be dropped": it is when a
+// skip pattern matches, or when there are keep patterns and none matches.
+// Matches are memoized per table name, so every pattern runs at most once
+// per table per run.
+type tableMatcher struct {
+	skips, keeps []*regexp.Regexp
+	cache        map[string]tableMatch
+}
+
+type tableMatch struct {
+	skip, keep bool
+}
+
+func newTableMatcher(skipTables, keepTables []string) (*tableMatcher, error) {
+	skips, err := compilePatterns(skipTables)
+	if err != nil {
+		return nil, err
+	}
+	keeps, err := compilePatterns(keepTables)
+	if err != nil {
+		return nil, err
+	}
+	return &tableMatcher{skips: skips, keeps: keeps, cache: make(map[string]tableMatch)}, nil
+}
+
+func compilePatterns(pats []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(pats))
+	for _, pat := range pats {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pat, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+func (m *tableMatcher) skip(tableName []byte) bool {
+	return m.skipEither(tableName, tableName)
+}
+
+// skipEither decides for a table that goes by two names, such as
+// "schema.table" and "table": a skip pattern matching either name drops it,
+// and a keep pattern matching either name keeps it.
+func (m *tableMatcher) skipEither(qualified, bare []byte) bool {
+	if len(m.skips) == 0 && len(m.keeps) == 0 {
+		return false
+	}
+	q, b := m.match(qualified), m.match(bare)
+	if q.skip || b.skip {
+		return true
+	}
+	return len(m.keeps) > 0 && !q.keep && !b.keep
+}
+
+func (m *tableMatcher) match(tableName []byte) tableMatch {
+	// The string(...) conversion in a map index expression does not allocate.
+	if match, ok := m.cache[string(tableName)]; ok {
+		return match
+	}
+	match := tableMatch{skip: anyMatch(m.skips, tableName), keep: anyMatch(m.keeps, tableName)}
+	m.cache[string(tableName)] = match
+	return match
+}
+
+func anyMatch(patterns []*regexp.Regexp, name []byte) bool {
+	for _, re := range patterns {
+		if re.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertTable extracts the table name from an "INSERT INTO `name` ..." line.
+// The returned slice aliases line.
+func insertTable(line []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(line, insertPrefix) {
+		return nil, false
+	}
+	rest := line[len(insertPrefix):]
+	if len(rest) > 0 && rest[0] == '`' {
+		rest = rest[1:]
+	}
+	end := 0
+	for end < len(rest) && rest[end] != '`' && rest[end] != ' ' {
+		end++
+	}
+	return rest[:end], true
+}
+
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r"))
+}
+
+// lastByte returns the final byte of b, or fallback when b is empty.
+func lastByte(b []byte, fallback byte) byte {
+	if len(b) == 0 {
+		return fallback
+	}
+	return b[len(b)-1]
+}
+
+// lineReader yields lines as slices of its bufio buffer without copying.
+// A line longer than the buffer is returned as a head chunk, and the caller
+// streams the remainder with copyRest, so no line is ever held in memory whole.
+type lineReader struct {
+	r *bufio.Reader
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, ioBufferSize)}
+}
+
+// head returns the start of the next line and whether it holds the whole
+// line. The slice is only valid until the next read.
+func (lr *lineReader) head() ([]byte, bool, error) {
+	chunk, err := lr.r.ReadSlice('\n')
+	switch err {
+	case nil:
+		return chunk, true, nil
+	case bufio.ErrBufferFull:
+		return chunk, false, nil
+	case io.EOF:
+		if len(chunk) == 0 {
+			return nil, false, io.EOF
+		}
+		return chunk, true, nil
+	default:
+		return nil, false, fmt.Errorf("read line: %w", err)
+	}
+}
+
+// peek returns up to n bytes ahead without consuming them.
+func (lr *lineReader) peek(n int) []byte {
+	head, _ := lr.r.Peek(n)
+	return head
+}
+
+// copyRest streams the remainder of a line started by head to w, or discards
+// it when w is nil. It returns the last byte before the line terminator,
+// starting from last, the corresponding byte of the head chunk.
+func (lr *lineReader) copyRest(w io.Writer, last byte) (byte, error) {
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		if len(chunk) > 0 {
+			last = lastByte(trimEOL(chunk), last)
+			if w != nil {
+				if _, werr := w.Write(chunk); werr != nil {
+					return last, fmt.Errorf("write output: %w", werr)
+				}
+			}
+		}
+		switch err {
+		case nil, io.EOF:
+			return last, nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return last, fmt.Errorf("read line: %w", err)
+		}
 	}
 }
@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/d00p1/filtrate-backups/internal/dumpgen"
+)
+
+var (
+	benchDumpOnce sync.Once
+	benchDump     []byte
+)
+
+// benchmarkDump returns a 32MB dumpgen payload shared by all benchmarks.
+func benchmarkDump(b *testing.B) []byte {
+	b.Helper()
+	benchDumpOnce.Do(func() {
+		var buf bytes.Buffer
+		tables := []string{"users", "orders", "tmp_events", "log_audit"}
+		if _, err := dumpgen.WriteSQL(&buf, tables, 32*1000*1000, 1000, 128, rand.New(rand.NewSource(1))); err != nil {
+			b.Fatalf("generate dump: %v", err)
+		}
+		benchDump = buf.Bytes()
+	})
+	return benchDump
+}
+
+func benchmarkInsertFilter(b *testing.B, data []byte) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := InsertFilter(bytes.NewReader(data), io.Discard, []string{"^tmp_", "^log_"}, 8*1024*1024); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertFilterExtendedInserts(b *testing.B) {
+	benchmarkInsertFilter(b, benchmarkDump(b))
+}
+
+func BenchmarkInsertFilterShortLines(b *testing.B) {
+	var buf bytes.Buffer
+	tables := []string{"users", "orders", "tmp_events", "log_audit"}
+	if _, err := dumpgen.WriteSQL(&buf, tables, 16*1000*1000, 1, 64, rand.New(rand.NewSource(1))); err != nil {
+		b.Fatalf("generate dump: %v", err)
+	}
+	benchmarkInsertFilter(b, buf.Bytes())
+}
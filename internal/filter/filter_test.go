@@ -2,6 +2,10 @@ package filter
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"strings"
 	"testing"
 )
@@ -29,12 +33,175 @@ func TestInsertFilterLargeLines(t *testing.T) {
 	}
 }
 
-func TestInsertFilterFailsWhenLineLimitExceeded(t *testing.T) {
-	longValue := strings.Repeat("x", 2048)
-	input := "INSERT INTO `tmp_log` VALUES ('" + longValue + "');\n"
+func TestInsertFilterStreamsLinesBeyondLimit(t *testing.T) {
+	longValue := strings.Repeat("x", 3*ioBufferSize)
+	keptInsert := "INSERT INTO `users` VALUES ('" + longValue + "');\r\n"
+	input := "INSERT INTO `tmp_log` VALUES ('" + longValue + "'),\n" +
+		"('" + longValue + "');\n" +
+		keptInsert +
+		"-- " + longValue + "\n" +
+		"CREATE TABLE `after` (id int);"
 
-	_, err := InsertFilter(strings.NewReader(input), &bytes.Buffer{}, []string{"^tmp_"}, 1024)
-	if err == nil {
-		t.Fatalf("expected line-limit error")
+	var out bytes.Buffer
+	stats, err := InsertFilter(strings.NewReader(input), &out, []string{"^tmp_"}, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := keptInsert + "-- " + longValue + "\n" + "CREATE TABLE `after` (id int);"
+	if out.String() != want {
+		t.Fatalf("unexpected output (len %d, want %d)", out.Len(), len(want))
+	}
+	if stats.TotalLines != 5 || stats.FilteredLines != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestInsertFilterMultiLineInsertAndCache(t *testing.T) {
+	input := "INSERT INTO `tmp_a` VALUES (1),\n(2),\n(3);\n" +
+		"INSERT INTO users VALUES (1);\n" +
+		"INSERT INTO `tmp_a` VALUES (4);\n" +
+		"-- done\n"
+
+	var out bytes.Buffer
+	stats, err := InsertFilter(strings.NewReader(input), &out, []string{"^tmp_"}, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "INSERT INTO users VALUES (1);\n-- done\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	if stats.TotalLines != 6 || stats.FilteredLines != 4 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestApplyMasksColumns(t *testing.T) {
+	input := "CREATE TABLE `accounts` (\n" +
+		"  `id` int NOT NULL,\n" +
+		"  `email` varchar(255) DEFAULT NULL,\n" +
+		"  `password` varchar(64) NOT NULL,\n" +
+		"  `note` text,\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB;\n" +
+		"INSERT INTO `accounts` VALUES (1,'a@x.io','s3cr,t','it''s (fine)'),(2,NULL,'p\\'w;d',NULL);\n" +
+		"INSERT INTO `accounts` (`password`,`id`) VALUES ('multi\nline',3),\n('x',4);\n" +
+		"INSERT INTO `users` VALUES (1,'keep');\n"
+
+	rules := Rules{Masks: []Mask{
+		{Table: "accounts", Column: "email", Strategy: MaskHash},
+		{Table: "accounts", Column: "password", Strategy: MaskRedact},
+	}}
+	var out bytes.Buffer
+	stats, err := Apply(strings.NewReader(input), &out, rules, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("'a@x.io'"))
+	want := strings.Split(input, "INSERT")[0] +
+		"INSERT INTO `accounts` VALUES (1,'" + hex.EncodeToString(sum[:]) + "','REDACTED','it''s (fine)'),(2,NULL,'REDACTED',NULL);\n" +
+		"INSERT INTO `accounts` (`password`,`id`) VALUES ('REDACTED',3),\n('REDACTED',4);\n" +
+		"INSERT INTO `users` VALUES (1,'keep');\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if stats.MaskedValues != 5 {
+		t.Fatalf("expected 5 masked values, got %d", stats.MaskedValues)
+	}
+}
+
+func TestApplyMaskHashWithKey(t *testing.T) {
+	input := "INSERT INTO `accounts` (`id`,`email`) VALUES (1,'a@x.io'),(2,NULL);\n"
+	rules := Rules{
+		Masks:   []Mask{{Table: "accounts", Column: "email", Strategy: MaskHash}},
+		MaskKey: []byte("pepper"),
+	}
+	var out bytes.Buffer
+	if _, err := Apply(strings.NewReader(input), &out, rules, 1024); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("pepper"))
+	mac.Write([]byte("'a@x.io'"))
+	want := "INSERT INTO `accounts` (`id`,`email`) VALUES (1,'" + hex.EncodeToString(mac.Sum(nil)) + "'),(2,NULL);\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestApplyMaskFailsClosed(t *testing.T) {
+	mask := Rules{Masks: []Mask{{Table: "accounts", Column: "password", Strategy: MaskNull}}}
+	for name, input := range map[string]string{
+		"unknown columns": "INSERT INTO `accounts` VALUES (1,'pw');\n",
+		"missing column":  "CREATE TABLE `accounts` (\n  `id` int\n);\nINSERT INTO `accounts` VALUES (1);\n",
+	} {
+		if _, err := Apply(strings.NewReader(input), io.Discard, mask, 1024); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParseMask(t *testing.T) {
+	m, err := ParseMask("users.email")
+	if err != nil || m != (Mask{Table: "users", Column: "email", Strategy: MaskNull}) {
+		t.Fatalf("unexpected mask %+v, %v", m, err)
+	}
+	for _, spec := range []string{"users", "users.email=shred", ".email"} {
+		if _, err := ParseMask(spec); err == nil {
+			t.Fatalf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestMaskTSV(t *testing.T) {
+	input := "1\ta@x.io\tpw\\\tx\n" +
+		"2\t\\N\tmulti\\\nline\tkeep\n"
+
+	var (
+		out     bytes.Buffer
+		offsets []int64
+	)
+	stats, err := MaskTSV(strings.NewReader(input), &out, map[int]string{1: MaskHash, 2: MaskRedact}, nil, func(offset int64) error {
+		offsets = append(offsets, offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("a@x.io"))
+	row1 := "1\t" + hex.EncodeToString(sum[:]) + "\tREDACTED\n"
+	want := row1 + "2\t\\N\tREDACTED\tkeep\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant:\n%q", out.String(), want)
+	}
+	if stats.TotalLines != 2 || stats.MaskedValues != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(offsets) != 2 || offsets[0] != int64(len(row1)) || offsets[1] != int64(len(want)) {
+		t.Fatalf("unexpected row offsets %v", offsets)
+	}
+
+	if _, err := MaskTSV(strings.NewReader("1\tx"), io.Discard, nil, nil, nil); err == nil {
+		t.Fatal("expected an unterminated row to fail")
+	}
+}
+
+func TestRulesTableMatcher(t *testing.T) {
+	m, err := Rules{SkipTables: []string{"^audit_", "^sessions$"}}.NewTableMatcher()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for table, want := range map[string]bool{"audit_log": true, "sessions": true, "sessions_old": false, "users": false} {
+		if got := m.Skips(table); got != want {
+			t.Fatalf("Skips(%q) = %v, want %v", table, got, want)
+		}
+	}
+
+	if _, err := (Rules{SkipTables: []string{"("}}).NewTableMatcher(); err == nil {
+		t.Fatal("expected an invalid pattern to fail")
 	}
 }
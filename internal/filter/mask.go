@@ -0,0 +1,281 @@
+package filter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Mask strategies replace a column value in every INSERT row. NULL values
+// stay NULL whatever the strategy.
+const (
+	MaskNull   = "null"
+	MaskEmpty  = "empty"
+	MaskRedact = "redact"
+	MaskHash   = "hash"
+)
+
+var maskReplacements = map[string][]byte{
+	MaskNull:   []byte("NULL"),
+	MaskEmpty:  []byte("''"),
+	MaskRedact: []byte("'REDACTED'"),
+}
+
+// Mask replaces the values of one column of one table, both matched by exact
+// name.
+type Mask struct {
+	Table    string
+	Column   string
+	Strategy string
+}
+
+// ParseMask parses "table.column" or "table.column=strategy". The strategy
+// defaults to MaskNull.
+func ParseMask(spec string) (Mask, error) {
+	target, strategy, _ := strings.Cut(strings.TrimSpace(spec), "=")
+	table, column, ok := strings.Cut(target, ".")
+	if !ok || table == "" || column == "" {
+		return Mask{}, fmt.Errorf("mask %q must look like table.column[=strategy]", spec)
+	}
+	m := Mask{Table: table, Column: column, Strategy: strings.ToLower(strategy)}
+	if m.Strategy == "" {
+		m.Strategy = MaskNull
+	}
+	if _, ok := maskReplacements[m.Strategy]; !ok && m.Strategy != MaskHash {
+		return Mask{}, fmt.Errorf("mask %q: unknown strategy %q (known: %s, %s, %s, %s)",
+			spec, m.Strategy, MaskNull, MaskEmpty, MaskRedact, MaskHash)
+	}
+	return m, nil
+}
+
+func (m Mask) String() string {
+	return m.Table + "." + m.Column + "=" + m.Strategy
+}
+
+// valuesKeyword ends the statement head of an INSERT.
+var valuesKeyword = []byte("VALUES")
+
+// columnList parses the optional "(`a`,`b`)" list that follows the table
+// name of an INSERT head and returns the index where the VALUES rows start.
+func columnList(line []byte) (columns []string, body int, err error) {
+	at := bytes.Index(line, valuesKeyword)
+	if at < 0 {
+		return nil, 0, fmt.Errorf("INSERT head has no VALUES within %d bytes", len(line))
+	}
+	head := line[len(insertPrefix):at]
+	if open := bytes.IndexByte(head, '('); open >= 0 {
+		if end := bytes.IndexByte(head[open:], ')'); end >= 0 {
+			for _, name := range bytes.Split(head[open+1:open+end], []byte(",")) {
+				columns = append(columns, string(bytes.Trim(bytes.TrimSpace(name), "`\"")))
+			}
+		}
+	}
+	return columns, at + len(valuesKeyword), nil
+}
+
+// masker rewrites the VALUES rows of one INSERT statement as they stream
+// through it. Only the bytes of the value being masked are looked at, so
+// rows and statements of any length pass in constant memory.
+type masker struct {
+	w       io.Writer
+	columns map[int]string // column index -> strategy
+
+	// noBackslashEscapes reads backslashes in literals as plain characters,
+	// as PostgreSQL and SQLite do
+	noBackslashEscapes bool
+
+	depth     int
+	column    int
+	quote     byte // open quote character, 0 outside literals
+	escaped   bool
+	quoteSeen bool // a quote that may close the literal or start a doubled quote
+	done      bool
+
+	value  maskedValue
+	masked int
+}
+
+func newMasker(w io.Writer, columns map[int]string, key []byte) *masker {
+	return &masker{w: w, columns: columns, value: maskedValue{key: key}}
+}
+
+// Write consumes the rows of the statement. Bytes after the terminating ';'
+// pass through unchanged.
+func (m *masker) Write(p []byte) (int, error) {
+	start := 0
+	for i := 0; i < len(p); i++ {
+		if m.done {
+			break
+		}
+		c := p[i]
+
+		if m.quote != 0 {
+			switch {
+			case m.escaped:
+				m.escaped = false
+			case m.quoteSeen:
+				m.quoteSeen = false
+				if c != m.quote {
+					m.quote = 0
+					i-- // c follows the literal; look at it again
+					continue
+				}
+			case c == '\\' && !m.noBackslashEscapes:
+				m.escaped = true
+			case c == m.quote:
+				m.quoteSeen = true
+			}
+			m.value.consume(c)
+			continue
+		}
+
+		switch {
+		case m.depth == 0 && c == ';':
+			m.done = true
+		case m.depth == 0 && c == '(':
+			m.depth, m.column = 1, 0
+			if err := m.beginValue(p[start : i+1]); err != nil {
+				return i, err
+			}
+			start = i + 1
+		case m.depth == 0:
+		case c == ' ' && m.value.strategy != "" && m.value.n == 0:
+			// keep the space after the comma, as pg_dump --inserts writes it
+			if _, err := m.w.Write(p[i : i+1]); err != nil {
+				return i, err
+			}
+		case c == '\'' || c == '"':
+			m.quote = c
+			m.value.consume(c)
+		case c == '(':
+			m.depth++
+			m.value.consume(c)
+		case m.depth == 1 && (c == ',' || c == ')'):
+			if err := m.endValue(p[start:i]); err != nil {
+				return i, err
+			}
+			start = i
+			if c == ')' {
+				m.depth = 0
+				continue
+			}
+			m.column++
+			if err := m.beginValue(p[start : i+1]); err != nil {
+				return i, err
+			}
+			start = i + 1
+		case c == ')':
+			m.depth--
+			m.value.consume(c)
+		default:
+			m.value.consume(c)
+		}
+	}
+
+	if m.value.strategy == "" {
+		if _, err := m.w.Write(p[start:]); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// beginValue flushes pending, which ends with the delimiter before the next
+// value, and starts consuming the value when its column is masked.
+func (m *masker) beginValue(pending []byte) error {
+	if m.value.strategy == "" {
+		if _, err := m.w.Write(pending); err != nil {
+			return err
+		}
+	}
+	m.value.reset(m.columns[m.column])
+	return nil
+}
+
+// endValue writes the replacement of a masked value, or flushes pending, the
+// unmasked bytes before the delimiter.
+func (m *masker) endValue(pending []byte) error {
+	strategy := m.value.strategy
+	m.value.strategy = ""
+	if strategy == "" {
+		_, err := m.w.Write(pending)
+		return err
+	}
+
+	if m.value.equalFold(sqlNull) {
+		_, err := m.w.Write(sqlNull)
+		return err
+	}
+	m.masked++
+	if strategy != MaskHash {
+		_, err := m.w.Write(maskReplacements[strategy])
+		return err
+	}
+	out := append([]byte{'\''}, m.value.sum()...)
+	_, err := m.w.Write(append(out, '\''))
+	return err
+}
+
+var sqlNull = []byte("NULL")
+
+// maskedValue collects what a mask strategy needs to know about a value as
+// its bytes stream past: the first few bytes, to recognize NULL, and a
+// running hash, keyed with key when it is not empty.
+type maskedValue struct {
+	strategy string // "" when the value is not masked
+	key      []byte
+	n        int
+	head     [8]byte
+	hasher   hash.Hash
+}
+
+func (v *maskedValue) reset(strategy string) {
+	v.strategy = strategy
+	v.n = 0
+	if strategy == MaskHash {
+		if v.hasher == nil && len(v.key) > 0 {
+			v.hasher = hmac.New(sha256.New, v.key)
+		} else if v.hasher == nil {
+			v.hasher = sha256.New()
+		}
+		v.hasher.Reset()
+	}
+}
+
+// consume records c as part of the value when it is being masked.
+func (v *maskedValue) consume(c byte) {
+	if v.strategy == "" {
+		return
+	}
+	if v.n < len(v.head) {
+		v.head[v.n] = c
+	}
+	v.n++
+	if v.strategy == MaskHash {
+		v.hasher.Write([]byte{c})
+	}
+}
+
+// equalFold reports whether the value, ignoring surrounding spaces, is lit.
+func (v *maskedValue) equalFold(lit []byte) bool {
+	if v.n > len(v.head) {
+		return false
+	}
+	return bytes.EqualFold(bytes.TrimSpace(v.head[:v.n]), lit)
+}
+
+// equal reports whether the value is exactly lit.
+func (v *maskedValue) equal(lit []byte) bool {
+	return v.n <= len(v.head) && bytes.Equal(v.head[:v.n], lit)
+}
+
+// sum is the hex SHA-256, or HMAC-SHA256 with key, of the value's bytes as
+// written in the dump.
+func (v *maskedValue) sum() []byte {
+	return hex.AppendEncode(nil, v.hasher.Sum(nil))
+}
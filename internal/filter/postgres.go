@@ -0,0 +1,225 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// pg_dump's plain format writes table data as COPY blocks,
+//
+//	COPY public.users (id, email) FROM stdin;
+//	1	a@b.c
+//	\.
+//
+// or, with --inserts and --column-inserts, as one INSERT per row. Tables are
+// schema-qualified; skip and keep patterns, samples and masks match either
+// "schema.table" or the bare table name. Samples pick COPY rows, and whole
+// statements of INSERT-style dumps, which are single rows unless pg_dump ran
+// with --rows-per-insert.
+var (
+	copyPrefix     = []byte("COPY ")
+	copyFromStdin  = []byte(" FROM stdin;")
+	copyTerminator = []byte(`\.`)
+	pgColumnIndent = []byte("    ")
+	pgConstraint   = []byte("    CONSTRAINT ")
+)
+
+func applyPostgres(reader *lineReader, w io.Writer, matcher *tableMatcher, rules Rules) (Stats, error) {
+	schema := newSchema(rules.Masks, rules.Columns, rules.MaskKey)
+	samplers := newRowSamplers(rules.Samples)
+	writer := bufio.NewWriterSize(w, ioBufferSize)
+	defer writer.Flush()
+
+	var (
+		stats    Stats
+		copying  bool        // inside a COPY ... FROM stdin block
+		dropping bool        // the current COPY block or INSERT is dropped
+		sampler  *rowSampler // samples the rows of the current COPY block
+		rows     *tsvMasker  // masks the rows of the current COPY block
+		active   *masker     // masked INSERT continuing past its first line
+	)
+
+	for {
+		line, complete, err := reader.head()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		stats.TotalLines++
+		trimmed := trimEOL(line)
+		last := lastByte(trimmed, 0)
+
+		var out io.Writer = writer
+		rest := line
+		endOfCopy, unsampled := false, false
+		switch {
+		case copying:
+			endOfCopy = complete && bytes.Equal(trimmed, copyTerminator)
+			unsampled = !endOfCopy && sampler != nil && !sampler.keep()
+			if rows != nil && !endOfCopy {
+				out = rows
+			}
+		case active != nil:
+			out = active
+		case dropping:
+			// a dropped INSERT continuing past its first line
+		default:
+			if qualified, bare, ok := quotedStatementTable(trimmed, copyPrefix); ok && complete && bytes.HasSuffix(trimmed, copyFromStdin) {
+				copying = true
+				dropping = matcher.skipEither(qualified, bare)
+				if !dropping {
+					sampler = lookupSampler(samplers, qualified, bare)
+					if rows, err = schema.copyMasker(writer, qualified, bare, trimmed); err != nil {
+						return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+					}
+				}
+				break
+			}
+			qualified, bare, ok := quotedStatementTable(trimmed, insertPrefix)
+			if !ok {
+				schema.observePostgres(trimmed, complete)
+				break
+			}
+			if dropping = matcher.skipEither(qualified, bare); dropping {
+				break
+			}
+			if sampler := lookupSampler(samplers, qualified, bare); sampler != nil && !sampler.keep() {
+				dropping = true
+				break
+			}
+			m, body, err := schema.masker(writer, schema.tableKey(qualified, bare), line)
+			if err != nil {
+				return stats, fmt.Errorf("line %d: %w", stats.TotalLines, err)
+			}
+			if m != nil {
+				m.noBackslashEscapes = true
+				if _, err := writer.Write(line[:body]); err != nil {
+					return stats, fmt.Errorf("write output: %w", err)
+				}
+				active, out, rest = m, m, line[body:]
+			}
+		}
+
+		if dropping || unsampled {
+			out = nil
+			stats.FilteredLines++
+		} else if _, err := out.Write(rest); err != nil {
+			return stats, fmt.Errorf("write output: %w", err)
+		}
+		if !complete {
+			if last, err = reader.copyRest(out, last); err != nil {
+				return stats, err
+			}
+		}
+
+		switch {
+		case endOfCopy:
+			copying, dropping, sampler = false, false, nil
+			if rows != nil {
+				stats.MaskedValues += rows.stats.MaskedValues
+				rows = nil
+			}
+		case dropping && !copying:
+			dropping = last != ';'
+		}
+		if active != nil && active.done {
+			stats.MaskedValues += active.masked
+			active = nil
+		}
+	}
+
+	return stats, nil
+}
+
+// lookupSampler returns the sampler of a table by its qualified name, else
+// by its bare one, or nil when the table is not sampled.
+func lookupSampler(samplers map[string]*rowSampler, qualified, bare []byte) *rowSampler {
+	if s, ok := samplers[string(qualified)]; ok {
+		return s
+	}
+	return samplers[string(bare)]
+}
+
+// tableKey picks the name masks refer to a table by: the qualified one if any
+// mask uses it, else the bare one.
+func (s *schema) tableKey(qualified, bare []byte) []byte {
+	if _, ok := s.masks[string(qualified)]; ok {
+		return qualified
+	}
+	return bare
+}
+
+// observePostgres tracks "CREATE TABLE schema.t (" and the "    col type,"
+// lines after it.
+func (s *schema) observePostgres(line []byte, complete bool) {
+	if len(s.masks) == 0 || !complete {
+		return
+	}
+	if s.creating != "" {
+		switch {
+		case bytes.HasPrefix(line, []byte(")")):
+			s.creating = ""
+		case bytes.HasPrefix(line, pgConstraint):
+		case bytes.HasPrefix(line, pgColumnIndent):
+			s.columns[s.creating] = append(s.columns[s.creating], pgColumnName(line[len(pgColumnIndent):]))
+		}
+		return
+	}
+	qualified, bare, ok := quotedStatementTable(line, createTablePrefix)
+	if !ok {
+		return
+	}
+	if key := s.tableKey(qualified, bare); s.masks[string(key)] != nil {
+		s.creating = string(key)
+		s.columns[s.creating] = nil
+	}
+}
+
+// pgColumnName returns the leading, possibly quoted, identifier of def.
+func pgColumnName(def []byte) string {
+	if len(def) == 0 || def[0] != '"' {
+		name, _, _ := bytes.Cut(def, []byte(" "))
+		return string(bytes.TrimSuffix(name, []byte(",")))
+	}
+	var name []byte
+	for i := 1; i < len(def); i++ {
+		if def[i] == '"' {
+			if i+1 < len(def) && def[i+1] == '"' {
+				i++
+			} else {
+				break
+			}
+		}
+		name = append(name, def[i])
+	}
+	return string(name)
+}
+
+// copyMasker returns a masker for the rows of a COPY block, or nil when the
+// table has no masks. pg_dump lists every column in the COPY head, so a
+// masked column missing from the list fails like one missing from CREATE
+// TABLE.
+func (s *schema) copyMasker(w io.Writer, qualified, bare, line []byte) (*tsvMasker, error) {
+	table := string(s.tableKey(qualified, bare))
+	if _, ok := s.masks[table]; !ok {
+		return nil, nil
+	}
+	head := bytes.TrimSuffix(line, copyFromStdin)
+	columns := s.columns[table]
+	if open := bytes.Index(head, []byte(" (")); open >= 0 && bytes.HasSuffix(head, []byte(")")) {
+		columns = columns[:0:0]
+		for _, name := range bytes.Split(head[open+2:len(head)-1], []byte(",")) {
+			columns = append(columns, pgColumnName(bytes.TrimSpace(name)))
+		}
+	}
+	byIndex, err := s.maskIndexes(table, columns, true)
+	if err != nil {
+		return nil, err
+	}
+	return newTSVMasker(w, byIndex, s.maskKey), nil
+}
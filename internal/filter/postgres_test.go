@@ -0,0 +1,172 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const pgHeader = "--\n-- PostgreSQL database dump\n--\n\nSET standard_conforming_strings = on;\n\n"
+
+func TestApplyPostgresCopyBlocks(t *testing.T) {
+	input := pgHeader +
+		"CREATE TABLE public.accounts (\n    id integer NOT NULL,\n    email text\n);\n\n" +
+		"COPY public.accounts (id, email) FROM stdin;\n" +
+		"1\ta@x.io\n" +
+		"2\t\\N\n" +
+		"3\ttab\\there\n" +
+		"\\.\n\n" +
+		"COPY audit.events (id, payload) FROM stdin;\n" +
+		"1\t{\"ip\": \"10.0.0.1\"}\n" +
+		"\\.\n\n" +
+		"COPY public.users (id) FROM stdin;\n" +
+		"7\n" +
+		"\\.\n"
+
+	rules := Rules{
+		SkipTables: []string{`^audit\.`},
+		Masks:      []Mask{{Table: "accounts", Column: "email", Strategy: MaskRedact}},
+	}
+	var out bytes.Buffer
+	stats, err := Apply(strings.NewReader(input), &out, rules, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := pgHeader +
+		"CREATE TABLE public.accounts (\n    id integer NOT NULL,\n    email text\n);\n\n" +
+		"COPY public.accounts (id, email) FROM stdin;\n" +
+		"1\tREDACTED\n" +
+		"2\t\\N\n" +
+		"3\tREDACTED\n" +
+		"\\.\n\n" +
+		"\n" +
+		"COPY public.users (id) FROM stdin;\n" +
+		"7\n" +
+		"\\.\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if stats.FilteredLines != 3 || stats.MaskedValues != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestApplyPostgresInserts(t *testing.T) {
+	input := "CREATE TABLE \"Shop\".\"Users\" (\n    id integer,\n    \"Email\" text\n);\n" +
+		"INSERT INTO \"Shop\".\"Users\" VALUES (1, 'back\\slash');\n" +
+		"INSERT INTO \"Shop\".\"Users\" (\"Email\", id) VALUES ('it''s', 2);\n" +
+		"INSERT INTO public.sessions VALUES (1, 'multi\nline;');\n" +
+		"INSERT INTO public.kept VALUES (1);\n"
+
+	rules := Rules{
+		Dialect:    DialectPostgres,
+		SkipTables: []string{"^sessions$"},
+		Masks:      []Mask{{Table: "Shop.Users", Column: "Email", Strategy: MaskNull}},
+	}
+	var out bytes.Buffer
+	stats, err := Apply(strings.NewReader(input), &out, rules, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "CREATE TABLE \"Shop\".\"Users\" (\n    id integer,\n    \"Email\" text\n);\n" +
+		"INSERT INTO \"Shop\".\"Users\" VALUES (1, NULL);\n" +
+		"INSERT INTO \"Shop\".\"Users\" (\"Email\", id) VALUES (NULL, 2);\n" +
+		"INSERT INTO public.kept VALUES (1);\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if stats.FilteredLines != 2 || stats.MaskedValues != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestApplyPostgresMaskFailsClosed(t *testing.T) {
+	input := pgHeader + "COPY public.accounts (id) FROM stdin;\n1\n\\.\n"
+	rules := Rules{Masks: []Mask{{Table: "accounts", Column: "email", Strategy: MaskNull}}}
+	if _, err := Apply(strings.NewReader(input), &bytes.Buffer{}, rules, 1024); err == nil {
+		t.Fatal("expected a masked column missing from COPY to fail")
+	}
+}
+
+func TestDetectDialectCopyFromStdin(t *testing.T) {
+	for head, want := range map[string]string{
+		"COPY public.users (id) FROM stdin;\n7\n":                            DialectPostgres,
+		"SELECT 1;\nCOPY users FROM stdin;\n":                                DialectPostgres,
+		"INSERT INTO `notes` VALUES (1,'run COPY x FROM stdin;\n later');\n": DialectMySQL,
+		"INSERT INTO `notes` VALUES (1,'\nCOPY x FROM stdin;');\n":           DialectMySQL,
+		"INSERT INTO `notes` VALUES (1,' FROM stdin;\n');\n":                 DialectMySQL,
+	} {
+		if got := detectDialect([]byte(head)); got != want {
+			t.Errorf("detectDialect(%q) = %s, want %s", head, got, want)
+		}
+	}
+}
+
+func TestApplyPostgresKeepAndSample(t *testing.T) {
+	var events, kept strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&events, "%d\tuser%d@x.io\n", i, i)
+		if i == 0 || i == 3 || i == 6 { // 30% of 10 rows, spread evenly
+			fmt.Fprintf(&kept, "%d\tREDACTED\n", i)
+		}
+	}
+	input := pgHeader +
+		"COPY public.events (id, email) FROM stdin;\n" + events.String() + "\\.\n" +
+		"COPY audit.log (id) FROM stdin;\n1\n\\.\n" +
+		"COPY public.users (id) FROM stdin;\n1\n2\n\\.\n" +
+		"INSERT INTO public.logs VALUES (1, 'a');\n" +
+		"INSERT INTO public.logs VALUES (2, 'multi\nline;');\n" +
+		"INSERT INTO public.logs VALUES (3, 'c');\n"
+
+	rules := Rules{
+		KeepTables: []string{`^(events|logs|public\.users)$`},
+		Samples:    []Sample{{Table: "events", Percent: 30}, {Table: "public.logs", Percent: 50}},
+		Masks:      []Mask{{Table: "events", Column: "email", Strategy: MaskRedact}},
+	}
+	var out bytes.Buffer
+	stats, err := Apply(strings.NewReader(input), &out, rules, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := pgHeader +
+		"COPY public.events (id, email) FROM stdin;\n" + kept.String() + "\\.\n" +
+		"COPY public.users (id) FROM stdin;\n1\n2\n\\.\n" +
+		"INSERT INTO public.logs VALUES (1, 'a');\n" +
+		"INSERT INTO public.logs VALUES (3, 'c');\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if stats.FilteredLines != 7+3+2 || stats.MaskedValues != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestApplySamplingNeedsPostgres(t *testing.T) {
+	rules := Rules{Samples: []Sample{{Table: "events", Percent: 10}}}
+	_, err := Apply(strings.NewReader("INSERT INTO `events` VALUES (1);\n"), io.Discard, rules, 1024)
+	if err == nil || !strings.Contains(err.Error(), "only supported for postgres") {
+		t.Fatalf("expected sampling a MySQL dump to fail, got %v", err)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	for spec, want := range map[string]Sample{
+		"events=10":          {Table: "events", Percent: 10},
+		" public.logs=2.5% ": {Table: "public.logs", Percent: 2.5},
+	} {
+		got, err := ParseSample(spec)
+		if err != nil || got != want {
+			t.Fatalf("ParseSample(%q) = %+v, %v", spec, got, err)
+		}
+	}
+	for _, spec := range []string{"events", "=10", "events=0", "events=101", "events=ten"} {
+		if _, err := ParseSample(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sample keeps a share of the rows of one table, matched by exact name like
+// masks. Rows are picked evenly and deterministically: the first row, then
+// one more whenever Percent of the rows read so far adds up to another whole
+// row, so identical dumps are sampled identically.
+type Sample struct {
+	Table   string
+	Percent float64
+}
+
+// ParseSample parses "table=percent", e.g. "events=10" or "events=2.5%".
+func ParseSample(spec string) (Sample, error) {
+	table, raw, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok || table == "" {
+		return Sample{}, fmt.Errorf("sample %q must look like table=percent", spec)
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(raw), "%"), 64)
+	if err != nil || math.IsNaN(percent) || percent*1e4 < 1 || percent > 100 {
+		return Sample{}, fmt.Errorf("sample %q: percent must be a number between 0.0001 and 100", spec)
+	}
+	return Sample{Table: table, Percent: percent}, nil
+}
+
+func (s Sample) String() string {
+	return s.Table + "=" + strconv.FormatFloat(s.Percent, 'f', -1, 64) + "%"
+}
+
+// rowSampler picks the rows of one table to keep.
+type rowSampler struct {
+	perMillion int64 // share of rows kept
+	rows       int64 // rows seen so far
+}
+
+func newRowSamplers(samples []Sample) map[string]*rowSampler {
+	samplers := make(map[string]*rowSampler, len(samples))
+	for _, s := range samples {
+		samplers[s.Table] = &rowSampler{perMillion: int64(math.Round(s.Percent * 1e4))}
+	}
+	return samplers
+}
+
+// keep reports whether the next row is kept: it is when the kept share,
+// rounded up, grows by a whole row with it.
+func (s *rowSampler) keep() bool {
+	n := s.rows
+	s.rows++
+	return ceilMillionths((n+1)*s.perMillion) > ceilMillionths(n*s.perMillion)
+}
+
+func ceilMillionths(v int64) int64 {
+	return (v + 1e6 - 1) / 1e6
+}
@@ -0,0 +1,136 @@
+package filter
+
+import (
+	"bytes"
+	"strings"
+)
+
+// sqlite3's .dump writes the CREATE TABLE statements as they were issued,
+// possibly over several lines, and one INSERT per row:
+//
+//	CREATE TABLE users(id INTEGER PRIMARY KEY, email TEXT);
+//	INSERT INTO users VALUES(1,'a@b.c');
+//	INSERT INTO "order items" VALUES(1,'x');
+//
+// Strings double their quotes and never escape with backslashes.
+var sqliteSyntax = insertSyntax{
+	insertTable:        sqliteInsertTable,
+	observe:            (*schema).observeSQLite,
+	noBackslashEscapes: true,
+}
+
+var (
+	ifNotExists = []byte("IF NOT EXISTS ")
+
+	// sqliteConstraints start table constraints rather than column definitions.
+	sqliteConstraints = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"}
+)
+
+func sqliteInsertTable(line []byte) ([]byte, bool) {
+	_, bare, ok := quotedStatementTable(line, insertPrefix)
+	return bare, ok
+}
+
+// observeSQLite collects the CREATE TABLE statement of a masked table up to
+// its closing ';' and reads the column names from it.
+func (s *schema) observeSQLite(line []byte, complete bool) {
+	if len(s.masks) == 0 || !complete {
+		return
+	}
+	if s.creating == "" {
+		if !bytes.HasPrefix(line, createTablePrefix) {
+			return
+		}
+		rest := bytes.TrimPrefix(line[len(createTablePrefix):], ifNotExists)
+		_, name, _ := quotedStatementTable(rest, nil)
+		if s.masks[string(name)] == nil {
+			return
+		}
+		s.creating = string(name)
+		s.definition = s.definition[:0]
+	}
+	s.definition = append(append(s.definition, line...), '\n')
+	if bytes.HasSuffix(bytes.TrimSpace(line), []byte(";")) {
+		s.columns[s.creating] = sqliteColumns(s.definition)
+		s.creating = ""
+	}
+}
+
+// sqliteColumns returns the column names declared in a CREATE TABLE
+// statement, or nil for CREATE TABLE ... AS SELECT.
+func sqliteColumns(stmt []byte) []string {
+	var (
+		columns []string
+		depth   int
+		quote   byte
+		start   int
+	)
+	for i, c := range stmt {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0 // a doubled quote reopens on the next byte
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+			if depth == 1 {
+				start = i + 1
+			}
+		case depth == 1 && (c == ',' || c == ')'):
+			if name, ok := sqliteColumnName(stmt[start:i]); ok {
+				columns = append(columns, name)
+			}
+			start = i + 1
+			if c == ')' {
+				return columns
+			}
+		case c == ')':
+			depth--
+		}
+	}
+	return columns
+}
+
+// sqliteColumnName returns the name a column definition starts with, and
+// false for a table constraint.
+func sqliteColumnName(def []byte) (string, bool) {
+	def = bytes.TrimSpace(def)
+	if len(def) == 0 {
+		return "", false
+	}
+	var closing byte
+	switch def[0] {
+	case '"', '`':
+		closing = def[0]
+	case '[':
+		closing = ']'
+	default:
+		end := bytes.IndexAny(def, " \t\n")
+		if end < 0 {
+			end = len(def)
+		}
+		word := string(def[:end])
+		for _, kw := range sqliteConstraints {
+			if strings.EqualFold(word, kw) {
+				return "", false
+			}
+		}
+		return word, true
+	}
+
+	var name []byte
+	for i := 1; i < len(def); i++ {
+		if def[i] == closing {
+			if closing == ']' || i+1 >= len(def) || def[i+1] != closing {
+				break
+			}
+			i++
+		}
+		name = append(name, def[i])
+	}
+	return string(name), true
+}
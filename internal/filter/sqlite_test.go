@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestApplySQLiteDump(t *testing.T) {
+	input := "PRAGMA foreign_keys=OFF;\n" +
+		"BEGIN TRANSACTION;\n" +
+		"CREATE TABLE accounts(\n  id INTEGER PRIMARY KEY,\n  \"e-mail\" TEXT,\n  note TEXT DEFAULT (lower('x, y')),\n  UNIQUE(\"e-mail\")\n);\n" +
+		"INSERT INTO accounts VALUES(1,'a@x.io','back\\slash');\n" +
+		"INSERT INTO accounts VALUES(2,replace('a\\nb','\\n',char(10)),'it''s');\n" +
+		"INSERT INTO accounts VALUES(3,NULL,NULL);\n" +
+		"CREATE TABLE \"session log\"(id, data);\n" +
+		"INSERT INTO \"session log\" VALUES(1,'tok');\n" +
+		"INSERT INTO sqlite_sequence VALUES('accounts',3);\n" +
+		"COMMIT;\n"
+
+	rules := Rules{
+		SkipTables: []string{"^session log$"},
+		Masks:      []Mask{{Table: "accounts", Column: "e-mail", Strategy: MaskRedact}},
+	}
+	var out bytes.Buffer
+	stats, err := Apply(strings.NewReader(input), &out, rules, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "PRAGMA foreign_keys=OFF;\n" +
+		"BEGIN TRANSACTION;\n" +
+		"CREATE TABLE accounts(\n  id INTEGER PRIMARY KEY,\n  \"e-mail\" TEXT,\n  note TEXT DEFAULT (lower('x, y')),\n  UNIQUE(\"e-mail\")\n);\n" +
+		"INSERT INTO accounts VALUES(1,'REDACTED','back\\slash');\n" +
+		"INSERT INTO accounts VALUES(2,'REDACTED','it''s');\n" +
+		"INSERT INTO accounts VALUES(3,NULL,NULL);\n" +
+		"CREATE TABLE \"session log\"(id, data);\n" +
+		"INSERT INTO sqlite_sequence VALUES('accounts',3);\n" +
+		"COMMIT;\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if stats.FilteredLines != 1 || stats.MaskedValues != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSQLiteColumns(t *testing.T) {
+	got := sqliteColumns([]byte("CREATE TABLE t([a b], `c`, \"d\"\"e\" INT CHECK (d > 0), CONSTRAINT pk PRIMARY KEY (a));"))
+	want := []string{"a b", "c", `d"e`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
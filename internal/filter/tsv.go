@@ -0,0 +1,130 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// tsvNull is how LOAD DATA's default dialect and PostgreSQL's COPY text
+// format write NULL.
+var tsvNull = []byte(`\N`)
+
+var tsvReplacements = map[string][]byte{
+	MaskNull:   tsvNull,
+	MaskEmpty:  nil,
+	MaskRedact: []byte("REDACTED"),
+}
+
+// MaskTSV copies rows in the LOAD DATA default dialect (fields terminated by
+// tab, lines by newline, escaped by backslash, not enclosed) from r to w and
+// replaces the fields at the masked column indexes. key keys the hash
+// strategy like Rules.MaskKey. rowEnd, when not nil, is
+// called with the uncompressed output offset after every row, which is what
+// MySQL Shell's chunk index files record.
+func MaskTSV(r io.Reader, w io.Writer, masks map[int]string, key []byte, rowEnd func(offset int64) error) (Stats, error) {
+	writer := bufio.NewWriterSize(w, ioBufferSize)
+	m := newTSVMasker(writer, masks, key)
+	m.rowEnd = rowEnd
+
+	if _, err := io.CopyBuffer(m, r, make([]byte, ioBufferSize)); err != nil {
+		return m.stats, err
+	}
+	if m.inRow {
+		return m.stats, fmt.Errorf("last row is not terminated by a newline")
+	}
+	if err := writer.Flush(); err != nil {
+		return m.stats, fmt.Errorf("write output: %w", err)
+	}
+	return m.stats, nil
+}
+
+// tsvMasker rewrites tab-separated rows as they stream through it, like
+// masker does for INSERT rows. Runs of unmasked bytes are passed on as they
+// are.
+type tsvMasker struct {
+	w      io.Writer
+	masks  map[int]string // column index -> strategy
+	rowEnd func(offset int64) error
+
+	offset  int64
+	column  int
+	inRow   bool
+	escaped bool
+	value   maskedValue
+	stats   Stats // TotalLines counts rows
+}
+
+func newTSVMasker(w io.Writer, masks map[int]string, key []byte) *tsvMasker {
+	m := &tsvMasker{w: w, masks: masks}
+	m.value.key = key
+	m.value.reset(masks[0])
+	return m
+}
+
+func (m *tsvMasker) Write(p []byte) (int, error) {
+	start := 0
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		m.inRow = true
+		switch {
+		case m.escaped:
+			m.escaped = false
+		case c == '\\':
+			m.escaped = true
+		case c == '\t' || c == '\n':
+			if m.value.strategy != "" {
+				if err := m.endField(); err != nil {
+					return i, err
+				}
+				start = i // the delimiter opens the next run
+			}
+			m.column++
+			if c == '\n' {
+				m.stats.TotalLines++
+				m.column, m.inRow = 0, false
+				if m.rowEnd != nil {
+					if err := m.rowEnd(m.offset + int64(i+1-start)); err != nil {
+						return i, err
+					}
+				}
+			}
+			m.value.reset(m.masks[m.column])
+			if m.value.strategy != "" {
+				if err := m.emit(p[start : i+1]); err != nil {
+					return i, err
+				}
+				start = i + 1
+			}
+			continue
+		}
+		m.value.consume(c)
+	}
+
+	if m.value.strategy == "" {
+		if err := m.emit(p[start:]); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// endField writes the replacement of the masked field that just ended.
+func (m *tsvMasker) endField() error {
+	if m.value.equal(tsvNull) {
+		return m.emit(tsvNull)
+	}
+	m.stats.MaskedValues++
+	if m.value.strategy == MaskHash {
+		return m.emit(m.value.sum())
+	}
+	return m.emit(tsvReplacements[m.value.strategy])
+}
+
+func (m *tsvMasker) emit(b []byte) error {
+	m.offset += int64(len(b))
+	if _, err := m.w.Write(b); err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+	return nil
+}
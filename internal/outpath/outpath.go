@@ -0,0 +1,177 @@
+// Package outpath expands output path templates such as
+// "s3://bucket/{{.Profile}}/{{.InputBase}}-{{.Date "2006-01-02"}}.tar.gz".
+package outpath
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/internal/discovery"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// Vars are the fields an output template can use.
+type Vars struct {
+	// InputName is the base name of the input, e.g. "db-2026-10-17.tar.gz";
+	// InputBase is InputName without archive and compression extensions,
+	// e.g. "db-2026-10-17".
+	InputName string
+	InputBase string
+	// InputTime is the modification time of the input, when known.
+	InputTime time.Time
+	// Time is the start of the run in UTC.
+	Time    time.Time
+	RunID   string
+	Profile string
+}
+
+// Date formats Time with a Go time layout.
+func (v Vars) Date(layout string) string {
+	return v.Time.Format(layout)
+}
+
+// Template is a parsed output path.
+type Template struct {
+	raw  string
+	tmpl *template.Template
+}
+
+// Parse parses an output path. A path without "{{" expands to itself.
+func Parse(raw string) (*Template, error) {
+	t := &Template{raw: raw}
+	if t.Static() {
+		return t, nil
+	}
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl = tmpl
+	// unknown fields only fail on execution
+	if _, err := t.Expand(Vars{InputName: "x", InputBase: "x", RunID: "x", Profile: "x"}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Static reports whether the path contains no template actions.
+func (t *Template) Static() bool {
+	return !strings.Contains(t.raw, "{{")
+}
+
+// Expand returns the output path for one run.
+func (t *Template) Expand(v Vars) (string, error) {
+	if t.tmpl == nil {
+		return t.raw, nil
+	}
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, v); err != nil {
+		return "", fmt.Errorf("expand output path: %w", err)
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("output path %q expands to nothing", t.raw)
+	}
+	return b.String(), nil
+}
+
+var (
+	runFields   = regexp.MustCompile(`\.(Time|Date|RunID|InputTime)\b`)
+	inputFields = regexp.MustCompile(`\.Input(Name|Base)\b`)
+)
+
+// Glob returns a path.Match pattern covering the outputs t expands to for
+// the series of inputs v belongs to across runs: actions using Time, Date,
+// RunID or InputTime become "*", and so does the timestamp in the input
+// name, as discovery.NameTime finds it with timeLayout, so that
+// "db-2026-10-17.tar.gz" and "db-2026-10-18.tar.gz" form one series. The
+// other actions expand as for v, and literal text is escaped.
+func (t *Template) Glob(v Vars, timeLayout string) (string, error) {
+	if t.tmpl == nil {
+		return escapeGlob(t.raw), nil
+	}
+	var b strings.Builder
+	for _, node := range t.tmpl.Tree.Root.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			b.WriteString(escapeGlob(string(n.Text)))
+		case *parse.ActionNode:
+			if runFields.MatchString(n.String()) {
+				b.WriteString("*")
+				continue
+			}
+			action, err := template.New("action").Option("missingkey=error").Parse(n.String())
+			if err != nil {
+				return "", err
+			}
+			var s strings.Builder
+			if err := action.Execute(&s, v); err != nil {
+				return "", fmt.Errorf("expand output path: %w", err)
+			}
+			expanded := s.String()
+			if loc := discovery.NameTimeIndex(expanded, timeLayout); loc != nil && inputFields.MatchString(n.String()) {
+				b.WriteString(escapeGlob(expanded[:loc[0]]) + "*" + escapeGlob(expanded[loc[1]:]))
+				continue
+			}
+			b.WriteString(escapeGlob(expanded))
+		default:
+			// conditionals and loops may expand to anything
+			b.WriteString("*")
+		}
+	}
+	return b.String(), nil
+}
+
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// InputVars returns the input fields for the input at location.
+func InputVars(location string) Vars {
+	name := "stdin"
+	if location != storage.StdioPath {
+		if u, err := storage.Parse(location); err == nil {
+			name = path.Base(strings.TrimSuffix(u.Path, "/"))
+		}
+	}
+	return Vars{InputName: name, InputBase: TrimExtensions(name)}
+}
+
+// TrimExtensions strips compression and archive extensions from a file
+// name: "db.tar.gz", "db.tgz", "db.sql.zst" and "db.zip" all become "db".
+func TrimExtensions(name string) string {
+	for {
+		trimmed := codec.TrimExtension(name)
+		ext := strings.ToLower(path.Ext(trimmed))
+		switch ext {
+		case ".tar", ".zip", ".sql", ".dump":
+			trimmed = trimmed[:len(trimmed)-len(ext)]
+		}
+		if trimmed == name || trimmed == "" {
+			return name
+		}
+		name = trimmed
+	}
+}
+
+// NewRunID returns a sortable, unique run ID such as
+// "20261017T030000Z-3f9a1c".
+func NewRunID(now time.Time) string {
+	var b [3]byte
+	_, _ = rand.Read(b[:])
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,84 @@
+package outpath
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpand(t *testing.T) {
+	run := time.Date(2026, 10, 17, 3, 4, 5, 0, time.UTC)
+	vars := InputVars("s3://backups/daily/db-2026-10-17T03-00.tar.gz")
+	vars.Time, vars.RunID, vars.Profile = run, "r1", "prod"
+
+	cases := map[string]string{
+		"./out/filtered.tar.gz": "./out/filtered.tar.gz",
+		`s3://clean/{{.Profile}}/{{.InputBase}}-{{.Date "2006-01-02"}}.tar.gz`: "s3://clean/prod/db-2026-10-17T03-00-2026-10-17.tar.gz",
+		"/out/{{.RunID}}/{{.InputName}}":                                       "/out/r1/db-2026-10-17T03-00.tar.gz",
+	}
+	for raw, want := range cases {
+		tmpl, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", raw, err)
+		}
+		if got, err := tmpl.Expand(vars); err != nil || got != want {
+			t.Errorf("Expand(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"/out/{{.Input}}.sql", "/out/{{.Date}}.sql", "/out/{{.InputBase"} {
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("Parse(%q) succeeded", raw)
+		}
+	}
+}
+
+func TestTrimExtensions(t *testing.T) {
+	for in, want := range map[string]string{
+		"db.tar.gz":  "db",
+		"db.tgz":     "db",
+		"db.sql.zst": "db",
+		"db.zip":     "db",
+		"db.v2.csv":  "db.v2.csv",
+		".sql":       ".sql",
+		"backup-dir": "backup-dir",
+	} {
+		if got := TrimExtensions(in); got != want {
+			t.Errorf("TrimExtensions(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if vars := InputVars("-"); vars.InputBase != "stdin" {
+		t.Errorf("stdin input named %q", vars.InputBase)
+	}
+	if id := NewRunID(time.Unix(0, 0)); !strings.HasPrefix(id, "19700101T000000Z-") || len(id) != 23 {
+		t.Errorf("unexpected run ID %q", id)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	vars := InputVars("/backups/db-2026-10-17.tar.gz")
+	vars.Profile = "shop"
+	cases := map[string]string{
+		`s3://clean/{{.Profile}}/{{.InputBase}}-{{.Date "2006-01-02"}}.tar.gz`: `s3://clean/shop/db-*-*.tar.gz`,
+		"/out/{{.RunID}}-{{.InputTime.Unix}}.sql":                              `/out/*-*.sql`,
+		"/out/[x]/{{.Profile}}.sql":                                            `/out/\[x]/shop.sql`,
+		"/out/{{.InputName}}":                                                  `/out/db-*.tar.gz`,
+	}
+	for raw, want := range cases {
+		tmpl, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", raw, err)
+		}
+		if got, err := tmpl.Glob(vars, ""); err != nil || got != want {
+			t.Errorf("Glob(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+
+	tmpl, _ := Parse("/out/{{.InputBase}}.sql")
+	if got, _ := tmpl.Glob(InputVars("/backups/db_20261017.sql.gz"), "20060102"); got != "/out/db_*.sql" {
+		t.Errorf("Glob with a time layout = %q", got)
+	}
+	if got, _ := tmpl.Glob(InputVars("/backups/db.sql.gz"), ""); got != "/out/db.sql" {
+		t.Errorf("Glob of an undated input = %q", got)
+	}
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/d00p1/filtrate-backups/internal/dump"
+	"github.com/d00p1/filtrate-backups/internal/filter"
+)
+
+// entryOutcome is what a layout did with one regular file.
+type entryOutcome int
+
+const (
+	entryUnhandled entryOutcome = iota // fall back to the generic treatment
+	entryCopied                        // written unchanged or rewritten, not SQL
+	entrySQL                           // written as part of the SQL stream
+	entryDropped                       // its data left out of the output
+)
+
+// layout is the file handling of one dump tool. filterDir hands it every
+// regular file and calls finish once the whole tree is processed, so that
+// index files can be rewritten to match what was kept.
+type layout interface {
+	process(srcDir, dstDir, rel string, opts Options) (entryOutcome, filter.Stats, error)
+	finish(srcDir, dstDir string) error
+}
+
+// layoutDetectors are tried in order by dump.LayoutAuto.
+var layoutDetectors = []struct {
+	name   string
+	detect func(srcDir string) bool
+}{
+	{dump.LayoutMydumper, isMydumper},
+	{dump.LayoutMysqlsh, isMysqlsh},
+}
+
+// resolveLayout picks the layout of the tree under srcDir, or nil for the
+// generic treatment.
+func resolveLayout(name, srcDir string) (layout, error) {
+	if name == "" || name == dump.LayoutAuto {
+		name = dump.LayoutGeneric
+		for _, d := range layoutDetectors {
+			if d.detect(srcDir) {
+				name = d.name
+				break
+			}
+		}
+	}
+	return newLayout(name)
+}
+
+func newLayout(name string) (layout, error) {
+	switch name {
+	case "", dump.LayoutAuto, dump.LayoutGeneric:
+		return nil, nil
+	case dump.LayoutMydumper:
+		return newMydumperLayout(), nil
+	case dump.LayoutMysqlsh:
+		return newMysqlshLayout(), nil
+	default:
+		return nil, fmt.Errorf("unknown dump layout %q", name)
+	}
+}
+
+// dumpRoots returns srcDir and its immediate subdirectories, where a dump
+// tool's top-level files may sit when the backup was archived with its
+// directory.
+func dumpRoots(srcDir string) []string {
+	roots := []string{srcDir}
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return roots
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			roots = append(roots, filepath.Join(srcDir, e.Name()))
+		}
+	}
+	return roots
+}
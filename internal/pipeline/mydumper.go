@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/d00p1/filtrate-backups/internal/filter"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+)
+
+// mydumper writes one directory per backup:
+//
+//	metadata                       binlog position and, since 0.12, per-table rows
+//	db-schema-create.sql           CREATE DATABASE
+//	db.table-schema.sql            CREATE TABLE, also -schema-triggers/-schema-view
+//	db.table.00001.sql             INSERT chunks, optionally .gz or .zst
+//	db.table.00001.dat             LOAD DATA chunks with --load-data
+//
+// Names are matched after stripping a compression extension.
+var (
+	mydumperSchemaFile = regexp.MustCompile(`^([^.]+)\.(.+)-schema(?:-[a-z]+)?\.sql$`)
+	mydumperDataFile   = regexp.MustCompile(`^([^.]+)\.(.+?)(?:\.\d+)*\.(sql|dat)$`)
+	mydumperSection    = regexp.MustCompile("^\\[`?([^`.]+)`?\\.`?([^`]+?)`?\\]$")
+	mydumperRows       = regexp.MustCompile(`^(\s*rows\s*=\s*)\d+`)
+)
+
+const mydumperMetadata = "metadata"
+
+// isMydumper looks for a metadata file next to per-table schema files.
+func isMydumper(srcDir string) bool {
+	for _, root := range dumpRoots(srcDir) {
+		if fi, err := os.Stat(filepath.Join(root, mydumperMetadata)); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if mydumperSchemaFile.MatchString(codec.TrimExtension(e.Name())) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mydumperLayout applies table policies per data chunk: a skipped table's
+// chunks are dropped unread, chunks of tables with masks are filtered, and
+// all other chunks are copied as they are. Per-table row counts in metadata
+// are zeroed for tables that lost all their chunks.
+type mydumperLayout struct {
+	metadata []string
+	columns  map[string]map[string][]string // "dir/db.table" -> DDL columns
+	dropped  map[string]bool                // "dir/db.table" with a dropped chunk
+	kept     map[string]bool                // "dir/db.table" with a kept chunk
+	skips    tableSkips
+}
+
+func newMydumperLayout() *mydumperLayout {
+	return &mydumperLayout{
+		columns: map[string]map[string][]string{},
+		dropped: map[string]bool{},
+		kept:    map[string]bool{},
+		skips:   tableSkips{},
+	}
+}
+
+func (l *mydumperLayout) process(srcDir, dstDir, rel string, opts Options) (entryOutcome, filter.Stats, error) {
+	srcPath := filepath.Join(srcDir, filepath.FromSlash(rel))
+	dstPath := filepath.Join(dstDir, filepath.FromSlash(rel))
+	base := codec.TrimExtension(path.Base(rel))
+
+	if path.Base(rel) == mydumperMetadata {
+		// rewritten by finish, once every table's fate is known
+		l.metadata = append(l.metadata, rel)
+		return entryCopied, filter.Stats{}, nil
+	}
+	if mydumperSchemaFile.MatchString(base) {
+		return entrySQL, filter.Stats{}, copyFile(srcPath, dstPath)
+	}
+	m := mydumperDataFile.FindStringSubmatch(base)
+	if m == nil {
+		return entryUnhandled, filter.Stats{}, nil
+	}
+
+	db, table, kind := m[1], m[2], m[3]
+	key := path.Join(path.Dir(rel), db+"."+table)
+	rules := opts.rulesFor(rel)
+	rules.Dialect = filter.DialectMySQL
+	skip, err := l.skips.skips(rules, table)
+	if err != nil {
+		return 0, filter.Stats{}, err
+	}
+	if skip {
+		l.dropped[key] = true
+		return entryDropped, filter.Stats{}, nil
+	}
+	l.kept[key] = true
+
+	switch {
+	case !rules.MasksTable(table):
+		if kind == "dat" {
+			return entryCopied, filter.Stats{}, copyFile(srcPath, dstPath)
+		}
+		return entrySQL, filter.Stats{}, copyFile(srcPath, dstPath)
+	case kind == "dat":
+		return 0, filter.Stats{}, fmt.Errorf("cannot mask %s: LOAD DATA chunks are not SQL", table)
+	}
+
+	if rules.Columns, err = l.tableColumns(srcDir, key); err != nil {
+		return 0, filter.Stats{}, err
+	}
+	stats, err := filterFile(srcPath, dstPath, nil, rules, opts.MaxLineBytes)
+	if err != nil {
+		return 0, stats, fmt.Errorf("filter %s: %w", rel, err)
+	}
+	return entrySQL, stats, nil
+}
+
+// tableColumns reads the columns of key, "dir/db.table", from its schema
+// file, which may be compressed.
+func (l *mydumperLayout) tableColumns(srcDir, key string) (map[string][]string, error) {
+	if cols, ok := l.columns[key]; ok {
+		return cols, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(srcDir, filepath.FromSlash(key)) + "-schema.sql*")
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string][]string{}
+	for _, file := range matches {
+		if codec.TrimExtension(filepath.Base(file)) != path.Base(key)+"-schema.sql" {
+			continue
+		}
+		if err := readDecoded(file, func(r *bufio.Reader) error {
+			cols, err = filter.ScanColumns(r)
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("read schema of %s: %w", key, err)
+		}
+		break
+	}
+	l.columns[key] = cols
+	return cols, nil
+}
+
+func (l *mydumperLayout) finish(srcDir, dstDir string) error {
+	for _, rel := range l.metadata {
+		if err := l.rewriteMetadata(rel, srcDir, dstDir); err != nil {
+			return fmt.Errorf("rewrite %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// rewriteMetadata copies a metadata file and sets "rows = 0" in the
+// [`db`.`table`] sections of tables whose data was dropped. The pre-0.12
+// format has no such sections and is copied unchanged.
+func (l *mydumperLayout) rewriteMetadata(rel, srcDir, dstDir string) error {
+	data, err := os.ReadFile(filepath.Join(srcDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+
+	dir := path.Dir(rel)
+	lines := strings.SplitAfter(string(data), "\n")
+	emptied := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if m := mydumperSection.FindStringSubmatch(trimmed); m != nil {
+			key := path.Join(dir, m[1]+"."+m[2])
+			emptied = l.dropped[key] && !l.kept[key]
+			continue
+		}
+		if emptied && mydumperRows.MatchString(line) {
+			lines[i] = mydumperRows.ReplaceAllString(line, "${1}0")
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(srcDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dstDir, filepath.FromSlash(rel)), []byte(strings.Join(lines, "")), info.Mode().Perm())
+}
@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d00p1/filtrate-backups/internal/filter"
+)
+
+const mydumperMetadataFile = "[config]\nquote-character = BACKTICK\n\n" +
+	"[master]\nFile = mysql-bin.000042\nPosition = 4\n\n" +
+	"[`shop`.`events`]\nreal_table_name=events\nrows = 2\n\n" +
+	"[`shop`.`users`]\nreal_table_name=users\nrows = 1\n\n" +
+	"[`shop`.`orders`]\nreal_table_name=orders\nrows = 1\n"
+
+func writeMydumperDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{
+		"metadata":                        []byte(mydumperMetadataFile),
+		"shop-schema-create.sql":          []byte("CREATE DATABASE `shop`;\n"),
+		"shop.events-schema.sql":          []byte("CREATE TABLE `events` (\n  `id` int\n);\n"),
+		"shop.events.00000.sql.gz":        gzipBytes(t, []byte("INSERT INTO `events` VALUES (1);\n")),
+		"shop.events.00001.sql.gz":        gzipBytes(t, []byte("INSERT INTO `events` VALUES (2);\n")),
+		"shop.users-schema.sql.gz":        gzipBytes(t, []byte("CREATE TABLE `users` (\n  `id` int,\n  `email` varchar(64)\n);\n")),
+		"shop.users.00000.sql.gz":         gzipBytes(t, []byte("INSERT INTO `users` VALUES (1,'a@b.c');\n")),
+		"shop.orders-schema.sql":          []byte("CREATE TABLE `orders` (\n  `id` int\n);\n"),
+		"shop.orders.00000.00001.sql":     []byte("INSERT INTO `orders` VALUES (7);\n"),
+		"shop.orders-schema-triggers.sql": []byte("-- no triggers\n"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, path, data)
+	}
+	return files
+}
+
+func TestRunMydumperDirectory(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "backup")
+	files := writeMydumperDir(t, input)
+
+	output := filepath.Join(dir, "out", "backup")
+	opts := testOptions(dir, input, output)
+	opts.TablesSkip = []string{"^events$"}
+	opts.Masks = []filter.Mask{{Table: "users", Column: "email", Strategy: filter.MaskRedact}}
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.DroppedFiles != 2 || result.MaskedValues != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	for _, name := range []string{"shop.events.00000.sql.gz", "shop.events.00001.sql.gz"} {
+		if _, err := os.Stat(filepath.Join(output, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s should be dropped: %v", name, err)
+		}
+	}
+	for _, name := range []string{"shop.events-schema.sql", "shop.orders.00000.00001.sql", "shop.orders-schema-triggers.sql"} {
+		if !bytes.Equal(readFile(t, filepath.Join(output, name)), files[name]) {
+			t.Fatalf("%s should be copied unchanged", name)
+		}
+	}
+	if got := gunzip(t, readFile(t, filepath.Join(output, "shop.users.00000.sql.gz"))); string(got) != "INSERT INTO `users` VALUES (1,'REDACTED');\n" {
+		t.Fatalf("users chunk not masked: %q", got)
+	}
+
+	want := strings.Replace(mydumperMetadataFile, "real_table_name=events\nrows = 2", "real_table_name=events\nrows = 0", 1)
+	if got := readFile(t, filepath.Join(output, "metadata")); string(got) != want {
+		t.Fatalf("unexpected metadata:\n%s", got)
+	}
+
+	if _, err := Run(context.Background(), opts); err == nil {
+		t.Fatal("expected an existing output directory to be refused")
+	}
+}
+
+func TestRunMydumperKeepList(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "backup")
+	writeMydumperDir(t, input)
+
+	output := filepath.Join(dir, "out", "backup")
+	opts := testOptions(dir, input, output)
+	opts.TablesKeep = []string{"^users$"}
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.DroppedFiles != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(output, "shop.users.00000.sql.gz")); err != nil {
+		t.Fatalf("kept table's chunk is missing: %v", err)
+	}
+
+	opts = testOptions(dir, input, filepath.Join(dir, "sampled"))
+	opts.Samples = []filter.Sample{{Table: "users", Percent: 50}}
+	if _, err := Run(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "sampling") {
+		t.Fatalf("expected sampling a mydumper table to fail, got %v", err)
+	}
+}
+
+func TestRunDirectoryRejectsChecksum(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "backup")
+	writeMydumperDir(t, input)
+
+	output := filepath.Join(dir, "out", "backup")
+	opts := testOptions(dir, input, output)
+	opts.InputChecksum = "sha256:" + strings.Repeat("00", 32)
+	if _, err := Run(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "INPUT_CHECKSUM") {
+		t.Fatalf("expected a checksum on a directory to be refused, got %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("output of an unverified input was written: %v", err)
+	}
+}
+
+func TestRunMydumperInsideTar(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	files := writeMydumperDir(t, filepath.Join(src, "backup-2026"))
+	entries := map[string]string{}
+	for name, data := range files {
+		entries["backup-2026/"+name] = string(data)
+	}
+	input := filepath.Join(dir, "backup.tar.gz")
+	writeFile(t, input, gzipBytes(t, tarBytes(t, entries)))
+
+	output := filepath.Join(dir, "out.tar.gz")
+	opts := testOptions(dir, input, output)
+	opts.TablesSkip = []string{"^orders$"}
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.DroppedFiles != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	got := untar(t, gunzip(t, readFile(t, output)))
+	if _, ok := got["backup-2026/shop.orders.00000.00001.sql"]; ok {
+		t.Fatal("orders chunk should be dropped")
+	}
+	if !strings.Contains(string(got["backup-2026/metadata"]), "real_table_name=orders\nrows = 0") {
+		t.Fatalf("orders rows not reset:\n%s", got["backup-2026/metadata"])
+	}
+	if !bytes.Equal([]byte(got["backup-2026/shop.users.00000.sql.gz"]), files["shop.users.00000.sql.gz"]) {
+		t.Fatal("unmasked chunk should be copied byte for byte")
+	}
+}
@@ -0,0 +1,464 @@
+package pipeline
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/d00p1/filtrate-backups/internal/filter"
+)
+
+// MySQL Shell's util.dumpInstance and util.dumpSchemas write one directory:
+//
+//	@.json, @.sql, @.post.sql      dump options and global DDL
+//	@.done.json                    written last; data sizes per chunk and table
+//	db.json, db.sql                schema metadata and DDL
+//	db@table.json, db@table.sql    table metadata (columns, dialect) and DDL
+//	db@table@0.tsv.zst             data chunks, "@@N" marking the last one
+//	db@table@0.tsv.zst.idx         big-endian uint64 row-end offsets
+//
+// File names are percent-encoded basenames, so tables are identified through
+// their JSON metadata rather than parsed out of chunk names.
+const (
+	mysqlshDumpMetadata = "@.json"
+	mysqlshDoneMetadata = "@.done.json"
+	mysqlshIndexExt     = ".idx"
+)
+
+var mysqlshChunkSuffix = regexp.MustCompile(`^(@@?\d+)?\.`)
+
+func isMysqlsh(srcDir string) bool {
+	for _, root := range dumpRoots(srcDir) {
+		if fi, err := os.Stat(filepath.Join(root, mysqlshDumpMetadata)); err == nil && fi.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+// mysqlshTable is the part of a db@table.json file the filter needs.
+type mysqlshTable struct {
+	basename string
+
+	Options struct {
+		Schema             string   `json:"schema"`
+		Table              string   `json:"table"`
+		Columns            []string `json:"columns"`
+		FieldsTerminatedBy *string  `json:"fieldsTerminatedBy"`
+		FieldsEnclosedBy   *string  `json:"fieldsEnclosedBy"`
+		FieldsEscapedBy    *string  `json:"fieldsEscapedBy"`
+		LinesTerminatedBy  *string  `json:"linesTerminatedBy"`
+	} `json:"options"`
+	Extension string `json:"extension"`
+}
+
+// defaultDialect reports whether the chunks use LOAD DATA's default TSV
+// dialect, the only one MaskTSV understands.
+func (t *mysqlshTable) defaultDialect() bool {
+	is := func(opt *string, want string) bool { return opt == nil || *opt == want }
+	o := t.Options
+	return is(o.FieldsTerminatedBy, "\t") && is(o.LinesTerminatedBy, "\n") &&
+		is(o.FieldsEscapedBy, `\`) && is(o.FieldsEnclosedBy, "")
+}
+
+// mysqlshLayout applies table policies to the data chunks: chunks of a
+// skipped table are emptied, chunks of a table with masks are rewritten
+// through MaskTSV, and the rest are copied. The index of every rewritten
+// chunk is regenerated and @.done.json gets the new data sizes, so that
+// util.loadDump accepts the result.
+type mysqlshLayout struct {
+	tables    map[string][]*mysqlshTable         // dir -> tables described there
+	rewritten map[string]map[string]mysqlshChunk // dir -> chunk name -> sizes
+	owners    map[string]*mysqlshTable           // "dir/chunk" -> table
+	skips     tableSkips
+	indexes   []string
+	done      []string
+}
+
+// mysqlshChunk holds the sizes @.done.json records for a rewritten chunk:
+// its file size on disk, and its uncompressed data size before and after.
+type mysqlshChunk struct {
+	fileBytes        int64
+	oldData, newData int64
+}
+
+func newMysqlshLayout() *mysqlshLayout {
+	return &mysqlshLayout{
+		tables:    map[string][]*mysqlshTable{},
+		rewritten: map[string]map[string]mysqlshChunk{},
+		owners:    map[string]*mysqlshTable{},
+		skips:     tableSkips{},
+	}
+}
+
+func (l *mysqlshLayout) process(srcDir, dstDir, rel string, opts Options) (entryOutcome, filter.Stats, error) {
+	srcPath := filepath.Join(srcDir, filepath.FromSlash(rel))
+	dstPath := filepath.Join(dstDir, filepath.FromSlash(rel))
+	dir, name := path.Split(rel)
+
+	switch {
+	case name == mysqlshDoneMetadata:
+		l.done = append(l.done, rel)
+		return entryCopied, filter.Stats{}, nil
+	case strings.HasSuffix(name, mysqlshIndexExt):
+		l.indexes = append(l.indexes, rel)
+		return entryCopied, filter.Stats{}, nil
+	}
+
+	t, err := l.chunkTable(srcDir, dir, name)
+	if err != nil {
+		return 0, filter.Stats{}, err
+	}
+	if t == nil {
+		return entryCopied, filter.Stats{}, copyFile(srcPath, dstPath)
+	}
+
+	rules := opts.rulesFor(rel)
+	skip, err := l.skips.skips(rules, t.Options.Table)
+	if err != nil {
+		return 0, filter.Stats{}, err
+	}
+	if !skip && !rules.MasksTable(t.Options.Table) {
+		return entryCopied, filter.Stats{}, copyFile(srcPath, dstPath)
+	}
+
+	var masks map[int]string
+	if !skip {
+		if masks, err = mysqlshMasks(t, rules.Masks); err != nil {
+			return 0, filter.Stats{}, err
+		}
+	}
+
+	// the old data size is the last row end of the original index, or else
+	// what decompressing the chunk yields
+	oldData, hasIndex, err := mysqlshIndexSize(srcPath + mysqlshIndexExt)
+	if err != nil {
+		return 0, filter.Stats{}, err
+	}
+
+	var (
+		stats filter.Stats
+		index bytes.Buffer
+		chunk mysqlshChunk
+	)
+	rowEnd := func(offset int64) error {
+		chunk.newData = offset
+		return binary.Write(&index, binary.BigEndian, uint64(offset))
+	}
+	err = transcode(srcPath, dstPath, nil, func(r io.Reader, w io.Writer) error {
+		src := &countingReader{r: r}
+		if skip {
+			if !hasIndex {
+				_, err := io.Copy(io.Discard, src)
+				oldData = src.n
+				return err
+			}
+			return nil
+		}
+		stats, err = filter.MaskTSV(src, w, masks, rules.MaskKey, rowEnd)
+		if !hasIndex {
+			oldData = src.n
+		}
+		return err
+	})
+	if err != nil {
+		return 0, stats, fmt.Errorf("rewrite %s: %w", rel, err)
+	}
+	if index.Len() == 0 {
+		_ = rowEnd(0)
+	}
+	if hasIndex {
+		if err := os.WriteFile(dstPath+mysqlshIndexExt, index.Bytes(), 0o644); err != nil {
+			return 0, stats, err
+		}
+	}
+	fi, err := os.Stat(dstPath)
+	if err != nil {
+		return 0, stats, err
+	}
+	chunk.fileBytes, chunk.oldData = fi.Size(), oldData
+
+	if l.rewritten[dir] == nil {
+		l.rewritten[dir] = map[string]mysqlshChunk{}
+	}
+	l.rewritten[dir][name] = chunk
+	l.owners[rel] = t
+	if skip {
+		return entryDropped, stats, nil
+	}
+	return entryCopied, stats, nil
+}
+
+// mysqlshIndexSize returns the last row end recorded in a chunk index, which
+// is the uncompressed size of the chunk's data.
+func mysqlshIndexSize(path string) (size int64, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if len(data) >= 8 {
+		size = int64(binary.BigEndian.Uint64(data[len(data)-8:]))
+	}
+	return size, true, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// chunkTable returns the table whose data chunk is dir/name, or nil when the
+// file is not a data chunk.
+func (l *mysqlshLayout) chunkTable(srcDir, dir, name string) (*mysqlshTable, error) {
+	tables, ok := l.tables[dir]
+	if !ok {
+		var err error
+		if tables, err = loadMysqlshTables(filepath.Join(srcDir, filepath.FromSlash(dir))); err != nil {
+			return nil, err
+		}
+		l.tables[dir] = tables
+	}
+	for _, t := range tables {
+		rest, ok := strings.CutPrefix(name, t.basename)
+		if !ok || t.Extension == "" {
+			continue
+		}
+		if m := mysqlshChunkSuffix.FindStringIndex(rest); m != nil && rest[m[1]:] == t.Extension {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// loadMysqlshTables reads the db@table.json files in dir.
+func loadMysqlshTables(dir string) ([]*mysqlshTable, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*@*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var tables []*mysqlshTable
+	for _, file := range files {
+		base := strings.TrimSuffix(filepath.Base(file), ".json")
+		if strings.HasPrefix(base, "@") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		t := &mysqlshTable{basename: base}
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", filepath.Base(file), err)
+		}
+		if t.Options.Table != "" {
+			tables = append(tables, t)
+		}
+	}
+	// a longer basename wins over one that is its prefix, e.g. "db@t@x" over "db@t"
+	slices.SortFunc(tables, func(a, b *mysqlshTable) int { return len(b.basename) - len(a.basename) })
+	return tables, nil
+}
+
+// mysqlshMasks maps the masks of t's table to column indexes.
+func mysqlshMasks(t *mysqlshTable, masks []filter.Mask) (map[int]string, error) {
+	if !t.defaultDialect() {
+		return nil, fmt.Errorf("cannot mask %s.%s: only the default TSV dialect is supported", t.Options.Schema, t.Options.Table)
+	}
+	byIndex := map[int]string{}
+	for _, m := range masks {
+		if m.Table != t.Options.Table {
+			continue
+		}
+		i := slices.Index(t.Options.Columns, m.Column)
+		if i < 0 {
+			return nil, fmt.Errorf("masked column %s.%s does not exist", m.Table, m.Column)
+		}
+		byIndex[i] = m.Strategy
+	}
+	return byIndex, nil
+}
+
+func (l *mysqlshLayout) finish(srcDir, dstDir string) error {
+	for _, rel := range l.indexes {
+		dst := filepath.Join(dstDir, filepath.FromSlash(rel))
+		if _, err := os.Stat(dst); err == nil {
+			continue // regenerated with its chunk
+		}
+		if _, err := os.Stat(strings.TrimSuffix(dst, mysqlshIndexExt)); os.IsNotExist(err) {
+			continue // the chunk itself was not written
+		}
+		if err := copyFile(filepath.Join(srcDir, filepath.FromSlash(rel)), dst); err != nil {
+			return err
+		}
+	}
+	for _, rel := range l.done {
+		if err := l.rewriteDone(rel, srcDir, dstDir); err != nil {
+			return fmt.Errorf("rewrite %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// rewriteDone updates @.done.json for every chunk rewritten in its directory:
+// chunkFileBytes gets the new file size on disk, and tableDataBytes,
+// schemaDataBytes and dataBytes the change in uncompressed data. The rest of
+// the document is kept byte for byte.
+func (l *mysqlshLayout) rewriteDone(rel, srcDir, dstDir string) error {
+	srcPath := filepath.Join(srcDir, filepath.FromSlash(rel))
+	dstPath := filepath.Join(dstDir, filepath.FromSlash(rel))
+	dir := path.Dir(rel) + "/"
+	if dir == "./" {
+		dir = ""
+	}
+	rewritten := l.rewritten[dir]
+	if len(rewritten) == 0 {
+		return copyFile(srcPath, dstPath)
+	}
+
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	ints, err := jsonIntSpans(data)
+	if err != nil {
+		return err
+	}
+
+	// only existing numbers are patched, in place, so the key order and
+	// formatting MySQL Shell wrote survive
+	values := map[string]int64{}
+	add := func(delta int64, keys ...string) {
+		key := strings.Join(keys, "\x00")
+		if span, ok := ints[key]; ok {
+			if _, ok := values[key]; !ok {
+				values[key] = span.value
+			}
+			values[key] += delta
+		}
+	}
+	for name, chunk := range rewritten {
+		if key := "chunkFileBytes\x00" + name; ints[key] != (jsonSpan{}) {
+			values[key] = chunk.fileBytes
+		}
+		delta := chunk.newData - chunk.oldData
+		t := l.owners[dir+name]
+		add(delta, "tableDataBytes", t.Options.Schema, t.Options.Table)
+		add(delta, "schemaDataBytes", t.Options.Schema)
+		add(delta, "dataBytes")
+	}
+
+	keys := slices.SortedFunc(maps.Keys(values), func(a, b string) int {
+		return cmp.Compare(ints[a].start, ints[b].start)
+	})
+	var out []byte
+	last := int64(0)
+	for _, key := range keys {
+		span := ints[key]
+		out = append(out, data[last:span.start]...)
+		out = strconv.AppendInt(out, values[key], 10)
+		last = span.end
+	}
+	out = append(out, data[last:]...)
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dstPath, out, info.Mode().Perm())
+}
+
+// jsonSpan is where an integer sits in a JSON document.
+type jsonSpan struct {
+	start, end int64
+	value      int64
+}
+
+// jsonIntSpans finds the integers of a JSON document, keyed by their path of
+// object keys and array indexes joined with NUL.
+func jsonIntSpans(data []byte) (map[string]jsonSpan, error) {
+	type frame struct {
+		object, wantKey bool
+		key             string
+		index           int
+	}
+	var stack []*frame
+	path := func() string {
+		keys := make([]string, len(stack))
+		for i, f := range stack {
+			if f.object {
+				keys[i] = f.key
+			} else {
+				keys[i] = strconv.Itoa(f.index)
+			}
+		}
+		return strings.Join(keys, "\x00")
+	}
+	// valueDone moves the enclosing object or array past a value
+	valueDone := func() {
+		if len(stack) == 0 {
+			return
+		}
+		if f := stack[len(stack)-1]; f.object {
+			f.wantKey = true
+		} else {
+			f.index++
+		}
+	}
+
+	spans := map[string]jsonSpan{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := tok.(string); ok && len(stack) > 0 && stack[len(stack)-1].wantKey {
+			stack[len(stack)-1].key, stack[len(stack)-1].wantKey = s, false
+			continue
+		}
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{':
+				stack = append(stack, &frame{object: true, wantKey: true})
+			case '[':
+				stack = append(stack, &frame{})
+			default:
+				stack = stack[:len(stack)-1]
+				valueDone()
+			}
+			continue
+		case json.Number:
+			// the offset is where the number just read ends
+			if n, err := v.Int64(); err == nil {
+				end := dec.InputOffset()
+				spans[path()] = jsonSpan{start: end - int64(len(v)), end: end, value: n}
+			}
+		}
+		valueDone()
+	}
+}
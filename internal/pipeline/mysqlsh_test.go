@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/d00p1/filtrate-backups/internal/filter"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+)
+
+// mysqlshDoneFile returns an @.done.json recording the on-disk sizes of the
+// given compressed chunks and the data sizes of the shop tables, in an order
+// that is not Go's sorted one, as MySQL Shell writes it.
+func mysqlshDoneFile(chunks map[string][]byte, events, users int) []byte {
+	var fileBytes []string
+	for _, name := range slices.Sorted(maps.Keys(chunks)) {
+		fileBytes = append(fileBytes, fmt.Sprintf("        %q: %d", name, len(chunks[name])))
+	}
+	return fmt.Appendf(nil, `{
+    "end": "2026-10-01 03:00:01",
+    "dataBytes": %d,
+    "tableDataBytes": {
+        "shop": {
+            "users": %d,
+            "events": %d
+        }
+    },
+    "schemaDataBytes": {
+        "shop": %d
+    },
+    "chunkFileBytes": {
+%s
+    }
+}`, events+users, users, events, events+users, strings.Join(fileBytes, ",\n"))
+}
+
+func mysqlshTableFile(table string, columns ...string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"options": map[string]any{
+			"schema":             "shop",
+			"table":              table,
+			"columns":            columns,
+			"fieldsTerminatedBy": "\t",
+			"fieldsEnclosedBy":   "",
+			"fieldsEscapedBy":    "\\",
+			"linesTerminatedBy":  "\n",
+		},
+		"extension":   "tsv.zst",
+		"compression": "zstd",
+	})
+	return data
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zstdCodec, err := codec.Lookup("zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw, err := zstdCodec.NewWriter(&buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func unzstd(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zstdCodec, err := codec.Lookup("zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zstdCodec.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func chunkIndex(offsets ...uint64) []byte {
+	var buf bytes.Buffer
+	for _, off := range offsets {
+		_ = binary.Write(&buf, binary.BigEndian, off)
+	}
+	return buf.Bytes()
+}
+
+func TestRunMysqlshDirectory(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "dump")
+	chunks := map[string][]byte{
+		"shop@events@@0.tsv.zst": zstdBytes(t, []byte("1\n2\n")),
+		"shop@users@0.tsv.zst":   zstdBytes(t, []byte("1\ta@b.c\n2\t\\N\n")),
+		"shop@users@@1.tsv.zst":  zstdBytes(t, []byte("3\tx\n")),
+	}
+	files := map[string][]byte{
+		"@.json":                     []byte(`{"dumper": "mysqlsh Ver 8.0.36"}`),
+		"@.done.json":                mysqlshDoneFile(chunks, 4, 17),
+		"shop.json":                  []byte(`{"schema": "shop"}`),
+		"shop.sql":                   []byte("CREATE DATABASE `shop`;\n"),
+		"shop@events.json":           mysqlshTableFile("events", "id"),
+		"shop@events.sql":            []byte("CREATE TABLE `events` (`id` int);\n"),
+		"shop@events@@0.tsv.zst":     chunks["shop@events@@0.tsv.zst"],
+		"shop@events@@0.tsv.zst.idx": chunkIndex(2, 4),
+		"shop@users.json":            mysqlshTableFile("users", "id", "email"),
+		"shop@users.sql":             []byte("CREATE TABLE `users` (`id` int, `email` text);\n"),
+		"shop@users@0.tsv.zst":       chunks["shop@users@0.tsv.zst"],
+		"shop@users@0.tsv.zst.idx":   chunkIndex(8, 13),
+		"shop@users@@1.tsv.zst":      chunks["shop@users@@1.tsv.zst"],
+		"shop@users@@1.tsv.zst.idx":  chunkIndex(4),
+	}
+	if err := os.MkdirAll(input, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		writeFile(t, filepath.Join(input, name), data)
+	}
+
+	output := filepath.Join(dir, "out")
+	opts := testOptions(dir, input, output)
+	opts.TablesSkip = []string{"^events$"}
+	opts.Masks = []filter.Mask{{Table: "users", Column: "email", Strategy: filter.MaskRedact}}
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.DroppedFiles != 1 || result.MaskedValues != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	if got := unzstd(t, readFile(t, filepath.Join(output, "shop@events@@0.tsv.zst"))); len(got) != 0 {
+		t.Fatalf("events chunk should be empty: %q", got)
+	}
+	if got := readFile(t, filepath.Join(output, "shop@events@@0.tsv.zst.idx")); !bytes.Equal(got, chunkIndex(0)) {
+		t.Fatalf("unexpected events index: %v", got)
+	}
+	if got := unzstd(t, readFile(t, filepath.Join(output, "shop@users@0.tsv.zst"))); string(got) != "1\tREDACTED\n2\t\\N\n" {
+		t.Fatalf("users chunk not masked: %q", got)
+	}
+	if got := readFile(t, filepath.Join(output, "shop@users@0.tsv.zst.idx")); !bytes.Equal(got, chunkIndex(11, 16)) {
+		t.Fatalf("unexpected users index: %v", got)
+	}
+	for _, name := range []string{"@.json", "shop@users.json", "shop@users.sql"} {
+		if !bytes.Equal(readFile(t, filepath.Join(output, name)), files[name]) {
+			t.Fatalf("%s should be copied unchanged", name)
+		}
+	}
+
+	// only the sizes change; keys keep their order and the layout stays
+	written := map[string][]byte{}
+	for name := range chunks {
+		written[name] = readFile(t, filepath.Join(output, name))
+	}
+	if got, want := readFile(t, filepath.Join(output, "@.done.json")), mysqlshDoneFile(written, 0, 27); !bytes.Equal(got, want) {
+		t.Fatalf("unexpected @.done.json:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRunMysqlshRefusesOtherDialects(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "dump")
+	if err := os.MkdirAll(input, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	table := bytes.Replace(mysqlshTableFile("users", "id", "email"), []byte(`"fieldsEnclosedBy":""`), []byte(`"fieldsEnclosedBy":"\""`), 1)
+	writeFile(t, filepath.Join(input, "@.json"), []byte(`{}`))
+	writeFile(t, filepath.Join(input, "shop@users.json"), table)
+	writeFile(t, filepath.Join(input, "shop@users@@0.tsv.zst"), zstdBytes(t, []byte("1\t\"a@b.c\"\n")))
+
+	opts := testOptions(dir, input, filepath.Join(dir, "out"))
+	opts.Masks = []filter.Mask{{Table: "users", Column: "email", Strategy: filter.MaskNull}}
+	if _, err := Run(context.Background(), opts); err == nil {
+		t.Fatal("expected an enclosed dialect to be refused")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Fatalf("no output should be published: %v", err)
+	}
+}
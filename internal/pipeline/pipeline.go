@@ -1,116 +1,814 @@
 package pipeline
 
 import (
-	"compress/gzip"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/d00p1/filtrate-backups/internal/dump"
 	"github.com/d00p1/filtrate-backups/internal/filter"
 	"github.com/d00p1/filtrate-backups/pkg/archive"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+const (
+	autoCodec = "auto"
+
+	streamBufferSize = 64 * 1024
 )
 
 type Options struct {
-	InputPath    string
-	OutputPath   string
-	TablesSkip   []string
-	TmpDir       string
-	MaxLineBytes int
+	// InputPath and OutputPath are local paths, URLs of a Storage backend,
+	// e.g. "s3://bucket/key", or storage.StdioPath for stdin and stdout.
+	// Directory inputs and outputs must be local.
+	InputPath  string
+	OutputPath string
+	Storage    storage.Backends // nil means storage.Default()
+	// InputChecksum, "algorithm:hex", is verified against the raw input
+	// before any output is published.
+	InputChecksum string
+	TablesSkip    []string
+	TablesKeep    []string
+	TmpDir        string
+	MaxLineBytes  int
+
+	// Masks, Samples, TablesSkip and TablesKeep form the default rule set,
+	// applied to every SQL entry that no RuleSets entry claims.
+	Masks    []filter.Mask
+	Samples  []filter.Sample
+	RuleSets []dump.RuleSet
+	// MaskKey keys the hash mask strategy of every rule set, see
+	// filter.Rules.MaskKey.
+	MaskKey []byte
+
+	// Dialect is the SQL flavour of every dump, filter.DialectAuto to detect
+	// it per dump from its header.
+	Dialect string
+
+	// InputCompression and OutputCompression name a codec or "auto".
+	InputCompression  string
+	OutputCompression string
+	CompressionLevel  int
+
+	// OutputContainer is "tar", "zip", "sql", "dir" or "auto" to mirror the
+	// input.
+	OutputContainer string
+
+	// Overwrite lets a run replace an existing output, which otherwise
+	// fails the run before the input is read.
+	Overwrite bool
+
+	// Layout is a dump tool's file structure, dump.LayoutAuto to detect it.
+	Layout string
+
+	// ArchiveLimits bounds what is extracted from an input archive.
+	ArchiveLimits archive.Limits
+
+	// SQLGlobs selects the archive entries run through the filter; every
+	// other entry is copied byte for byte. See dump.Globs for the syntax.
+	// Empty means dump.DefaultSQLGlob.
+	SQLGlobs []string
+
+	// Deterministic makes archive output reproducible: entries are sorted,
+	// ownership is cleared and every timestamp is set to ModTime, or, when
+	// ModTime is zero, to the newest entry of the input archive or the
+	// modification time of a plain input file.
+	Deterministic bool
+	ModTime       time.Time
 }
 
 type Result struct {
 	OutputPath    string
 	TotalLines    int
 	FilteredLines int
+	MaskedValues  int
+	DroppedFiles  int
+	// Warnings describes entries written differently than they were read,
+	// e.g. re-compressed with another codec.
+	Warnings []string
 }
 
-func Run(opts Options) (Result, error) {
-	tmpDir, err := os.MkdirTemp(opts.TmpDir, "cache-")
+func Run(ctx context.Context, opts Options) (Result, error) {
+	if opts.Storage == nil {
+		opts.Storage = storage.Default()
+	}
+	outCodec, err := resolveOutputCodec(opts.OutputCompression, opts.OutputPath, opts.CompressionLevel)
 	if err != nil {
-		return Result{}, fmt.Errorf("mkdir temp: %w", err)
+		return Result{}, err
+	}
+	out := output{ctx: ctx, storage: opts.Storage, path: opts.OutputPath, codec: outCodec, level: opts.CompressionLevel, overwrite: opts.Overwrite}
+	// fail early; publishing checks again in case the output appears
+	// while the run is in progress
+	if !opts.Overwrite {
+		exists, err := opts.Storage.Exists(ctx, opts.OutputPath)
+		if err != nil {
+			return Result{}, fmt.Errorf("check output: %w", err)
+		}
+		if exists {
+			return Result{}, errOutputExists(opts.OutputPath)
+		}
 	}
-	defer os.RemoveAll(tmpDir)
 
-	inputFile, err := os.Open(opts.InputPath)
+	if path, ok := storage.LocalPath(opts.InputPath); ok {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			if opts.InputChecksum != "" {
+				return Result{}, fmt.Errorf("INPUT_CHECKSUM can't verify %s: checksums cover a single input file, not a directory", path)
+			}
+			opts.InputPath = path
+			return runDir(out, opts)
+		}
+	}
+
+	input, inputInfo, err := opts.Storage.Open(ctx, opts.InputPath)
 	if err != nil {
 		return Result{}, fmt.Errorf("open input: %w", err)
 	}
-	defer inputFile.Close()
+	defer input.Close()
 
-	gzReader, err := gzip.NewReader(inputFile)
+	var raw io.Reader = input
+	if opts.InputChecksum != "" {
+		if raw, err = storage.VerifyChecksum(input, opts.InputChecksum); err != nil {
+			return Result{}, err
+		}
+	}
+	src := bufio.NewReaderSize(raw, streamBufferSize)
+	inCodec, err := resolveInputCodec(opts.InputCompression, src, inputInfo.Name)
 	if err != nil {
-		return Result{}, fmt.Errorf("gzip reader error: %w", err)
+		return Result{}, err
 	}
-	defer gzReader.Close()
+	decoder, err := inCodec.NewReader(src)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s reader error: %w", inCodec.Name, err)
+	}
+	defer decoder.Close()
 
-	if err := archive.Unpack(gzReader, tmpDir); err != nil {
-		return Result{}, fmt.Errorf("unpack archive: %w", err)
+	body := bufio.NewReaderSize(decoder, streamBufferSize)
+	inContainer, err := detectContainer(body)
+	if err != nil {
+		return Result{}, err
+	}
+	outContainer := opts.OutputContainer
+	if outContainer == "" || outContainer == dump.ContainerAuto {
+		outContainer = inContainer
+	}
+
+	if inContainer == dump.ContainerSQL && outContainer == dump.ContainerSQL {
+		return runStream(body, src, inputInfo.Name, out, opts)
 	}
 
-	entries, err := os.ReadDir(tmpDir)
+	tmpDir, err := os.MkdirTemp(opts.TmpDir, "cache-")
 	if err != nil {
-		return Result{}, fmt.Errorf("read extracted files: %w", err)
+		return Result{}, fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	extractDir := filepath.Join(tmpDir, "extracted")
+	if err := os.MkdirAll(extractDir, 0o755); err != nil {
+		return Result{}, fmt.Errorf("create work dir: %w", err)
+	}
+
+	var manifest *archive.Manifest
+	if inContainer == dump.ContainerSQL {
+		if err := spool(body, filepath.Join(extractDir, sqlEntryName(inputInfo.Name))); err != nil {
+			return Result{}, err
+		}
+	} else {
+		// ZIP reads its directory through ReaderAt, so an uncompressed local
+		// file can be used in place instead of being spooled again.
+		var archiveReader io.Reader = body
+		if f, ok := input.(*os.File); ok && inContainer == dump.ContainerZip && inCodec.Name == codec.None && opts.InputChecksum == "" {
+			archiveReader = f
+		}
+		if manifest, err = unpack(inContainer, archiveReader, extractDir, opts.ArchiveLimits); err != nil {
+			return Result{}, err
+		}
+	}
+	if opts.InputChecksum != "" {
+		if err := drainInput(src); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if opts.Deterministic {
+		out.deterministic = true
+		out.mtime = sourceTime(opts.ModTime, manifest, inputInfo.ModTime)
+	}
+	return processTree(extractDir, tmpDir, manifest, outContainer, out, opts)
+}
+
+// runDir filters an unpacked dump directory, e.g. a mydumper backup. The
+// output mirrors it as a directory unless another container is asked for.
+func runDir(out output, opts Options) (Result, error) {
+	outContainer := opts.OutputContainer
+	if outContainer == "" || outContainer == dump.ContainerAuto {
+		outContainer = dump.ContainerDir
+	}
+
+	tmpDir, err := os.MkdirTemp(opts.TmpDir, "cache-")
+	if err != nil {
+		return Result{}, fmt.Errorf("mkdir temp: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if opts.Deterministic {
+		out.deterministic = true
+		out.mtime = opts.ModTime
+		if info, err := os.Stat(opts.InputPath); err == nil && out.mtime.IsZero() {
+			out.mtime = info.ModTime()
+		}
 	}
+	return processTree(opts.InputPath, tmpDir, nil, outContainer, out, opts)
+}
 
+// processTree filters the tree under srcDir into a work dir below tmpDir and
+// writes it out in outContainer. A directory output is filtered straight
+// into a hidden sibling of the output path and renamed into place at the
+// end, so a failed run never leaves a half-written directory behind.
+func processTree(srcDir, tmpDir string, manifest *archive.Manifest, outContainer string, out output, opts Options) (Result, error) {
 	filteredDir := filepath.Join(tmpDir, "filtered")
+	outDir, localOutput := storage.LocalPath(opts.OutputPath)
+	if outContainer == dump.ContainerDir {
+		if !localOutput {
+			return Result{}, fmt.Errorf("directory output %s must be a local path", opts.OutputPath)
+		}
+		parent := filepath.Dir(outDir)
+		if err := os.MkdirAll(parent, 0o755); err != nil {
+			return Result{}, fmt.Errorf("create output dir: %w", err)
+		}
+		var err error
+		if filteredDir, err = os.MkdirTemp(parent, "."+filepath.Base(outDir)+".partial-"); err != nil {
+			return Result{}, fmt.Errorf("create output dir: %w", err)
+		}
+		defer os.RemoveAll(filteredDir)
+	}
 	if err := os.MkdirAll(filteredDir, 0o755); err != nil {
-		return Result{}, fmt.Errorf("create filtered dir: %w", err)
+		return Result{}, fmt.Errorf("create work dir: %w", err)
+	}
+
+	result, sqlFiles, err := filterDir(srcDir, filteredDir, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	switch outContainer {
+	case dump.ContainerSQL:
+		err = out.writeConcatenated(filteredDir, sqlFiles)
+	case dump.ContainerDir:
+		err = publishDir(filteredDir, outDir, opts.Overwrite)
+	default:
+		err = out.writeArchive(outContainer, filteredDir, manifest)
+	}
+	if err != nil {
+		return Result{}, err
 	}
 
-	var totalLines, filteredLines int
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+	result.OutputPath = opts.OutputPath
+	return result, nil
+}
+
+// publishDir moves a finished output tree into place, replacing an existing
+// one if overwrite is set. Without it the rename fails on an existing
+// directory, so one that appeared during the run is never replaced.
+func publishDir(dir, dst string, overwrite bool) error {
+	if err := os.Chmod(dir, 0o755); err != nil {
+		return fmt.Errorf("publish output dir: %w", err)
+	}
+	if overwrite {
+		if err := os.RemoveAll(dst); err != nil {
+			return fmt.Errorf("replace output dir: %w", err)
 		}
+	}
+	if err := os.Rename(dir, dst); errors.Is(err, fs.ErrExist) {
+		return errOutputExists(dst)
+	} else if err != nil {
+		return fmt.Errorf("publish output dir: %w", err)
+	}
+	return nil
+}
 
-		srcPath := filepath.Join(tmpDir, entry.Name())
-		dstPath := filepath.Join(filteredDir, entry.Name())
+// runStream filters a plain SQL stream straight into the output without
+// touching the temp dir. The rest of the raw input, src, is drained before
+// the output is published so that its checksum is verified.
+func runStream(body, src io.Reader, inputName string, out output, opts Options) (Result, error) {
+	var stats filter.Stats
+	err := out.write(func(w io.Writer) error {
+		var err error
+		if stats, err = filter.Apply(body, w, opts.rulesFor(sqlEntryName(inputName)), opts.MaxLineBytes); err != nil {
+			return err
+		}
+		return drainInput(src)
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{OutputPath: opts.OutputPath}
+	result.add(stats)
+	return result, nil
+}
 
-		srcFile, err := os.Open(srcPath)
-		if err != nil {
-			return Result{}, fmt.Errorf("open extracted file: %w", err)
+// rulesFor returns the rules of the first rule set claiming the entry at
+// rel, or the default rule set.
+func (opts Options) rulesFor(rel string) filter.Rules {
+	for _, rs := range opts.RuleSets {
+		paths := dump.Globs(rs.Paths)
+		if paths.Match(rel) || paths.Match(codec.TrimExtension(rel)) {
+			rules := rs.Rules
+			rules.Dialect = opts.Dialect
+			rules.MaskKey = opts.MaskKey
+			return rules
 		}
+	}
+	return filter.Rules{
+		SkipTables: opts.TablesSkip,
+		KeepTables: opts.TablesKeep,
+		Samples:    opts.Samples,
+		Masks:      opts.Masks,
+		Dialect:    opts.Dialect,
+		MaskKey:    opts.MaskKey,
+	}
+}
 
-		dstFile, err := os.Create(dstPath)
-		if err != nil {
-			srcFile.Close()
-			return Result{}, fmt.Errorf("create filtered file: %w", err)
+// tableSkips keeps one compiled table matcher per skip and keep pattern
+// lists, for layouts that decide chunk by chunk whether a table is dropped.
+// Those layouts are MySQL's, so a kept table with a sample fails like the
+// filter does for MySQL dumps.
+type tableSkips map[string]*filter.TableMatcher
+
+// skips reports whether rules drop table, compiling the patterns of rules
+// on first use.
+func (c tableSkips) skips(rules filter.Rules, table string) (bool, error) {
+	key := strings.Join(rules.SkipTables, "\x00") + "\x01" + strings.Join(rules.KeepTables, "\x00")
+	m, ok := c[key]
+	if !ok {
+		var err error
+		if m, err = rules.NewTableMatcher(); err != nil {
+			return false, err
 		}
+		c[key] = m
+	}
+	if skip := m.Skips(table); skip || !rules.SamplesTable(table) {
+		return skip, nil
+	}
+	return false, fmt.Errorf("table %s: row sampling is only supported for %s dumps", table, filter.DialectPostgres)
+}
+
+func (r *Result) add(stats filter.Stats) {
+	r.TotalLines += stats.TotalLines
+	r.FilteredLines += stats.FilteredLines
+	r.MaskedValues += stats.MaskedValues
+}
 
-		stats, err := filter.InsertFilter(srcFile, dstFile, opts.TablesSkip, opts.MaxLineBytes)
-		srcFile.Close()
-		dstFile.Close()
+// filterDir mirrors the tree under srcDir into dstDir. Regular files are
+// handed to the dump's layout first; the rest matching opts.SQLGlobs, with or
+// without a compression extension, are filtered, other files are copied
+// unchanged, and directories and symlinks are recreated. It returns the
+// relative paths of the SQL files written, in walk order.
+func filterDir(srcDir, dstDir string, opts Options) (Result, []string, error) {
+	sqlGlobs := dump.Globs(opts.SQLGlobs)
+	if len(sqlGlobs) == 0 {
+		sqlGlobs = dump.Globs{dump.DefaultSQLGlob}
+	}
+	lay, err := resolveLayout(opts.Layout, srcDir)
+	if err != nil {
+		return Result{}, nil, err
+	}
+
+	var (
+		result   Result
+		sqlFiles []string
+	)
+	err = filepath.WalkDir(srcDir, func(srcPath string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return Result{}, fmt.Errorf("filter %s: %w", entry.Name(), err)
+			return err
 		}
+		rel, err := filepath.Rel(srcDir, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dstDir, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dstPath, 0o755)
 
-		totalLines += stats.TotalLines
-		filteredLines += stats.FilteredLines
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dstPath)
+
+		case !d.Type().IsRegular():
+			return nil
+		}
+
+		slashRel := filepath.ToSlash(rel)
+		outcome, stats := entryUnhandled, filter.Stats{}
+		if lay != nil {
+			if outcome, stats, err = lay.process(srcDir, dstDir, slashRel, opts); err != nil {
+				return err
+			}
+		}
+		if outcome == entryUnhandled {
+			var written string
+			if outcome, written, stats, err = processGeneric(srcPath, dstPath, slashRel, sqlGlobs, opts); err != nil {
+				return err
+			}
+			if written != slashRel {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s cannot be re-compressed with its codec, wrote it as %s", slashRel, written))
+				rel = filepath.FromSlash(written)
+			}
+		}
+
+		result.add(stats)
+		switch outcome {
+		case entrySQL:
+			sqlFiles = append(sqlFiles, rel)
+		case entryDropped:
+			result.DroppedFiles++
+		}
+		return nil
+	})
+	if err == nil && lay != nil {
+		err = lay.finish(srcDir, dstDir)
 	}
+	if err != nil {
+		return Result{}, nil, fmt.Errorf("process extracted files: %w", err)
+	}
+	return result, sqlFiles, nil
+}
 
-	if err := packToTarGz(filteredDir, opts.OutputPath); err != nil {
-		return Result{}, err
+// processGeneric filters rel when it matches sqlGlobs and copies it otherwise.
+// It returns the path the entry was written under: an SQL entry compressed
+// with a decompress-only codec, e.g. "db.sql.bz2", is re-compressed with
+// gzip and renamed to match, e.g. "db.sql.gz".
+func processGeneric(srcPath, dstPath, rel string, sqlGlobs dump.Globs, opts Options) (entryOutcome, string, filter.Stats, error) {
+	if !sqlGlobs.Match(rel) && !sqlGlobs.Match(codec.TrimExtension(rel)) {
+		return entryCopied, rel, filter.Stats{}, copyFile(srcPath, dstPath)
+	}
+	c, err := entryCodec(srcPath)
+	if err != nil {
+		return 0, rel, filter.Stats{}, fmt.Errorf("filter %s: %w", rel, err)
+	}
+	var out *codec.Codec
+	written := rel
+	if !c.CanWrite() {
+		if out, err = codec.Lookup("gzip"); err != nil {
+			return 0, rel, filter.Stats{}, err
+		}
+		written = codec.TrimExtension(rel) + out.Extensions[0]
+		dstPath = codec.TrimExtension(dstPath) + out.Extensions[0]
+	}
+	stats, err := filterFile(srcPath, dstPath, out, opts.rulesFor(rel), opts.MaxLineBytes)
+	if err != nil {
+		return 0, rel, stats, fmt.Errorf("filter %s: %w", rel, err)
 	}
+	return entrySQL, written, stats, nil
+}
 
-	return Result{OutputPath: opts.OutputPath, TotalLines: totalLines, FilteredLines: filteredLines}, nil
+// entryCodec detects the compression of the extracted file at path.
+func entryCodec(path string) (*codec.Codec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open extracted file: %w", err)
+	}
+	defer f.Close()
+	return codec.Detect(bufio.NewReader(f), path)
 }
 
-func packToTarGz(srcDir, outputFile string) error {
-	if err := os.MkdirAll(filepath.Dir(outputFile), 0o755); err != nil {
-		return fmt.Errorf("create output dir: %w", err)
+// filterFile filters one SQL entry. A compressed entry, e.g. "db.sql.gz", is
+// filtered decompressed and re-compressed with out, or with its own codec
+// when out is nil.
+func filterFile(srcPath, dstPath string, out *codec.Codec, rules filter.Rules, maxLineBytes int) (filter.Stats, error) {
+	var stats filter.Stats
+	err := transcode(srcPath, dstPath, out, func(r io.Reader, w io.Writer) error {
+		var err error
+		stats, err = filter.Apply(r, w, rules, maxLineBytes)
+		return err
+	})
+	return stats, err
+}
+
+// transcode rewrites the file at srcPath into dstPath through fn, which sees
+// the decompressed content. The codec is detected like the input stream's
+// and the output is re-compressed with out, or with the detected codec when
+// out is nil, at its default level.
+func transcode(srcPath, dstPath string, out *codec.Codec, fn func(r io.Reader, w io.Writer) error) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open extracted file: %w", err)
 	}
+	defer srcFile.Close()
 
-	f, err := os.Create(outputFile)
+	src := bufio.NewReaderSize(srcFile, streamBufferSize)
+	c, err := codec.Detect(src, srcPath)
 	if err != nil {
-		return fmt.Errorf("create output file: %w", err)
+		return err
+	}
+	if out == nil {
+		out = c
+	}
+	if !out.CanWrite() {
+		return fmt.Errorf("cannot re-compress %s entry: codec is decompress-only", out.Name)
+	}
+	decoder, err := c.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("%s reader error: %w", c.Name, err)
+	}
+	defer decoder.Close()
+
+	dstFile, err := os.Create(dstPath)
+	if err != nil {
+		return fmt.Errorf("create filtered file: %w", err)
+	}
+	defer dstFile.Close()
+
+	encoder, err := out.NewWriter(dstFile, 0)
+	if err != nil {
+		return fmt.Errorf("%s writer error: %w", out.Name, err)
+	}
+	if err := fn(decoder, encoder); err != nil {
+		_ = encoder.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("close %s writer: %w", out.Name, err)
+	}
+	return dstFile.Close()
+}
+
+func copyFile(srcPath, dstPath string) error {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open extracted file: %w", err)
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("create copied file: %w", err)
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return fmt.Errorf("copy %s: %w", filepath.Base(srcPath), err)
+	}
+	return dstFile.Close()
+}
+
+// detectContainer peeks at the decompressed stream to tell an archive from a
+// plain SQL dump.
+func detectContainer(body *bufio.Reader) (string, error) {
+	head, err := body.Peek(512)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("peek decompressed input: %w", err)
+	}
+	if format, ok := archive.Detect(head); ok {
+		return format.Name(), nil
+	}
+	return dump.ContainerSQL, nil
+}
+
+func unpack(container string, r io.Reader, dst string, limits archive.Limits) (*archive.Manifest, error) {
+	format, err := archive.Lookup(container)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := format.Unpack(r, dst, limits)
+	if err != nil {
+		return nil, fmt.Errorf("unpack %s archive: %w", format.Name(), err)
+	}
+	return manifest, nil
+}
+
+// sqlEntryName names the archive entry for a plain SQL input, e.g.
+// "db.sql.gz" becomes "db.sql" and stdin "stdin.sql".
+func sqlEntryName(inputName string) string {
+	name := codec.TrimExtension(filepath.Base(inputName))
+	if !strings.EqualFold(filepath.Ext(name), ".sql") {
+		name += ".sql"
+	}
+	return name
+}
+
+// drainInput reads what the decoders left of the raw input, such as archive
+// padding, so that a checksum over all of it is checked.
+func drainInput(src io.Reader) error {
+	if _, err := io.Copy(io.Discard, src); err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
+	return nil
+}
+
+func spool(r io.Reader, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create spool file: %w", err)
 	}
 	defer f.Close()
 
-	gzWriter := gzip.NewWriter(f)
-	defer gzWriter.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("spool input: %w", err)
+	}
+	return f.Close()
+}
+
+// sourceTime picks the timestamp of deterministic output: fixed if set, else
+// the newest entry of the input archive, else the input's mtime.
+func sourceTime(fixed time.Time, manifest *archive.Manifest, inputTime time.Time) time.Time {
+	if !fixed.IsZero() {
+		return fixed
+	}
+	if t := manifest.ModTime(); !t.IsZero() {
+		return t
+	}
+	if !inputTime.IsZero() {
+		return inputTime
+	}
+	return time.Unix(0, 0)
+}
+
+// resolveInputCodec honours an explicit codec name and otherwise sniffs the
+// stream, falling back to the extension of the input name.
+func resolveInputCodec(name string, src *bufio.Reader, inputName string) (*codec.Codec, error) {
+	if name != "" && name != autoCodec {
+		return codec.Lookup(name)
+	}
+	c, err := codec.Detect(src, inputName)
+	if err != nil {
+		return nil, fmt.Errorf("detect input compression: %w", err)
+	}
+	return c, nil
+}
+
+// resolveOutputCodec picks the output codec from its name, or from the output
+// extension for "auto". Bare ".sql", ".tar" and ".zip" outputs stay
+// uncompressed; any other unknown extension keeps gzip, the historical default.
+func resolveOutputCodec(name, outputPath string, level int) (*codec.Codec, error) {
+	if name == "" || name == autoCodec {
+		name = "gzip"
+		if c, ok := codec.ByExtension(outputPath); ok {
+			name = c.Name
+		} else if ext := strings.ToLower(filepath.Ext(outputPath)); ext == ".sql" || ext == ".tar" || ext == ".zip" {
+			name = codec.None
+		}
+	}
+
+	c, err := codec.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !c.CanWrite() {
+		return nil, fmt.Errorf("output codec %s is decompress-only", c.Name)
+	}
+	if err := c.ValidateLevel(level); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// output is the compressed destination of a run. Deterministic archives are
+// written from a normalized manifest stamped with mtime.
+type output struct {
+	ctx       context.Context
+	storage   storage.Backends
+	path      string
+	codec     *codec.Codec
+	level     int
+	overwrite bool
+
+	deterministic bool
+	mtime         time.Time
+}
 
-	if err := archive.Pack(srcDir, gzWriter); err != nil {
-		return fmt.Errorf("pack directory: %w", err)
+// write creates the output and hands fill an encoder writing into it. The
+// output is only published by a successful write; a failed or cancelled one
+// aborts it instead of leaving part of it behind.
+func (o output) write(fill func(w io.Writer) error) error {
+	create := o.storage.CreateExclusive
+	if o.overwrite {
+		create = o.storage.Create
+	}
+	dst, err := create(o.ctx, o.path)
+	if err != nil {
+		return fmt.Errorf("create output file: %w", err)
+	}
+
+	encoder, err := o.codec.NewWriter(dst, o.level)
+	if err != nil {
+		_ = dst.Abort()
+		return fmt.Errorf("%s writer error: %w", o.codec.Name, err)
+	}
+	if err := fill(encoder); err != nil {
+		_ = encoder.Close()
+		_ = dst.Abort()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		_ = dst.Abort()
+		return fmt.Errorf("close %s writer: %w", o.codec.Name, err)
+	}
+	// an interrupted run must not publish what it has written so far
+	if err := o.ctx.Err(); err != nil {
+		_ = dst.Abort()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return errOutputExists(o.path)
+		}
+		return fmt.Errorf("close output file: %w", err)
 	}
 	return nil
 }
+
+func errOutputExists(path string) error {
+	return fmt.Errorf("output %s already exists; pass --overwrite to replace it", path)
+}
+
+// writeArchive packs srcDir in the given container. When the input was an
+// archive of the same format, its original entry order and headers are
+// re-emitted from manifest.
+func (o output) writeArchive(container, srcDir string, manifest *archive.Manifest) error {
+	format, err := archive.Lookup(container)
+	if err != nil {
+		return err
+	}
+	if o.deterministic {
+		if manifest, err = archive.Normalize(format, srcDir, manifest, o.mtime); err != nil {
+			return fmt.Errorf("normalize %s archive: %w", format.Name(), err)
+		}
+	}
+	return o.write(func(w io.Writer) error {
+		var err error
+		if manifest != nil && manifest.Format == format.Name() {
+			err = format.Repack(srcDir, manifest, w)
+		} else {
+			err = format.Pack(srcDir, w)
+		}
+		if err != nil {
+			return fmt.Errorf("pack %s archive: %w", format.Name(), err)
+		}
+		return nil
+	})
+}
+
+// writeConcatenated joins the filtered SQL files, relative to srcDir, into a
+// single SQL stream in name order, decompressing compressed entries. Non-SQL
+// entries have no place in a plain dump and are left out.
+func (o output) writeConcatenated(srcDir string, sqlFiles []string) error {
+	files := append([]string(nil), sqlFiles...)
+	sort.Strings(files)
+
+	return o.write(func(w io.Writer) error {
+		for _, rel := range files {
+			if err := copyDecoded(w, filepath.Join(srcDir, rel)); err != nil {
+				return fmt.Errorf("write %s: %w", filepath.ToSlash(rel), err)
+			}
+		}
+		return nil
+	})
+}
+
+func copyDecoded(w io.Writer, path string) error {
+	return readDecoded(path, func(r *bufio.Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// readDecoded hands fn the content of the file at path, decompressed when
+// its magic bytes or extension name a codec.
+func readDecoded(path string, fn func(r *bufio.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", filepath.Base(path), err)
+	}
+	defer f.Close()
+
+	src := bufio.NewReaderSize(f, streamBufferSize)
+	c, err := codec.Detect(src, path)
+	if err != nil {
+		return err
+	}
+	decoder, err := c.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("%s reader error: %w", c.Name, err)
+	}
+	defer decoder.Close()
+
+	return fn(bufio.NewReaderSize(decoder, streamBufferSize))
+}
@@ -0,0 +1,603 @@
+package pipeline
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/internal/dump"
+	"github.com/d00p1/filtrate-backups/internal/filter"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+)
+
+const sampleDump = "CREATE TABLE `users` (id int);\n" +
+	"INSERT INTO `users` VALUES (1);\n" +
+	"INSERT INTO `tmp_log` VALUES (1);\n"
+
+const filteredDump = "CREATE TABLE `users` (id int);\n" +
+	"INSERT INTO `users` VALUES (1);\n"
+
+func TestRunPlainSQLGzipMirrorsInput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "db.sql.gz")
+	writeFile(t, input, gzipBytes(t, []byte(sampleDump)))
+
+	output := filepath.Join(dir, "out", "db.sql.gz")
+	result, err := Run(context.Background(), testOptions(dir, input, output))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.FilteredLines != 1 {
+		t.Fatalf("expected 1 filtered line, got %d", result.FilteredLines)
+	}
+
+	got := gunzip(t, readFile(t, output))
+	if string(got) != filteredDump {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestRunPlainSQLIntoTar(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "db.sql")
+	writeFile(t, input, []byte(sampleDump))
+
+	output := filepath.Join(dir, "out.tar.gz")
+	opts := testOptions(dir, input, output)
+	opts.OutputContainer = dump.ContainerTar
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	entries := untar(t, gunzip(t, readFile(t, output)))
+	if string(entries["db.sql"]) != filteredDump {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+}
+
+func TestRunTarIntoPlainSQL(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.tar.gz")
+	writeFile(t, input, gzipBytes(t, tarBytes(t, map[string]string{"dump.sql": sampleDump})))
+
+	output := filepath.Join(dir, "out.sql")
+	opts := testOptions(dir, input, output)
+	opts.OutputContainer = dump.ContainerSQL
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if got := readFile(t, output); string(got) != filteredDump {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestRunRefusesToClobberOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "db.sql")
+	writeFile(t, input, []byte(sampleDump))
+	output := filepath.Join(dir, "out.sql")
+	writeFile(t, output, []byte("previous run"))
+
+	opts := testOptions(dir, input, output)
+	if _, err := Run(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an existing output error, got %v", err)
+	}
+	if got := readFile(t, output); string(got) != "previous run" {
+		t.Fatalf("existing output changed to %q", got)
+	}
+
+	opts.Overwrite = true
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := readFile(t, output); string(got) != filteredDump {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestRunCancelledLeavesNoOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "db.sql")
+	writeFile(t, input, []byte(sampleDump))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, testOptions(dir, input, filepath.Join(dir, "out", "db.sql.gz"))); err == nil {
+		t.Fatal("cancelled run succeeded")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "out")); len(entries) != 0 {
+		t.Fatalf("cancelled run left %s behind", entries[0].Name())
+	}
+}
+
+func testOptions(dir, input, output string) Options {
+	return Options{
+		InputPath:    input,
+		OutputPath:   output,
+		TablesSkip:   []string{"^tmp_"},
+		TmpDir:       dir,
+		MaxLineBytes: 1024 * 1024,
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func tarBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func untar(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	entries := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[strings.TrimPrefix(hdr.Name, "./")] = content
+	}
+}
+
+func TestRunZipMirrorsInput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "backup.zip")
+	writeFile(t, input, zipBytes(t, map[string]string{"a.sql": sampleDump, "b.sql": sampleDump}))
+
+	output := filepath.Join(dir, "out", "backup.zip")
+	result, err := Run(context.Background(), testOptions(dir, input, output))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.FilteredLines != 2 {
+		t.Fatalf("expected 2 filtered lines, got %d", result.FilteredLines)
+	}
+
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatalf("output is not a zip: %v", err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(zr.File))
+	}
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != filteredDump {
+			t.Fatalf("%s: unexpected content:\n%s", zf.Name, got)
+		}
+	}
+}
+
+func zipBytes(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRunKeepsNestedAndNonSQLEntries(t *testing.T) {
+	dir := t.TempDir()
+	metadata := "Started dump at: 2026-10-17 03:00:00\n"
+	checksums := "INSERT INTO `tmp_log` looks like SQL but is a checksum list\n"
+	input := filepath.Join(dir, "in.tar.gz")
+	writeFile(t, input, gzipBytes(t, tarBytes(t, map[string]string{
+		"shop/users.sql":     sampleDump,
+		"shop/metadata":      metadata,
+		"shop/checksums.txt": checksums,
+	})))
+
+	output := filepath.Join(dir, "out.tar.gz")
+	result, err := Run(context.Background(), testOptions(dir, input, output))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.FilteredLines != 1 {
+		t.Fatalf("expected 1 filtered line, got %d", result.FilteredLines)
+	}
+
+	entries := untar(t, gunzip(t, readFile(t, output)))
+	if string(entries["shop/users.sql"]) != filteredDump {
+		t.Fatalf("nested SQL entry not filtered: %q", entries["shop/users.sql"])
+	}
+	if string(entries["shop/metadata"]) != metadata || string(entries["shop/checksums.txt"]) != checksums {
+		t.Fatalf("non-SQL entries changed: %v", entries)
+	}
+}
+
+func TestRunPreservesTarMetadata(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2026, 10, 17, 3, 0, 0, 123456789, time.UTC)
+	headers := []*tar.Header{
+		{Name: "z-last.sql", Typeflag: tar.TypeReg, Mode: 0o600, Uid: 1001, Gid: 1002, Uname: "mysql", Gname: "backup",
+			ModTime: mtime, Format: tar.FormatPAX, PAXRecords: map[string]string{"SCHILY.xattr.user.origin": "db01"}},
+		{Name: "a-first/", Typeflag: tar.TypeDir, Mode: 0o750, Uid: 1001, Gid: 1002, ModTime: mtime, Format: tar.FormatGNU},
+		{Name: "a-first/notes.txt", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime, Format: tar.FormatGNU},
+	}
+	contents := []string{sampleDump, "", "keep me\n"}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i, hdr := range headers {
+		hdr.Size = int64(len(contents[i]))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, contents[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "in.tar")
+	writeFile(t, input, buf.Bytes())
+
+	output := filepath.Join(dir, "out.tar")
+	if _, err := Run(context.Background(), testOptions(dir, input, output)); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(readFile(t, output)))
+	for i, want := range headers {
+		got, err := tr.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if got.Name != want.Name || got.Mode != want.Mode || got.Uid != want.Uid || got.Gid != want.Gid ||
+			got.Uname != want.Uname || got.Gname != want.Gname {
+			t.Fatalf("entry %d header changed:\ngot  %+v\nwant %+v", i, got, want)
+		}
+		if !got.ModTime.Equal(want.ModTime.Truncate(modTimePrecision(want.Format))) {
+			t.Fatalf("%s: mtime %v, want %v", got.Name, got.ModTime, want.ModTime)
+		}
+		for k, v := range want.PAXRecords {
+			if got.PAXRecords[k] != v {
+				t.Fatalf("%s: PAX record %s = %q, want %q", got.Name, k, got.PAXRecords[k], v)
+			}
+		}
+	}
+	if hdr, _ := tar.NewReader(bytes.NewReader(readFile(t, output))).Next(); hdr.Size != int64(len(filteredDump)) {
+		t.Fatalf("filtered entry size not updated: %d", hdr.Size)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("unexpected extra entries: %v", err)
+	}
+}
+
+func modTimePrecision(format tar.Format) time.Duration {
+	if format == tar.FormatPAX {
+		return time.Nanosecond
+	}
+	return time.Second
+}
+
+func TestRunDeterministicOutput(t *testing.T) {
+	dir := t.TempDir()
+	fixed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := [][2]string{{"shop/users.sql", sampleDump}, {"shop/metadata", "rows: 2\n"}, {"billing.sql", sampleDump}}
+
+	runTwice := func(t *testing.T, inputs [2][]byte, name string, opts Options) [2][]byte {
+		t.Helper()
+		var outs [2][]byte
+		opts.Overwrite = true // the second run replaces the first output
+		for i, data := range inputs {
+			opts.InputPath = filepath.Join(dir, name)
+			opts.OutputPath = filepath.Join(dir, "out", name)
+			writeFile(t, opts.InputPath, data)
+			if err := os.Chtimes(opts.InputPath, fixed, fixed); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			outs[i] = readFile(t, opts.OutputPath)
+		}
+		if !bytes.Equal(outs[0], outs[1]) {
+			t.Fatalf("outputs differ for identical input")
+		}
+		return outs
+	}
+
+	t.Run("tar", func(t *testing.T) {
+		reversed := [][2]string{files[2], files[1], files[0]}
+		opts := testOptions(dir, "", "")
+		opts.Deterministic = true
+		opts.ModTime = fixed
+		opts.OutputCompression = "gzip"
+		outs := runTwice(t, [2][]byte{
+			gzipBytes(t, orderedTarBytes(t, files)),
+			gzipBytes(t, orderedTarBytes(t, reversed)),
+		}, "in.tar.gz", opts)
+
+		tr := tar.NewReader(bytes.NewReader(gunzip(t, outs[0])))
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !hdr.ModTime.Equal(fixed) || hdr.Uid != 0 || hdr.Uname != "" {
+				t.Fatalf("%s not normalized: %+v", hdr.Name, hdr)
+			}
+			names = append(names, hdr.Name)
+		}
+		if want := "billing.sql shop/ shop/metadata shop/users.sql"; strings.Join(names, " ") != want {
+			t.Fatalf("entries %v, want %s", names, want)
+		}
+	})
+
+	t.Run("plain into zip", func(t *testing.T) {
+		opts := testOptions(dir, "", "")
+		opts.Deterministic = true
+		opts.OutputContainer = dump.ContainerZip
+		opts.OutputCompression = "none"
+		outs := runTwice(t, [2][]byte{[]byte(sampleDump), []byte(sampleDump)}, "db.sql", opts)
+
+		zr, err := zip.NewReader(bytes.NewReader(outs[0]), int64(len(outs[0])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != 1 || !zr.File[0].Modified.Equal(fixed) {
+			t.Fatalf("entry mtime not taken from the input file: %+v", zr.File[0].FileHeader)
+		}
+	})
+}
+
+func orderedTarBytes(t *testing.T, files [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f[0], Mode: 0o644, Size: int64(len(f[1])), Typeflag: tar.TypeReg, Uid: 1000, Uname: "backup"}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRunFiltersCompressedMembers(t *testing.T) {
+	dir := t.TempDir()
+	zstdCodec, err := codec.Lookup("zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zst bytes.Buffer
+	zw, err := zstdCodec.NewWriter(&zst, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(zw, sampleDump); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := filepath.Join(dir, "in.tar")
+	writeFile(t, input, tarBytes(t, map[string]string{
+		"shop.sql.gz":  string(gzipBytes(t, []byte(sampleDump))),
+		"crm.sql.zst":  zst.String(),
+		"notes.txt.gz": string(gzipBytes(t, []byte("INSERT INTO `tmp_x` VALUES (1);\n"))),
+	}))
+
+	output := filepath.Join(dir, "out.tar")
+	result, err := Run(context.Background(), testOptions(dir, input, output))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.FilteredLines != 2 {
+		t.Fatalf("expected 2 filtered lines, got %d", result.FilteredLines)
+	}
+
+	entries := untar(t, readFile(t, output))
+	if got := gunzip(t, entries["shop.sql.gz"]); string(got) != filteredDump {
+		t.Fatalf("gzip member not filtered: %q", got)
+	}
+	zr, err := zstdCodec.NewReader(bytes.NewReader(entries["crm.sql.zst"]))
+	if err != nil {
+		t.Fatalf("zstd member not re-compressed: %v", err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != filteredDump {
+		t.Fatalf("zstd member not filtered: %q", got)
+	}
+	if !bytes.Equal(gunzip(t, entries["notes.txt.gz"]), []byte("INSERT INTO `tmp_x` VALUES (1);\n")) {
+		t.Fatal("non-SQL compressed member changed")
+	}
+
+	opts := testOptions(dir, input, filepath.Join(dir, "out.sql"))
+	opts.OutputContainer = dump.ContainerSQL
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := readFile(t, opts.OutputPath); string(got) != filteredDump+filteredDump {
+		t.Fatalf("concatenated output not decompressed:\n%q", got)
+	}
+}
+
+// sampleDumpBzip2 is sampleDump compressed with bzip2, which the codec
+// package can only decompress.
+const sampleDumpBzip2 = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\xae\x85\xcb\xbe\x00\x00\x0f\xdf\x80\x00\x10\x40\x60\x20" +
+	"\x08\x3a\x25\x9f\x00\xc6\xa7\xde\x00\x20\x00\x48\x4a\x93\x46\x8c\x99\x1a\x34\xf4\x80\x00\x25\x48" +
+	"\xf5\x00\x34\x00\x0d\x1e\xa7\xa4\x62\xa2\x26\x24\x16\x60\xba\x26\xd5\x3c\xf5\xe4\x14\xe9\x8e\xbe" +
+	"\xfa\xa4\x91\x26\x30\xb8\x93\x44\x07\x08\xf0\xd8\xb4\xaa\x94\x2e\x10\x56\xee\x44\x74\x25\x3e\xd6" +
+	"\x68\x6f\x47\xaa\xa3\x11\x22\xce\xfc\x5d\xc9\x14\xe1\x42\x42\xba\x17\x2e\xf8"
+
+func TestRunRecompressesDecompressOnlyMembers(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.tar")
+	writeFile(t, input, tarBytes(t, map[string]string{
+		"shop.sql.bz2":  sampleDumpBzip2,
+		"notes.txt.bz2": sampleDumpBzip2,
+	}))
+
+	output := filepath.Join(dir, "out.tar")
+	result, err := Run(context.Background(), testOptions(dir, input, output))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if want := []string{"shop.sql.bz2 cannot be re-compressed with its codec, wrote it as shop.sql.gz"}; !slices.Equal(result.Warnings, want) {
+		t.Fatalf("warnings %q, want %q", result.Warnings, want)
+	}
+
+	entries := untar(t, readFile(t, output))
+	if _, ok := entries["shop.sql.bz2"]; ok {
+		t.Fatal("bzip2 member kept under its old name")
+	}
+	if got := gunzip(t, entries["shop.sql.gz"]); string(got) != filteredDump {
+		t.Fatalf("bzip2 member not filtered: %q", got)
+	}
+	if string(entries["notes.txt.bz2"]) != sampleDumpBzip2 {
+		t.Fatal("non-SQL bzip2 member changed")
+	}
+
+	opts := testOptions(dir, input, filepath.Join(dir, "out.sql"))
+	opts.OutputContainer = dump.ContainerSQL
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := readFile(t, opts.OutputPath); string(got) != filteredDump {
+		t.Fatalf("concatenated output: %q", got)
+	}
+}
+
+func TestRunAppliesRuleSetsByPath(t *testing.T) {
+	dir := t.TempDir()
+	auth := "CREATE TABLE `accounts` (\n  `id` int,\n  `password` varchar(64)\n);\n" +
+		"INSERT INTO `accounts` VALUES (1,'hunter2');\n"
+	input := filepath.Join(dir, "in.tar")
+	writeFile(t, input, tarBytes(t, map[string]string{
+		"analytics/events.sql": sampleDump,
+		"auth.sql":             auth,
+		"billing.sql":          sampleDump,
+	}))
+
+	output := filepath.Join(dir, "out.tar")
+	opts := testOptions(dir, input, output)
+	opts.RuleSets = []dump.RuleSet{
+		{Name: "analytics", Paths: []string{"analytics/*.sql"}, Rules: filter.Rules{SkipTables: []string{".*"}}},
+		{Name: "auth", Paths: []string{"auth.sql"}, Rules: filter.Rules{Masks: []filter.Mask{
+			{Table: "accounts", Column: "password", Strategy: filter.MaskRedact},
+		}}},
+	}
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.FilteredLines != 3 || result.MaskedValues != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	entries := untar(t, readFile(t, output))
+	if got := string(entries["analytics/events.sql"]); got != "CREATE TABLE `users` (id int);\n" {
+		t.Fatalf("analytics rule set not applied:\n%s", got)
+	}
+	if got := string(entries["auth.sql"]); got != strings.Replace(auth, "'hunter2'", "'REDACTED'", 1) {
+		t.Fatalf("auth rule set not applied:\n%s", got)
+	}
+	if got := string(entries["billing.sql"]); got != filteredDump {
+		t.Fatalf("default rule set not applied:\n%s", got)
+	}
+}
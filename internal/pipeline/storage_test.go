@@ -0,0 +1,136 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/internal/dump"
+	"github.com/d00p1/filtrate-backups/internal/filter"
+	"github.com/d00p1/filtrate-backups/pkg/codec"
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+	"github.com/d00p1/filtrate-backups/pkg/storage/s3fake"
+)
+
+func TestRunS3ZipToS3Tar(t *testing.T) {
+	fake := s3fake.New()
+	defer fake.Close()
+	s3, err := storage.NewS3(context.Background(), storage.S3Options{
+		Endpoint:        fake.URL,
+		PathStyle:       true,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	fake.Put("backups", "nightly/in.zip", zipBytes(t, map[string]string{"dump.sql": sampleDump}), mtime)
+
+	opts := testOptions(t.TempDir(), "s3://backups/nightly/in.zip", "s3://backups/filtered/out.tar.gz")
+	opts.Storage = storage.Backends{"file": storage.File{}, "s3": s3}
+	opts.OutputContainer = dump.ContainerTar
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.FilteredLines != 1 {
+		t.Fatalf("expected 1 filtered line, got %d", result.FilteredLines)
+	}
+
+	data, ok := fake.Object("backups", "filtered/out.tar.gz")
+	if !ok {
+		t.Fatalf("no output uploaded, bucket holds %v", fake.Keys())
+	}
+	if entries := untar(t, gunzip(t, data)); string(entries["dump.sql"]) != filteredDump {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	opts.OutputPath = "s3://backups/filtered/out"
+	opts.OutputContainer = dump.ContainerDir
+	if _, err := Run(context.Background(), opts); err == nil {
+		t.Fatal("expected a directory output on s3 to fail")
+	}
+}
+
+func TestRunStdinToStdout(t *testing.T) {
+	var stdout bytes.Buffer
+	stdio := storage.Stdio{In: bytes.NewReader(gzipBytes(t, []byte(sampleDump))), Out: &stdout}
+
+	opts := testOptions(t.TempDir(), storage.StdioPath, storage.StdioPath)
+	opts.Storage = storage.Backends{"stdio": stdio}
+	opts.OutputCompression = codec.None
+	opts.RuleSets = []dump.RuleSet{{Name: "stdin", Paths: []string{"stdin.sql"}, Rules: filter.Rules{SkipTables: []string{"^users$"}}}}
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if want := "CREATE TABLE `users` (id int);\n" + "INSERT INTO `tmp_log` VALUES (1);\n"; stdout.String() != want {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+}
+
+func TestRunZipFromStdinIsSpooled(t *testing.T) {
+	var stdout bytes.Buffer
+	stdio := storage.Stdio{In: bytes.NewReader(zipBytes(t, map[string]string{"dump.sql": sampleDump})), Out: &stdout}
+
+	opts := testOptions(t.TempDir(), storage.StdioPath, storage.StdioPath)
+	opts.Storage = storage.Backends{"stdio": stdio}
+	opts.OutputCompression = codec.None
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(stdout.Bytes()), int64(stdout.Len()))
+	if err != nil {
+		t.Fatalf("output is not a zip: %v", err)
+	}
+	rc, err := zr.Open("dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, _ := io.ReadAll(rc); string(got) != filteredDump {
+		t.Fatalf("unexpected content:\n%s", got)
+	}
+}
+
+func TestRunHTTPInputVerifiesChecksum(t *testing.T) {
+	data := gzipBytes(t, []byte(sampleDump))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "db.sql.gz", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "db.sql.gz")
+	opts := testOptions(dir, srv.URL+"/nightly/db.sql.gz?token=x", output)
+	opts.InputChecksum = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := gunzip(t, readFile(t, output)); string(got) != filteredDump {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+
+	for _, container := range []string{dump.ContainerSQL, dump.ContainerTar} {
+		output := filepath.Join(dir, "bad-"+container)
+		opts := testOptions(dir, srv.URL+"/nightly/db.sql.gz", output)
+		opts.OutputContainer = container
+		opts.InputChecksum = "sha256:" + strings.Repeat("00", 32)
+		if _, err := Run(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("%s: expected a checksum mismatch, got %v", container, err)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Fatalf("%s: output of an unverified input was published", container)
+		}
+	}
+}
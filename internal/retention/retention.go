@@ -0,0 +1,100 @@
+// Package retention decides which old outputs to prune.
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// Policy keeps the union of what each of its rules keeps; zero rules keep
+// nothing. A Policy without rules is disabled.
+type Policy struct {
+	// Last keeps the Last newest outputs.
+	Last int
+	// Within keeps the outputs younger than Within.
+	Within time.Duration
+	// Daily, Weekly and Monthly keep the newest output of each of the last
+	// Daily days, Weekly ISO weeks and Monthly months that have outputs,
+	// in UTC.
+	Daily, Weekly, Monthly int
+}
+
+// Enabled reports whether the policy has any rule.
+func (p Policy) Enabled() bool {
+	return p.Last > 0 || p.Within > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+// Plan splits objects, ranked by ModTime, into those the policy keeps and
+// those to prune, both newest first. Nothing is pruned by a disabled
+// policy.
+func (p Policy) Plan(objects []storage.Object, now time.Time) (keep, prune []storage.Object) {
+	sorted := append([]storage.Object(nil), objects...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].ModTime.Equal(sorted[j].ModTime) {
+			return sorted[i].ModTime.After(sorted[j].ModTime)
+		}
+		return sorted[i].Location > sorted[j].Location
+	})
+	if !p.Enabled() {
+		return sorted, nil
+	}
+
+	kept := make([]bool, len(sorted))
+	for i, obj := range sorted {
+		if i < p.Last || (p.Within > 0 && now.Sub(obj.ModTime) < p.Within) {
+			kept[i] = true
+		}
+	}
+	keepPeriods(sorted, kept, p.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(sorted, kept, p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(sorted, kept, p.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	for i, obj := range sorted {
+		if kept[i] {
+			keep = append(keep, obj)
+		} else {
+			prune = append(prune, obj)
+		}
+	}
+	return keep, prune
+}
+
+// keepPeriods marks the newest of the newest-first objects in each of the
+// first n periods period names.
+func keepPeriods(sorted []storage.Object, kept []bool, n int, period func(time.Time) string) {
+	seen := map[string]bool{}
+	for i, obj := range sorted {
+		if len(seen) == n {
+			return
+		}
+		key := period(obj.ModTime.UTC())
+		if !seen[key] {
+			seen[key] = true
+			kept[i] = true
+		}
+	}
+}
+
+// ParseDuration accepts time.ParseDuration strings and whole days or weeks,
+// e.g. "36h", "30d" or "2w".
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,73 @@
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage"
+)
+
+// daily returns one output per day for n days up to now, newest first.
+func daily(now time.Time, n int) []storage.Object {
+	var objects []storage.Object
+	for i := 0; i < n; i++ {
+		at := now.AddDate(0, 0, -i)
+		objects = append(objects, storage.Object{
+			Location: fmt.Sprintf("/out/db-%s.tar.gz", at.Format("2006-01-02")),
+			Info:     storage.Info{Name: "db.tar.gz", ModTime: at},
+		})
+	}
+	return objects
+}
+
+func names(objects []storage.Object) string {
+	var s []string
+	for _, obj := range objects {
+		s = append(s, strings.TrimSuffix(strings.TrimPrefix(obj.Location, "/out/db-"), ".tar.gz"))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestPlan(t *testing.T) {
+	// a Saturday
+	now := time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)
+	objects := daily(now, 70)
+
+	cases := []struct {
+		name   string
+		policy Policy
+		keep   string
+	}{
+		{"last", Policy{Last: 2}, "2026-10-17 2026-10-16"},
+		{"within", Policy{Within: 36 * time.Hour}, "2026-10-17 2026-10-16"},
+		{"weekly", Policy{Weekly: 3}, "2026-10-17 2026-10-11 2026-10-04"},
+		{"monthly", Policy{Monthly: 3}, "2026-10-17 2026-09-30 2026-08-31"},
+		{"gfs", Policy{Daily: 2, Weekly: 2, Monthly: 2}, "2026-10-17 2026-10-16 2026-10-11 2026-09-30"},
+	}
+	for _, tc := range cases {
+		keep, prune := tc.policy.Plan(objects, now)
+		if got := names(keep); got != tc.keep {
+			t.Errorf("%s: kept %s, want %s", tc.name, got, tc.keep)
+		}
+		if len(keep)+len(prune) != len(objects) {
+			t.Errorf("%s: kept %d and pruned %d of %d", tc.name, len(keep), len(prune), len(objects))
+		}
+	}
+
+	if _, prune := (Policy{}).Plan(objects, now); len(prune) != 0 {
+		t.Fatalf("disabled policy pruned %d outputs", len(prune))
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for in, want := range map[string]time.Duration{"36h": 36 * time.Hour, "30d": 30 * 24 * time.Hour, "2w": 14 * 24 * time.Hour} {
+		if got, err := ParseDuration(in); err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseDuration("xd"); err == nil {
+		t.Error("ParseDuration(xd) succeeded")
+	}
+}
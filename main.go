@@ -15,7 +15,7 @@ func main() {
 	defer stop()
 
 	if err := app.Run(ctx, os.Args[1:]); err != nil {
-		fmt.Println("Error:", err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 }
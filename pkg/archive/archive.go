@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format is an archive container that can be extracted into a directory and
+// rebuilt from one. Unpack never writes outside dst and reports unsafe
+// entries and exceeded limits as *UnsafePathError and *LimitError.
+// Pack and Repack do not close w.
+type Format interface {
+	Name() string
+	// Unpack extracts r into dst and returns the original entries.
+	Unpack(r io.Reader, dst string, limits Limits) (*Manifest, error)
+	// Pack archives the tree under src with metadata taken from disk.
+	Pack(src string, w io.Writer) error
+	// Repack archives the tree under src in the order and with the metadata
+	// of m, a manifest of the same format. Entries whose files are gone from
+	// src are dropped; files under src that m does not know are appended.
+	Repack(src string, m *Manifest, w io.Writer) error
+}
+
+// Manifest lists the entries of an extracted archive in their original order.
+type Manifest struct {
+	Format  string
+	Entries []Entry
+}
+
+// Entry is one archive member. Path is the sanitized, slash-separated path
+// below the extraction directory ("" for a root "./" entry); Header is the
+// format's native header, *tar.Header or *zip.FileHeader.
+type Entry struct {
+	Path   string
+	Header any
+}
+
+// paths returns the set of entry paths in m.
+func (m *Manifest) paths() map[string]bool {
+	set := make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		set[e.Path] = true
+	}
+	return set
+}
+
+// walkExtras calls fn for regular files and symlinks under src whose path is
+// not in known, in lexical order.
+func walkExtras(src string, known map[string]bool, fn func(rel string, fi os.FileInfo) error) error {
+	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && fi.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if known[rel] {
+			return nil
+		}
+		return fn(rel, fi)
+	})
+}
+
+var (
+	Tar Format = tarFormat{}
+	Zip Format = zipFormat{}
+)
+
+var formats = []Format{Tar, Zip}
+
+// Lookup returns the format registered under name.
+func Lookup(name string) (Format, error) {
+	for _, f := range formats {
+		if f.Name() == strings.ToLower(name) {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown archive format %q", name)
+}
+
+// Detect identifies the archive format of a stream from its first bytes. At
+// least 512 bytes are needed to recognize a tar header.
+func Detect(head []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(head, zipLocalMagic), bytes.HasPrefix(head, zipEmptyMagic):
+		return Zip, true
+	case IsTar(head):
+		return Tar, true
+	default:
+		return nil, false
+	}
+}
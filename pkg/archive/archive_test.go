@@ -0,0 +1,92 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoundTripAndDetect(t *testing.T) {
+	files := map[string]string{
+		"billing.sql": "INSERT INTO `invoices` VALUES (1);\n",
+		"events.sql":  "INSERT INTO `events` VALUES (1);\n",
+	}
+
+	for _, format := range []Format{Tar, Zip} {
+		src := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(src, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := format.Pack(src, &buf); err != nil {
+			t.Fatalf("%s pack: %v", format.Name(), err)
+		}
+
+		detected, ok := Detect(buf.Bytes()[:min(512, buf.Len())])
+		if !ok || detected.Name() != format.Name() {
+			t.Fatalf("%s detected as %v", format.Name(), detected)
+		}
+
+		dst := t.TempDir()
+		if _, err := format.Unpack(&buf, dst, Limits{}); err != nil {
+			t.Fatalf("%s unpack: %v", format.Name(), err)
+		}
+		for name, content := range files {
+			got, err := os.ReadFile(filepath.Join(dst, name))
+			if err != nil {
+				t.Fatalf("%s: %v", format.Name(), err)
+			}
+			if string(got) != content {
+				t.Fatalf("%s: %s = %q", format.Name(), name, got)
+			}
+		}
+	}
+}
+
+func TestDetectPlainSQL(t *testing.T) {
+	head := bytes.Repeat([]byte("-- MySQL dump\n"), 64)
+	if format, ok := Detect(head); ok {
+		t.Fatalf("plain SQL detected as %s", format.Name())
+	}
+}
+
+func TestNormalizeFixesModesOfFilesFromDisk(t *testing.T) {
+	src := t.TempDir()
+	if err := os.Mkdir(filepath.Join(src, "shop"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "shop", "orders.sql"), []byte("SELECT 1;\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []Format{Tar, Zip} {
+		m, err := Normalize(format, src, nil, time.Unix(1767225600, 0))
+		if err != nil {
+			t.Fatalf("%s normalize: %v", format.Name(), err)
+		}
+		want := map[string]os.FileMode{"shop": os.ModeDir | 0o755, "shop/orders.sql": 0o644}
+		for _, e := range m.Entries {
+			var mode os.FileMode
+			switch h := e.Header.(type) {
+			case *tar.Header:
+				mode = h.FileInfo().Mode()
+			case *zip.FileHeader:
+				mode = h.Mode()
+			}
+			if mode != want[e.Path] {
+				t.Fatalf("%s: %s has mode %v, want %v", format.Name(), e.Path, mode, want[e.Path])
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsafePath matches every UnsafePathError.
+	ErrUnsafePath = errors.New("unsafe archive entry")
+	// ErrLimitExceeded matches every LimitError.
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+)
+
+// UnsafePathError reports an entry that would be written, or would link,
+// outside the extraction directory.
+type UnsafePathError struct {
+	Name   string
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("unsafe archive entry %q: %s", e.Name, e.Reason)
+}
+
+func (e *UnsafePathError) Unwrap() error { return ErrUnsafePath }
+
+// LimitError reports an archive that exceeds one of the configured Limits.
+type LimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("archive exceeds %s limit of %d", e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return ErrLimitExceeded }
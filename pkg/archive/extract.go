@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits bounds what Unpack extracts. Zero values mean unlimited.
+type Limits struct {
+	// MaxBytes caps the total size of extracted file contents.
+	MaxBytes int64
+	// MaxEntries caps the number of archive entries, directories included.
+	MaxEntries int
+}
+
+// extractor writes archive entries below dst. Every file operation goes
+// through an os.Root, so no entry can reach outside dst, even via symlinks
+// created by earlier entries.
+type extractor struct {
+	root     *os.Root
+	dst      string
+	limits   Limits
+	entries  int
+	written  int64
+	manifest *Manifest
+}
+
+func newExtractor(format, dst string, limits Limits) (*extractor, error) {
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{root: root, dst: dst, limits: limits, manifest: &Manifest{Format: format}}, nil
+}
+
+func (e *extractor) Close() error {
+	return e.root.Close()
+}
+
+// entry accounts for one archive entry, records header in the manifest and
+// returns the sanitized name, or "" for entries that name dst itself.
+func (e *extractor) entry(name string, header any) (string, error) {
+	e.entries++
+	if e.limits.MaxEntries > 0 && e.entries > e.limits.MaxEntries {
+		return "", &LimitError{Limit: "entry count", Max: int64(e.limits.MaxEntries)}
+	}
+	clean, err := sanitizeName(name)
+	if err != nil {
+		return "", err
+	}
+	e.manifest.Entries = append(e.manifest.Entries, Entry{Path: filepath.ToSlash(clean), Header: header})
+	return clean, nil
+}
+
+// sanitizeName turns an archive entry name into a clean relative path and
+// rejects absolute names and names that climb out with "..".
+func sanitizeName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(slashed) || filepath.VolumeName(name) != "" {
+		return "", &UnsafePathError{Name: name, Reason: "absolute path"}
+	}
+	clean := path.Clean(slashed)
+	if clean == "." {
+		return "", nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(clean)) {
+		return "", &UnsafePathError{Name: name, Reason: "path escapes destination"}
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+func (e *extractor) mkdirAll(name string) error {
+	if name == "" || name == "." {
+		return nil
+	}
+	if err := e.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+	info, err := e.root.Stat(name)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("create directory %s: not a directory", name)
+		}
+		return nil
+	}
+	if err := e.root.Mkdir(name, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// replace clears whatever an earlier entry left at name, so a new entry never
+// writes through an existing symlink.
+func (e *extractor) replace(name string) error {
+	info, err := e.root.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("extract %s: directory already exists", name)
+	}
+	return e.root.Remove(name)
+}
+
+func (e *extractor) file(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	if e.limits.MaxBytes > 0 && e.written+size > e.limits.MaxBytes {
+		return &LimitError{Limit: "total bytes", Max: e.limits.MaxBytes}
+	}
+	if err := e.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+	if err := e.replace(name); err != nil {
+		return err
+	}
+
+	f, err := e.root.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Declared sizes are checked above; the copy is bounded as well in case a
+	// format under-reports them.
+	src := r
+	if e.limits.MaxBytes > 0 {
+		src = io.LimitReader(r, e.limits.MaxBytes-e.written+1)
+	}
+	n, err := io.Copy(f, src)
+	e.written += n
+	if err != nil {
+		return err
+	}
+	if e.limits.MaxBytes > 0 && e.written > e.limits.MaxBytes {
+		return &LimitError{Limit: "total bytes", Max: e.limits.MaxBytes}
+	}
+	return f.Close()
+}
+
+// symlink creates name pointing at target, provided the target resolves
+// inside dst.
+func (e *extractor) symlink(name, target string) error {
+	if path.IsAbs(strings.ReplaceAll(target, "\\", "/")) || filepath.VolumeName(target) != "" {
+		return &UnsafePathError{Name: name, Reason: "absolute symlink target " + target}
+	}
+	resolved := filepath.Join(filepath.Dir(name), filepath.FromSlash(target))
+	if !filepath.IsLocal(resolved) {
+		return &UnsafePathError{Name: name, Reason: "symlink target escapes destination: " + target}
+	}
+
+	if err := e.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+	// The target is checked against the parent's path as written, which is
+	// only where the link really lives when no parent is itself a symlink.
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		info, err := e.root.Lstat(dir)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &UnsafePathError{Name: name, Reason: "symlink below symlinked directory " + filepath.ToSlash(dir)}
+		}
+	}
+	if err := e.replace(name); err != nil {
+		return err
+	}
+	// os.Root has no Symlink before Go 1.25. The parent was just created
+	// through the root and contains no symlinks, and every symlink in dst is
+	// validated like this one, so the joined path stays inside dst.
+	return os.Symlink(filepath.FromSlash(target), filepath.Join(e.dst, name))
+}
+
+// hardlink materializes name as a copy of target, an earlier regular file.
+// Copying instead of linking keeps later edits to one name from leaking into
+// the other.
+func (e *extractor) hardlink(name, target string) error {
+	clean, err := sanitizeName(target)
+	if err != nil || clean == "" {
+		return &UnsafePathError{Name: name, Reason: "hardlink target escapes destination: " + target}
+	}
+	info, err := e.root.Lstat(clean)
+	if err != nil {
+		return fmt.Errorf("hardlink %s: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return &UnsafePathError{Name: name, Reason: "hardlink target is not a regular file: " + target}
+	}
+
+	src, err := e.root.Open(clean)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return e.file(name, info.Mode(), info.Size(), src)
+}
@@ -0,0 +1,398 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func regular(name, content string) tarEntry {
+	return tarEntry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}, content: content}
+}
+
+func TestTarUnpackRejectsUnsafeEntries(t *testing.T) {
+	tests := map[string][]tarEntry{
+		"traversal": {regular("../../etc/cron.d/x", "boom")},
+		"absolute":  {regular("/etc/cron.d/x", "boom")},
+		"symlink escape": {
+			{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../outside"}},
+		},
+		"absolute symlink": {
+			{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+		},
+		"hardlink escape": {
+			{hdr: tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+		},
+	}
+
+	for name, entries := range tests {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "dst")
+			if err := os.Mkdir(dst, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Tar.Unpack(buildTar(t, entries...), dst, Limits{})
+			var unsafe *UnsafePathError
+			if !errors.As(err, &unsafe) || !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("expected UnsafePathError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTarUnpackCannotWriteThroughSymlink(t *testing.T) {
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "dst")
+	if err := os.Mkdir(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link points inside dst, so it is accepted; the later regular entry
+	// with the same name must replace the link instead of writing through it.
+	archive := buildTar(t,
+		regular("data/target.sql", "original"),
+		tarEntry{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "data/target.sql"}},
+		regular("link", "replacement"),
+	)
+	if _, err := Tar.Unpack(archive, dst, Limits{}); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(dst, "data", "target.sql")); string(got) != "original" {
+		t.Fatalf("symlink target was overwritten: %q", got)
+	}
+	info, err := os.Lstat(filepath.Join(dst, "link"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("link should have been replaced by a regular file: %v %v", info, err)
+	}
+}
+
+func TestTarUnpackRejectsSymlinkBelowSymlinkedDirectory(t *testing.T) {
+	parent := t.TempDir()
+	dst := filepath.Join(parent, "dst")
+	if err := os.Mkdir(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "outside"), []byte("host secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a/d resolves to dst itself, so a/d/e -> ../outside looks like a/outside
+	// on paper but would really point next to dst.
+	archive := buildTar(t,
+		tarEntry{hdr: tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		tarEntry{hdr: tar.Header{Name: "a/d", Typeflag: tar.TypeSymlink, Linkname: ".."}},
+		tarEntry{hdr: tar.Header{Name: "a/d/e", Typeflag: tar.TypeSymlink, Linkname: "../outside"}},
+	)
+	_, err := Tar.Unpack(archive, dst, Limits{})
+	var unsafe *UnsafePathError
+	if !errors.As(err, &unsafe) {
+		t.Fatalf("expected UnsafePathError, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "e")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("symlink was created through the symlinked directory: %v", err)
+	}
+}
+
+func TestTarRepackEmitsReplacedEntriesAsOnDisk(t *testing.T) {
+	dst := t.TempDir()
+	// the later symlink replaces the regular file, which Repack must not
+	// ship with the regular header's size and the link target's content
+	manifest, err := Tar.Unpack(buildTar(t,
+		regular("secret.sql", "INSERT INTO `accounts` VALUES (1);\n"),
+		regular("dump.sql", "short"),
+		tarEntry{hdr: tar.Header{Name: "dump.sql", Typeflag: tar.TypeSymlink, Linkname: "secret.sql"}},
+	), dst, Limits{})
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Tar.Repack(dst, manifest, &buf); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	tr := tar.NewReader(&buf)
+	var got []string
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		got = append(got, fmt.Sprintf("%s %c %s %d", hdr.Name, hdr.Typeflag, hdr.Linkname, hdr.Size))
+	}
+	want := []string{"secret.sql 0  35", "dump.sql 2 secret.sql 0", "dump.sql 2 secret.sql 0"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("repacked %q, want %q", got, want)
+	}
+}
+
+func TestTarUnpackHardlinkAndTruncate(t *testing.T) {
+	dst := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dst, "dump.sql"), []byte("stale content that is longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := buildTar(t,
+		regular("dump.sql", "fresh"),
+		tarEntry{hdr: tar.Header{Name: "copy.sql", Typeflag: tar.TypeLink, Linkname: "dump.sql"}},
+	)
+	if _, err := Tar.Unpack(archive, dst, Limits{}); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+
+	for _, name := range []string{"dump.sql", "copy.sql"} {
+		if got, _ := os.ReadFile(filepath.Join(dst, name)); string(got) != "fresh" {
+			t.Fatalf("%s = %q", name, got)
+		}
+	}
+}
+
+func TestUnpackLimits(t *testing.T) {
+	entries := []tarEntry{regular("a.sql", "12345"), regular("b.sql", "67890")}
+
+	_, err := Tar.Unpack(buildTar(t, entries...), t.TempDir(), Limits{MaxEntries: 1})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != "entry count" {
+		t.Fatalf("expected entry count LimitError, got %v", err)
+	}
+
+	_, err = Tar.Unpack(buildTar(t, entries...), t.TempDir(), Limits{MaxBytes: 8})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "total bytes" || !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected total bytes LimitError, got %v", err)
+	}
+
+	if _, err := Tar.Unpack(buildTar(t, entries...), t.TempDir(), Limits{MaxBytes: 10, MaxEntries: 2}); err != nil {
+		t.Fatalf("archive within limits rejected: %v", err)
+	}
+}
+
+func TestZipRepackEmitsReplacedEntriesAsOnDisk(t *testing.T) {
+	var in bytes.Buffer
+	zw := zip.NewWriter(&in)
+	for _, e := range []struct {
+		name, content string
+		mode          os.FileMode
+	}{
+		{"secret.sql", "INSERT INTO `accounts` VALUES (1);\n", 0o644},
+		{"dump.sql", "short", 0o644},
+		{"dump.sql", "secret.sql", os.ModeSymlink | 0o777},
+	} {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		header.SetMode(e.mode)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	manifest, err := Zip.Unpack(&in, dst, Limits{})
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Zip.Repack(dst, manifest, &out); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, zf := range zr.File {
+		got = append(got, fmt.Sprintf("%s %v %s", zf.Name, zf.Mode().Type(), readZipEntry(t, zf)))
+	}
+	want := []string{"secret.sql ---------- INSERT INTO `accounts` VALUES (1);\n", "dump.sql L--------- secret.sql", "dump.sql L--------- secret.sql"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("repacked %q, want %q", got, want)
+	}
+}
+
+func TestZipRoundTripsZip64Entry(t *testing.T) {
+	content := []byte("INSERT INTO `orders` VALUES (1);\n")
+	in := buildZip64(t, "orders.sql", content)
+
+	dst := t.TempDir()
+	manifest, err := Zip.Unpack(bytes.NewReader(in), dst, Limits{})
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "orders.sql")); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("unpacked %q, %v", got, err)
+	}
+
+	var out bytes.Buffer
+	if err := Zip.Repack(dst, manifest, &out); err != nil {
+		t.Fatalf("repack: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "orders.sql" || readZipEntry(t, zr.File[0]) != string(content) {
+		t.Fatalf("unexpected repacked archive: %+v", zr.File)
+	}
+	if mode := zr.File[0].Mode(); mode != 0o640 {
+		t.Fatalf("repacked mode %v, want -rw-r-----", mode)
+	}
+}
+
+// buildZip64 writes a stored one-entry archive in full ZIP64 form: sizes and
+// offsets in zip64 extra fields, and a zip64 end of central directory. Real
+// archives only get these records past 4 GiB or 65535 entries.
+func buildZip64(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	crc := crc32.ChecksumIEEE(content)
+	size := uint64(len(content))
+
+	b := le.AppendUint32(nil, 0x04034b50) // local file header
+	b = le.AppendUint16(b, 45)            // version needed: zip64
+	b = le.AppendUint16(b, 0)             // flags
+	b = le.AppendUint16(b, 0)             // stored
+	b = le.AppendUint16(b, 0)             // time
+	b = le.AppendUint16(b, 0x21)          // date, 1980-01-01
+	b = le.AppendUint32(b, crc)
+	b = le.AppendUint32(b, 0xffffffff)
+	b = le.AppendUint32(b, 0xffffffff)
+	b = le.AppendUint16(b, uint16(len(name)))
+	b = le.AppendUint16(b, 20)
+	b = append(b, name...)
+	b = le.AppendUint16(b, 0x0001) // zip64 extra: sizes
+	b = le.AppendUint16(b, 16)
+	b = le.AppendUint64(b, size)
+	b = le.AppendUint64(b, size)
+	b = append(b, content...)
+
+	dirOffset := uint64(len(b))
+	b = le.AppendUint32(b, 0x02014b50) // central directory header
+	b = le.AppendUint16(b, 3<<8|45)    // made by unix
+	b = le.AppendUint16(b, 45)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint16(b, 0x21)
+	b = le.AppendUint32(b, crc)
+	b = le.AppendUint32(b, 0xffffffff)
+	b = le.AppendUint32(b, 0xffffffff)
+	b = le.AppendUint16(b, uint16(len(name)))
+	b = le.AppendUint16(b, 28)
+	b = le.AppendUint16(b, 0)                    // comment length
+	b = le.AppendUint16(b, 0)                    // disk
+	b = le.AppendUint16(b, 0)                    // internal attributes
+	b = le.AppendUint32(b, uint32(0o100640)<<16) // regular file, rw-r-----
+	b = le.AppendUint32(b, 0xffffffff)           // local header offset
+	b = append(b, name...)
+	b = le.AppendUint16(b, 0x0001) // zip64 extra: sizes and offset
+	b = le.AppendUint16(b, 24)
+	b = le.AppendUint64(b, size)
+	b = le.AppendUint64(b, size)
+	b = le.AppendUint64(b, 0)
+	dirSize := uint64(len(b)) - dirOffset
+
+	end64 := uint64(len(b))
+	b = le.AppendUint32(b, 0x06064b50) // zip64 end of central directory
+	b = le.AppendUint64(b, 44)
+	b = le.AppendUint16(b, 45)
+	b = le.AppendUint16(b, 45)
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint64(b, 1)
+	b = le.AppendUint64(b, 1)
+	b = le.AppendUint64(b, dirSize)
+	b = le.AppendUint64(b, dirOffset)
+	b = le.AppendUint32(b, 0x07064b50) // zip64 end of central directory locator
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint64(b, end64)
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint32(b, 0x06054b50) // end of central directory, deferring to zip64
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint16(b, 0xffff)
+	b = le.AppendUint16(b, 0xffff)
+	b = le.AppendUint32(b, 0xffffffff)
+	b = le.AppendUint32(b, 0xffffffff)
+	b = le.AppendUint16(b, 0)
+	return b
+}
+
+func readZipEntry(t *testing.T, zf *zip.File) string {
+	t.Helper()
+	rc, err := zf.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestZipUnpackRejectsTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("../evil.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Zip.Unpack(&buf, t.TempDir(), Limits{})
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("expected ErrUnsafePath, got %v", err)
+	}
+}
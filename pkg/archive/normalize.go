@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Normalize returns a manifest of format f for the tree under src that makes
+// Repack reproducible: entries are sorted by path, every timestamp is set to
+// mtime and ownership is cleared. Headers of m are reused when m has the same
+// format, so modes, link targets and PAX records survive; files that m does
+// not know get headers from disk with mode 0644, or 0755 for directories, so
+// the umask of the extraction does not leak into the archive. m may be nil.
+func Normalize(f Format, src string, m *Manifest, mtime time.Time) (*Manifest, error) {
+	mtime = mtime.UTC().Truncate(time.Second)
+	out := &Manifest{Format: f.Name()}
+
+	known := map[string]bool{}
+	if m != nil && m.Format == f.Name() {
+		known = m.paths()
+		for _, e := range m.Entries {
+			out.Entries = append(out.Entries, Entry{Path: e.Path, Header: normalizeHeader(e.Header, mtime, false)})
+		}
+	}
+
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == src || (!fi.Mode().IsRegular() && !fi.IsDir() && fi.Mode()&os.ModeSymlink == 0) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if known[rel] {
+			return nil
+		}
+
+		var header any
+		switch f.(type) {
+		case tarFormat:
+			header, err = tarDiskHeader(src, rel, fi)
+		case zipFormat:
+			header, err = zipHeader(rel, fi)
+		default:
+			err = fmt.Errorf("normalize: unsupported archive format %s", f.Name())
+		}
+		if err != nil {
+			return err
+		}
+		out.Entries = append(out.Entries, Entry{Path: rel, Header: normalizeHeader(header, mtime, true)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(out.Entries, func(i, j int) bool {
+		return out.Entries[i].Path < out.Entries[j].Path
+	})
+	return out, nil
+}
+
+// normalizeHeader returns a copy of a native header with its timestamps set
+// to mtime and its ownership cleared. Headers built from disk also get fixed
+// permissions: 0644 for files and 0755 for directories.
+func normalizeHeader(header any, mtime time.Time, fromDisk bool) any {
+	switch h := header.(type) {
+	case *tar.Header:
+		n := *h
+		if fromDisk {
+			switch n.Typeflag {
+			case tar.TypeDir:
+				n.Mode = 0o755
+			case tar.TypeReg:
+				n.Mode = 0o644
+			}
+		}
+		n.ModTime = mtime
+		n.AccessTime = time.Time{}
+		n.ChangeTime = time.Time{}
+		n.Uid, n.Gid = 0, 0
+		n.Uname, n.Gname = "", ""
+		if n.PAXRecords != nil {
+			n.PAXRecords = maps.Clone(n.PAXRecords)
+			for _, key := range []string{"atime", "ctime", "mtime", "uid", "gid", "uname", "gname"} {
+				delete(n.PAXRecords, key)
+			}
+		}
+		return &n
+	case *zip.FileHeader:
+		n := *h
+		if mode := n.Mode(); fromDisk && mode.IsDir() {
+			n.SetMode(os.ModeDir | 0o755)
+		} else if fromDisk && mode.IsRegular() {
+			n.SetMode(0o644)
+		}
+		n.Modified = mtime
+		return &n
+	default:
+		return header
+	}
+}
+
+// ModTime returns the newest modification time among the entries of m, or
+// the zero time when m is nil or has no timestamps.
+func (m *Manifest) ModTime() time.Time {
+	var newest time.Time
+	if m == nil {
+		return newest
+	}
+	for _, e := range m.Entries {
+		var t time.Time
+		switch h := e.Header.(type) {
+		case *tar.Header:
+			t = h.ModTime
+		case *zip.FileHeader:
+			t = h.Modified
+			if t.IsZero() {
+				t = h.ModTime()
+			}
+		}
+		if t.After(newest) {
+			newest = t
+		}
+	}
+	return newest
+}
@@ -2,47 +2,120 @@ package archive
 
 import (
 	"archive/tar"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-func Pack(src string, writer io.WriteCloser) error {
+type tarFormat struct{}
+
+func (tarFormat) Name() string { return "tar" }
+
+func (tarFormat) Unpack(r io.Reader, dst string, limits Limits) (*Manifest, error) {
+	ex, err := newExtractor("tar", dst, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer ex.Close()
+
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+
+		switch {
+
+		// if no more files are found return
+		case err == io.EOF:
+			return ex.manifest, nil
+
+		// return any other error
+		case err != nil:
+			return nil, err
+
+		// if the header is nil, just skip it (not sure how this happens)
+		case header == nil:
+			continue
+		}
+
+		name, err := ex.entry(header.Name, header)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+
+		// check the file type; devices, fifos and other special entries are skipped
+		switch header.Typeflag {
+
+		case tar.TypeDir:
+			err = ex.mkdirAll(name)
+
+		case tar.TypeReg, tar.TypeGNUSparse:
+			err = ex.file(name, os.FileMode(header.Mode), header.Size, tr)
 
+		case tar.TypeSymlink:
+			err = ex.symlink(name, header.Linkname)
+
+		case tar.TypeLink:
+			err = ex.hardlink(name, header.Linkname)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (tarFormat) Pack(src string, w io.Writer) error {
 	if _, err := os.Stat(src); err != nil {
 		return fmt.Errorf("unable to tar files - %v", err.Error())
 	}
 
-	tw := tar.NewWriter(writer)
-
-	defer func() { _ = tw.Close(); _ = writer.Close() }()
+	tw := tar.NewWriter(w)
 
-	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
 
 		if err != nil {
 			return err
 		}
 
-		// return on non-regular files (thanks to [kumo](https://medium.com/@komuw/just-like-you-did-fbdd7df829d3) for this suggested update)
-		if !fi.Mode().IsRegular() {
+		// the root itself is not an entry; other non-regular files except
+		// directories and symlinks are skipped (thanks to [kumo](https://medium.com/@komuw/just-like-you-did-fbdd7df829d3) for this suggested update)
+		if file == src {
+			return nil
+		}
+		var link string
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		case !fi.Mode().IsRegular() && !fi.IsDir():
 			return nil
 		}
 
-		// create a new dir/file header
-		header, err := tar.FileInfoHeader(fi, fi.Name())
+		// create a new dir/file header named for the desired destination when untaring
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		header, err := tarHeader(filepath.ToSlash(rel), fi, link)
 		if err != nil {
 			return err
 		}
-
-		// update the name to correctly reflect the desired destination when untaring
-		header.Name = strings.TrimPrefix(strings.ReplaceAll(file, src, ""), string(filepath.Separator))
 
 		// write the header
 		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
 
 		// open files for taring
 		f, err := os.Open(file)
@@ -61,62 +134,206 @@ func Pack(src string, writer io.WriteCloser) error {
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
 }
 
-func Unpack(r io.Reader, dst string) error {
-	tr := tar.NewReader(r)
+// Repack re-emits the original headers of m in their original order. Only
+// the size of regular files is refreshed from src; mtimes, ownership, PAX
+// records and the header format are kept, and the writer recomputes the
+// checksum.
+func (tarFormat) Repack(src string, m *Manifest, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	emitted := make(map[string]bool, len(m.Entries))
 
-	for {
-		header, err := tr.Next()
+	for _, entry := range m.Entries {
+		orig, ok := entry.Header.(*tar.Header)
+		if !ok {
+			return fmt.Errorf("repack tar: entry %q has no tar header", entry.Path)
+		}
+		header := *orig
+		file := filepath.Join(src, filepath.FromSlash(entry.Path))
 
-		switch {
+		// devices and fifos were never extracted and pass through as is
+		var fi os.FileInfo
+		if entry.Path != "" && extractedTarTypes[header.Typeflag] {
+			var err error
+			if fi, err = os.Lstat(file); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if !tarTypeMatches(header.Typeflag, fi.Mode()) {
+				// a later duplicate replaced the entry with another kind of
+				// file, e.g. a symlink over a regular file; ship what is on
+				// disk under the entry's ownership and mtime
+				disk, err := tarDiskHeader(src, entry.Path, fi)
+				if err != nil {
+					return err
+				}
+				disk.ModTime, disk.Uid, disk.Gid, disk.Uname, disk.Gname = header.ModTime, header.Uid, header.Gid, header.Uname, header.Gname
+				header = *disk
+			}
+		}
 
-		// if no more files are found return
-		case err == io.EOF:
-			return nil
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+			// sparse entries were expanded on extraction and go back dense
+			header.Typeflag = tar.TypeReg
+			header.Size = fi.Size()
 
-		// return any other error
-		case err != nil:
+		case tar.TypeLink:
+			target, err := sanitizeName(header.Linkname)
+			if err == nil && emitted[filepath.ToSlash(target)] {
+				break
+			}
+			// the link target was dropped, so ship the materialized copy
+			header.Typeflag = tar.TypeReg
+			header.Linkname = ""
+			header.Size = fi.Size()
+		}
+
+		if err := writeTarHeader(tw, &header); err != nil {
 			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if err := copyFileTo(tw, file); err != nil {
+				return err
+			}
+		}
+		emitted[entry.Path] = true
+	}
 
-		// if the header is nil, just skip it (not sure how this happens)
-		case header == nil:
-			continue
+	err := walkExtras(src, m.paths(), func(rel string, fi os.FileInfo) error {
+		header, err := tarDiskHeader(src, rel, fi)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg {
+			return copyFileTo(tw, filepath.Join(src, filepath.FromSlash(rel)))
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
 
-		// the target location where the dir/file should be created
-		target := filepath.Join(dst, header.Name)
+// tarHeader builds the header of the file at rel, a slash-separated path
+// below the packed directory, from its on-disk metadata.
+func tarHeader(rel string, fi os.FileInfo, link string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = rel
+	if fi.IsDir() {
+		header.Name += "/"
+	}
+	return header, nil
+}
 
-		// the following switch could also be done using fi.Mode(), not sure if there
-		// a benefit of using one vs. the other.
-		// fi := header.FileInfo()
+// tarDiskHeader is tarHeader for a file under src, reading symlink targets.
+func tarDiskHeader(src, rel string, fi os.FileInfo) (*tar.Header, error) {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(filepath.Join(src, filepath.FromSlash(rel))); err != nil {
+			return nil, err
+		}
+	}
+	return tarHeader(rel, fi, link)
+}
 
-		// check the file type
-		switch header.Typeflag {
+// tarTypeMatches reports whether Unpack leaves an entry of type typeflag as
+// a file of mode on disk. Hard links are extracted as regular files.
+func tarTypeMatches(typeflag byte, mode os.FileMode) bool {
+	switch typeflag {
+	case tar.TypeDir:
+		return mode.IsDir()
+	case tar.TypeSymlink:
+		return mode&os.ModeSymlink != 0
+	default:
+		return mode.IsRegular()
+	}
+}
 
-		// if its a dir and it doesn't exist create it
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0755); err != nil {
-					return err
-				}
-			}
+// extractedTarTypes are the entry types Unpack materializes on disk.
+var extractedTarTypes = map[byte]bool{
+	tar.TypeReg:       true,
+	tar.TypeGNUSparse: true,
+	tar.TypeDir:       true,
+	tar.TypeSymlink:   true,
+	tar.TypeLink:      true,
+}
 
-		// if it's a file create it
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
+// writeTarHeader writes header in its original format and falls back to a
+// format the writer picks when the original cannot encode a changed field,
+// e.g. a filtered entry that grew past the USTAR size limit. WriteHeader
+// validates before writing, so a failed attempt leaves no partial output.
+func writeTarHeader(tw *tar.Writer, header *tar.Header) error {
+	err := tw.WriteHeader(header)
+	if err == nil || header.Format == tar.FormatUnknown {
+		return err
+	}
+	header.Format = tar.FormatUnknown
+	return tw.WriteHeader(header)
+}
 
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
-				return err
-			}
+func copyFileTo(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Pack writes the regular files under src as a tar archive and closes writer.
+func Pack(src string, writer io.WriteCloser) error {
+	err := Tar.Pack(src, writer)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
 
-			// manually close here after each file operation; defering would cause each file close
-			// to wait until all operations have completed.
-			_ = f.Close()
+// Unpack extracts a tar archive into dst without limits. Entries that would
+// land outside dst are still rejected.
+func Unpack(r io.Reader, dst string) error {
+	_, err := Tar.Unpack(r, dst, Limits{})
+	return err
+}
+
+// IsTar reports whether head, the first bytes of a stream, starts with a tar
+// header. POSIX and GNU archives carry a "ustar" magic; pre-POSIX archives
+// are recognized by their header checksum.
+func IsTar(head []byte) bool {
+	const blockSize = 512
+	if len(head) < blockSize {
+		return false
+	}
+	if bytes.Equal(head[257:262], []byte("ustar")) {
+		return true
+	}
+
+	stored, err := strconv.ParseUint(strings.Trim(string(head[148:156]), " \x00"), 8, 64)
+	if err != nil {
+		return false
+	}
+	var sum uint64
+	for i, b := range head[:blockSize] {
+		if i >= 148 && i < 156 {
+			b = ' '
 		}
+		sum += uint64(b)
 	}
+	return sum == stored
 }
@@ -0,0 +1,262 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var (
+	zipLocalMagic = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+)
+
+type zipFormat struct{}
+
+func (zipFormat) Name() string { return "zip" }
+
+// Unpack extracts a ZIP archive into dst. ZIP keeps its directory at the end
+// of the file, so a stream that is not an *os.File is spooled next to dst
+// first. ZIP64 archives are read transparently.
+func (zipFormat) Unpack(r io.Reader, dst string, limits Limits) (*Manifest, error) {
+	f, ok := r.(*os.File)
+	if !ok {
+		spool, err := os.CreateTemp(filepath.Dir(dst), "zip-spool-")
+		if err != nil {
+			return nil, fmt.Errorf("create zip spool: %w", err)
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		if _, err := io.Copy(spool, r); err != nil {
+			return nil, fmt.Errorf("spool zip: %w", err)
+		}
+		f = spool
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("read zip directory: %w", err)
+	}
+
+	ex, err := newExtractor("zip", dst, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer ex.Close()
+
+	for _, zf := range zr.File {
+		header := zf.FileHeader
+		name, err := ex.entry(zf.Name, &header)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = ex.mkdirAll(name)
+		case mode&fs.ModeSymlink != 0:
+			err = extractZipSymlink(ex, name, zf)
+		case mode.IsRegular():
+			err = extractZipFile(ex, name, zf)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ex.manifest, nil
+}
+
+func extractZipFile(ex *extractor, name string, zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("open zip entry %s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+
+	if err := ex.file(name, zf.Mode(), int64(zf.UncompressedSize64), rc); err != nil {
+		return fmt.Errorf("extract zip entry %s: %w", zf.Name, err)
+	}
+	return nil
+}
+
+// extractZipSymlink recreates a symlink entry, whose content is the target.
+func extractZipSymlink(ex *extractor, name string, zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("open zip entry %s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return fmt.Errorf("read zip symlink %s: %w", zf.Name, err)
+	}
+	return ex.symlink(name, string(target))
+}
+
+// Pack writes the tree under src as a deflated ZIP archive, keeping
+// directories and symlinks. Entries switch to ZIP64 records automatically
+// once they pass 4 GiB.
+func (zipFormat) Pack(src string, w io.Writer) error {
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("unable to zip files - %v", err.Error())
+	}
+
+	zw := zip.NewWriter(w)
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == src {
+			return nil
+		}
+		isSymlink := fi.Mode()&os.ModeSymlink != 0
+		if !fi.Mode().IsRegular() && !fi.IsDir() && !isSymlink {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		header, err := zipHeader(filepath.ToSlash(rel), fi)
+		if err != nil {
+			return err
+		}
+		return writeZipEntry(zw, header, file, fi)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Repack re-emits the entries of m in their original order, keeping names,
+// comments, modification times, compression methods and attributes. Sizes
+// and CRCs are recomputed, and extra fields are dropped because they may
+// carry stale ZIP64 sizes; the writer adds fresh ones as needed.
+func (zipFormat) Repack(src string, m *Manifest, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range m.Entries {
+		orig, ok := entry.Header.(*zip.FileHeader)
+		if !ok {
+			return fmt.Errorf("repack zip: entry %q has no zip header", entry.Path)
+		}
+		if entry.Path == "" {
+			continue
+		}
+		file := filepath.Join(src, filepath.FromSlash(entry.Path))
+		fi, err := os.Lstat(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:           orig.Name,
+			Comment:        orig.Comment,
+			NonUTF8:        orig.NonUTF8,
+			CreatorVersion: orig.CreatorVersion,
+			ExternalAttrs:  orig.ExternalAttrs,
+			Method:         orig.Method,
+			Modified:       orig.Modified,
+		}
+		if !zipTypeMatches(orig.Mode(), fi.Mode()) {
+			// a later duplicate replaced the entry with another kind of
+			// file, e.g. a symlink over a regular file; ship what is on
+			// disk under the entry's comment and mtime
+			if header, err = zipHeader(entry.Path, fi); err != nil {
+				return err
+			}
+			header.Comment, header.NonUTF8, header.Modified = orig.Comment, orig.NonUTF8, orig.Modified
+		}
+		if header.Modified.IsZero() {
+			header.SetModTime(orig.ModTime())
+		}
+		if err := writeZipEntry(zw, header, file, fi); err != nil {
+			return err
+		}
+	}
+
+	err := walkExtras(src, m.paths(), func(rel string, fi os.FileInfo) error {
+		header, err := zipHeader(rel, fi)
+		if err != nil {
+			return err
+		}
+		return writeZipEntry(zw, header, filepath.Join(src, filepath.FromSlash(rel)), fi)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// zipTypeMatches reports whether an entry of mode is what Unpack leaves as
+// a file of disk mode.
+func zipTypeMatches(mode, disk os.FileMode) bool {
+	switch {
+	case mode.IsDir():
+		return disk.IsDir()
+	case mode&os.ModeSymlink != 0:
+		return disk&os.ModeSymlink != 0
+	default:
+		return disk.IsRegular()
+	}
+}
+
+// zipHeader builds a deflated entry header for the file at rel, a
+// slash-separated path below the packed directory.
+func zipHeader(rel string, fi os.FileInfo) (*zip.FileHeader, error) {
+	header, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = rel
+	if fi.IsDir() {
+		header.Name += "/"
+	}
+	header.Method = zip.Deflate
+	return header, nil
+}
+
+// writeZipEntry writes header followed by the content of file: nothing for
+// directories, the link target for symlinks, and the file data otherwise.
+func writeZipEntry(zw *zip.Writer, header *zip.FileHeader, file string, fi os.FileInfo) error {
+	if fi.IsDir() {
+		_, err := zw.CreateHeader(header)
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		header.Method = zip.Store
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(entry, filepath.ToSlash(target))
+		return err
+	}
+
+	entry, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	return copyFileTo(entry, file)
+}
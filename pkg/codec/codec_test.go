@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+const sample = "INSERT INTO t VALUES (1);\n"
+
+// sampleBzip2 is sample compressed with bzip2(1); the codec cannot write it.
+var sampleBzip2 = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x70, 0xe3, 0xf1, 0x02, 0x00, 0x00,
+	0x06, 0x5e, 0x80, 0x00, 0x10, 0x40, 0x60, 0x20, 0x08, 0x22, 0x25, 0x9f, 0x00, 0x04, 0x00, 0x20,
+	0x00, 0x22, 0x20, 0x18, 0x8c, 0xd4, 0x7a, 0x85, 0x34, 0xc8, 0xc4, 0xc4, 0xc4, 0xcc, 0x82, 0x34,
+	0xec, 0xe5, 0x8f, 0x16, 0x02, 0x40, 0x4f, 0x1a, 0x95, 0x4f, 0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48,
+	0x38, 0x71, 0xf8, 0x81, 0x00,
+}
+
+func TestRoundTripAndDetect(t *testing.T) {
+	for _, name := range []string{"gzip", "zstd", "xz", None} {
+		c, err := Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		w, err := c.NewWriter(&buf, c.MaxLevel)
+		if err != nil {
+			t.Fatalf("%s writer: %v", name, err)
+		}
+		if _, err := io.WriteString(w, sample); err != nil {
+			t.Fatalf("%s write: %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s close: %v", name, err)
+		}
+
+		// The misleading extension proves detection prefers magic bytes.
+		detected, err := Detect(bufio.NewReader(bytes.NewReader(buf.Bytes())), "dump.sql.bz2")
+		if name == None {
+			if err != nil || detected.Name != "bzip2" {
+				t.Fatalf("plain stream should fall back to extension, got %v %v", detected, err)
+			}
+			continue
+		}
+		if err != nil || detected.Name != name {
+			t.Fatalf("detect %s: got %v %v", name, detected, err)
+		}
+
+		assertDecodes(t, c, buf.Bytes())
+	}
+}
+
+func TestBzip2IsDecompressOnly(t *testing.T) {
+	c, err := Detect(bufio.NewReader(bytes.NewReader(sampleBzip2)), "dump")
+	if err != nil || c.Name != "bzip2" {
+		t.Fatalf("expected bzip2, got %v %v", c, err)
+	}
+	if c.CanWrite() {
+		t.Fatalf("bzip2 should be decompress-only")
+	}
+	assertDecodes(t, c, sampleBzip2)
+}
+
+func TestTrimExtension(t *testing.T) {
+	tests := map[string]string{
+		"db.sql.gz":     "db.sql",
+		"backup.tgz":    "backup.tar",
+		"backup.tar.xz": "backup.tar",
+		"backup.tzst":   "backup.tar",
+		"dump.sql":      "dump.sql",
+	}
+	for in, want := range tests {
+		if got := TrimExtension(in); got != want {
+			t.Fatalf("TrimExtension(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	gz, _ := Lookup("gzip")
+	if err := gz.ValidateLevel(10); err == nil {
+		t.Fatalf("expected gzip level 10 to be rejected")
+	}
+	none, _ := Lookup(None)
+	if err := none.ValidateLevel(3); err == nil {
+		t.Fatalf("expected levels to be rejected for %s", None)
+	}
+}
+
+func TestXZLevelsUseGrowingDictionaries(t *testing.T) {
+	for i := 1; i < len(xzDictCaps); i++ {
+		if xzDictCaps[i] <= xzDictCaps[i-1] {
+			t.Fatalf("xz level %d dictionary %d is not larger than level %d's %d", i+1, xzDictCaps[i], i, xzDictCaps[i-1])
+		}
+	}
+	xzc, _ := Lookup("xz")
+	for level := xzc.MinLevel; level <= xzc.MaxLevel; level++ {
+		var buf bytes.Buffer
+		w, err := xzc.NewWriter(&buf, level)
+		if err != nil {
+			t.Fatalf("xz level %d writer: %v", level, err)
+		}
+		if _, err := io.WriteString(w, sample); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		assertDecodes(t, xzc, buf.Bytes())
+	}
+}
+
+func assertDecodes(t *testing.T, c *Codec, data []byte) {
+	t.Helper()
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s reader: %v", c.Name, err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s read: %v", c.Name, err)
+	}
+	if string(got) != sample {
+		t.Fatalf("%s round trip mismatch: %q", c.Name, got)
+	}
+}
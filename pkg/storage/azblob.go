@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	blobsdk "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// AzureOptions configure the azblob://container/blob backend. A connection
+// string wins over an account key; with neither, requests are anonymous
+// unless Endpoint carries a SAS token.
+type AzureOptions struct {
+	Account string
+	Key     string
+	// ConnectionString is what the portal and Azurite hand out, e.g.
+	// "UseDevelopmentStorage=true".
+	ConnectionString string
+	// Endpoint is the blob service URL, by default
+	// https://<account>.blob.core.windows.net/; for Azurite
+	// http://127.0.0.1:10000/devstoreaccount1.
+	Endpoint string
+	// BlockSize is the size of uploaded blocks, 0 for the SDK default.
+	// Blocks are buffered in memory.
+	BlockSize int64
+}
+
+// Azure is the Azure Blob Storage backend. The client is created on first
+// use.
+type Azure struct {
+	opts AzureOptions
+
+	once   sync.Once
+	client *azblob.Client
+	err    error
+}
+
+func NewAzure(opts AzureOptions) *Azure {
+	return &Azure{opts: opts}
+}
+
+func (a *Azure) connect() (*azblob.Client, error) {
+	a.once.Do(func() {
+		a.client, a.err = a.newClient()
+		if a.err != nil {
+			a.err = fmt.Errorf("create Azure Blob client: %w", a.err)
+		}
+	})
+	return a.client, a.err
+}
+
+func (a *Azure) newClient() (*azblob.Client, error) {
+	if a.opts.ConnectionString != "" {
+		return azblob.NewClientFromConnectionString(a.opts.ConnectionString, nil)
+	}
+	endpoint := a.opts.Endpoint
+	if endpoint == "" {
+		if a.opts.Account == "" {
+			return nil, errors.New("set an account, an endpoint or a connection string")
+		}
+		endpoint = "https://" + a.opts.Account + ".blob.core.windows.net/"
+	}
+	if a.opts.Key != "" {
+		cred, err := azblob.NewSharedKeyCredential(a.opts.Account, a.opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+	}
+	return azblob.NewClientWithNoCredential(endpoint, nil)
+}
+
+func (a *Azure) Open(ctx context.Context, u *url.URL) (io.ReadCloser, Info, error) {
+	container, blob, err := bucketLocation(u)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	client, err := a.connect()
+	if err != nil {
+		return nil, Info{}, err
+	}
+	resp, err := client.DownloadStream(ctx, container, blob, nil)
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("get azblob://%s/%s: %w", container, blob, err)
+	}
+	info := Info{Name: objectName(blob), Size: -1}
+	if resp.ContentLength != nil {
+		info.Size = *resp.ContentLength
+	}
+	if resp.LastModified != nil {
+		info.ModTime = *resp.LastModified
+	}
+	return resp.Body, info, nil
+}
+
+func (a *Azure) Stat(ctx context.Context, u *url.URL) (Info, error) {
+	container, blob, err := bucketLocation(u)
+	if err != nil {
+		return Info{}, err
+	}
+	client, err := a.connect()
+	if err != nil {
+		return Info{}, err
+	}
+	props, err := client.ServiceClient().NewContainerClient(container).NewBlobClient(blob).GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return Info{}, fmt.Errorf("azblob://%s/%s: %w", container, blob, fs.ErrNotExist)
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("stat azblob://%s/%s: %w", container, blob, err)
+	}
+	info := Info{Name: objectName(blob), Size: -1}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.ModTime = *props.LastModified
+	}
+	return info, nil
+}
+
+func (a *Azure) Remove(ctx context.Context, u *url.URL) error {
+	container, blob, err := bucketLocation(u)
+	if err != nil {
+		return err
+	}
+	client, err := a.connect()
+	if err != nil {
+		return err
+	}
+	if _, err := client.DeleteBlob(ctx, container, blob, nil); err != nil {
+		return fmt.Errorf("delete azblob://%s/%s: %w", container, blob, err)
+	}
+	return nil
+}
+
+func (a *Azure) List(ctx context.Context, u *url.URL) ([]Object, error) {
+	container, prefix, err := bucketPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	pages := client.NewListBlobsFlatPager(container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list azblob://%s/%s: %w", container, prefix, err)
+		}
+		for _, blob := range page.Segment.BlobItems {
+			name := *blob.Name
+			if name == prefix || strings.Contains(name[len(prefix):], "/") {
+				continue // a directory marker or a blob in a subdirectory
+			}
+			info := Info{Name: objectName(name), Size: -1}
+			if p := blob.Properties; p != nil {
+				if p.ContentLength != nil {
+					info.Size = *p.ContentLength
+				}
+				if p.LastModified != nil {
+					info.ModTime = *p.LastModified
+				}
+			}
+			objects = append(objects, childObject(u, name, info))
+		}
+	}
+	return objects, nil
+}
+
+// Create streams the blob as staged blocks. The block list is committed
+// only by Close; after Abort the staged blocks are never committed and the
+// service discards them.
+func (a *Azure) Create(ctx context.Context, u *url.URL) (Writer, error) {
+	return a.create(ctx, u, false)
+}
+
+// CreateExclusive is Create with If-None-Match: *, so that the block list is
+// not committed if the blob exists by then.
+func (a *Azure) CreateExclusive(ctx context.Context, u *url.URL) (Writer, error) {
+	return a.create(ctx, u, true)
+}
+
+func (a *Azure) create(ctx context.Context, u *url.URL, exclusive bool) (Writer, error) {
+	container, blob, err := bucketLocation(u)
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	w := &pipeWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		opts := &azblob.UploadStreamOptions{BlockSize: a.opts.BlockSize}
+		if exclusive {
+			anyTag := azcore.ETagAny
+			opts.AccessConditions = &azblob.AccessConditions{
+				ModifiedAccessConditions: &blobsdk.ModifiedAccessConditions{IfNoneMatch: &anyTag},
+			}
+		}
+		_, err := client.UploadStream(ctx, container, blob, pr, opts)
+		if bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists) {
+			err = fmt.Errorf("%w: %w", fs.ErrExist, err)
+		}
+		if err != nil {
+			err = fmt.Errorf("upload azblob://%s/%s: %w", container, blob, err)
+		}
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
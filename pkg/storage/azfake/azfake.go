@@ -0,0 +1,237 @@
+// Package azfake is an in-process Azure Blob Storage server for tests. It
+// speaks just enough of the REST API for single-shot and staged block
+// uploads with an If-None-Match: * condition, downloads, property reads,
+// deletes and flat listings, keeps blobs in memory and ignores signatures.
+package azfake
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Account is the storage account the server answers for, the one Azurite
+// uses.
+const Account = "devstoreaccount1"
+
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	blobs  map[string]blob
+	staged map[string]map[string][]byte // blob path -> block id -> data
+}
+
+type blob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// New starts a server; callers Close it.
+func New() *Server {
+	s := &Server{blobs: map[string]blob{}, staged: map[string]map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint is the blob service URL of Account to configure clients with.
+func (s *Server) Endpoint() string {
+	return s.URL + "/" + Account
+}
+
+// Put stores a blob as if it had been uploaded at modTime.
+func (s *Server) Put(container, name string, data []byte, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[container+"/"+name] = blob{data: bytes.Clone(data), modTime: modTime}
+}
+
+// Blob returns a stored blob.
+func (s *Server) Blob(container, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blobs[container+"/"+name]
+	return b.data, ok
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, "/"+Account+"/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "unknown account")
+		return
+	}
+	q := r.URL.Query()
+	if !strings.Contains(path, "/") {
+		if r.Method == http.MethodGet && q.Get("restype") == "container" && q.Get("comp") == "list" {
+			s.list(w, path, q.Get("prefix"))
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "container operations are not supported")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidInput", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && q.Get("comp") == "block":
+		if s.staged[path] == nil {
+			s.staged[path] = map[string][]byte{}
+		}
+		s.staged[path][q.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodPut && q.Get("comp") == "blocklist":
+		var list struct {
+			IDs []string `xml:",any"`
+		}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidXmlDocument", err.Error())
+			return
+		}
+		var data []byte
+		for _, id := range list.IDs {
+			block, ok := s.staged[path][id]
+			if !ok {
+				writeError(w, http.StatusBadRequest, "InvalidBlockList", fmt.Sprintf("block %s was not staged", id))
+				return
+			}
+			data = append(data, block...)
+		}
+		if s.refuseExisting(w, r, path) {
+			return
+		}
+		delete(s.staged, path)
+		s.storeLocked(w, path, data)
+
+	case r.Method == http.MethodPut && !q.Has("comp"):
+		if s.refuseExisting(w, r, path) {
+			return
+		}
+		s.storeLocked(w, path, body)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		b, ok := s.blobs[path]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+		w.Header().Set("ETag", etag(b))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, path, b.modTime, bytes.NewReader(b.data))
+
+	case r.Method == http.MethodDelete:
+		if _, ok := s.blobs[path]; !ok {
+			writeError(w, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+			return
+		}
+		delete(s.blobs, path)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" is not supported")
+	}
+}
+
+// refuseExisting answers a write conditional on If-None-Match: * with 409
+// when the blob exists.
+func (s *Server) refuseExisting(w http.ResponseWriter, r *http.Request, path string) bool {
+	if _, ok := s.blobs[path]; !ok || r.Header.Get("If-None-Match") != "*" {
+		return false
+	}
+	writeError(w, http.StatusConflict, "BlobAlreadyExists", "The specified blob already exists.")
+	return true
+}
+
+func (s *Server) storeLocked(w http.ResponseWriter, path string, data []byte) {
+	b := blob{data: data, modTime: time.Now()}
+	s.blobs[path] = b
+	w.Header().Set("ETag", etag(b))
+	w.Header().Set("Last-Modified", b.modTime.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// list answers List Blobs without a delimiter in a single page.
+func (s *Server) list(w http.ResponseWriter, container, prefix string) {
+	type properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		ETag          string `xml:"Etag"`
+		ContentLength int    `xml:"Content-Length"`
+		BlobType      string
+	}
+	type item struct {
+		Name       string
+		Properties properties
+	}
+	result := struct {
+		XMLName       xml.Name `xml:"EnumerationResults"`
+		ContainerName string   `xml:"ContainerName,attr"`
+		Prefix        string
+		Blobs         []item `xml:"Blobs>Blob"`
+		NextMarker    string
+	}{ContainerName: container, Prefix: prefix}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, full := range s.keysLocked() {
+		c, name, _ := strings.Cut(full, "/")
+		if c != container || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		b := s.blobs[full]
+		result.Blobs = append(result.Blobs, item{Name: name, Properties: properties{
+			LastModified:  b.modTime.UTC().Format(http.TimeFormat),
+			ETag:          etag(b),
+			ContentLength: len(b.data),
+			BlobType:      "BlockBlob",
+		}})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func etag(b blob) string {
+	return "\"0x" + strconv.FormatInt(b.modTime.UnixNano(), 16) + "\""
+}
+
+// writeError answers with the error code in x-ms-error-code, where clients
+// look for it, and in the body.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
+
+// Keys lists the stored blob paths as "container/name", sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keysLocked()
+}
+
+func (s *Server) keysLocked() []string {
+	keys := make([]string, 0, len(s.blobs))
+	for k := range s.blobs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ParseChecksum checks a checksum spec of the form "algorithm:hex", e.g.
+// "sha256:9f86d08...".
+func ParseChecksum(spec string) (string, []byte, error) {
+	algo, digest, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	algo = strings.ToLower(algo)
+	newHash, known := checksumAlgorithms[algo]
+	if !ok || !known {
+		names := make([]string, 0, len(checksumAlgorithms))
+		for name := range checksumAlgorithms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("checksum %q must be ALGORITHM:HEX with algorithm %s", spec, strings.Join(names, ", "))
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil || len(sum) != newHash().Size() {
+		return "", nil, fmt.Errorf("checksum %q is not a hex %s digest", spec, algo)
+	}
+	return algo, sum, nil
+}
+
+// VerifyChecksum wraps r so that its EOF turns into an error unless the
+// bytes read match spec. Readers must read to EOF for the check to run.
+func VerifyChecksum(r io.Reader, spec string) (io.Reader, error) {
+	algo, sum, err := ParseChecksum(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumReader{r: r, algo: algo, want: sum, hash: checksumAlgorithms[algo]()}, nil
+}
+
+type checksumReader struct {
+	r    io.Reader
+	algo string
+	want []byte
+	hash hash.Hash
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := c.hash.Sum(nil); !bytes.Equal(got, c.want) {
+			return n, fmt.Errorf("%s checksum mismatch: got %x, want %x", c.algo, got, c.want)
+		}
+	}
+	return n, err
+}
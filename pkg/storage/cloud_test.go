@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage/azfake"
+	"github.com/d00p1/filtrate-backups/pkg/storage/gcsfake"
+)
+
+// The GCS and Azure backends are tested against the in-process fakes in
+// gcsfake and azfake. The emulator-backed integration tests run only when
+// the emulator's address is set:
+//
+//	fake-gcs-server -scheme http -port 4443 &
+//	STORAGE_EMULATOR_HOST=localhost:4443 go test ./pkg/storage
+//
+//	azurite-blob --blobPort 10000 &
+//	AZURITE_BLOB_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1 go test ./pkg/storage
+
+// azuriteKey is the well-known key of Azurite's devstoreaccount1.
+const azuriteKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+func TestGCSEmulator(t *testing.T) {
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		t.Skip("STORAGE_EMULATOR_HOST is not set")
+	}
+	g := NewGCS(GCSOptions{Anonymous: true})
+	client, err := g.connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Bucket("filtrate-test").Create(context.Background(), "test", nil)
+	testObjectStore(t, Backends{"gs": g}, "gs://filtrate-test/daily/db.sql.gz")
+}
+
+func TestGCSFake(t *testing.T) {
+	fake := gcsfake.New()
+	t.Cleanup(fake.Close)
+	g := NewGCS(GCSOptions{Endpoint: fake.Endpoint(), Anonymous: true, ChunkSize: 4 << 20})
+	testObjectStore(t, Backends{"gs": g}, "gs://filtrate-test/daily/db.sql.gz")
+}
+
+func TestAzuriteEmulator(t *testing.T) {
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURITE_BLOB_ENDPOINT is not set")
+	}
+	a := NewAzure(AzureOptions{Account: "devstoreaccount1", Key: azuriteKey, Endpoint: endpoint})
+	client, err := a.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = client.CreateContainer(context.Background(), "filtrate-test", nil)
+	testObjectStore(t, Backends{"azblob": a}, "azblob://filtrate-test/daily/db.sql.gz")
+}
+
+func TestAzureFake(t *testing.T) {
+	fake := azfake.New()
+	t.Cleanup(fake.Close)
+	a := NewAzure(AzureOptions{Account: azfake.Account, Key: azuriteKey, Endpoint: fake.Endpoint(), BlockSize: 4 << 20})
+	testObjectStore(t, Backends{"azblob": a}, "azblob://filtrate-test/daily/db.sql.gz")
+}
+
+// testObjectStore uploads and reads back an object spanning several chunks,
+// lists it, checks that an aborted upload leaves nothing behind, and removes it.
+func testObjectStore(t *testing.T, backends Backends, location string) {
+	t.Helper()
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 20<<20/26)
+
+	w, err := backends.Create(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, info, err := backends.Open(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || info.Size != int64(len(data)) || info.Name != "db.sql.gz" {
+		t.Fatalf("read back %d bytes with info %+v, want %d", len(got), info, len(data))
+	}
+
+	objects, err := backends.List(ctx, location[:strings.LastIndex(location, "/")])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Location != location || objects[0].Size != int64(len(data)) {
+		t.Fatalf("listing %+v, want only %s", objects, location)
+	}
+	if ok, err := backends.Exists(ctx, location+".missing"); ok || err != nil {
+		t.Fatalf("Exists(missing) = %v, %v", ok, err)
+	}
+
+	w, err = backends.Create(ctx, location+".aborted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := backends.Open(ctx, location+".aborted"); err == nil {
+		t.Fatal("aborted upload was committed")
+	}
+
+	exclusiveCreate(t, backends, location, func() {})
+	if info, err := backends.Stat(ctx, location); err != nil || info.Size != int64(len(data)) {
+		t.Fatalf("refused upload replaced the object: %+v, %v", info, err)
+	}
+
+	if err := backends.Remove(ctx, location); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := backends.Exists(ctx, location); ok || err != nil {
+		t.Fatalf("Exists(removed) = %v, %v", ok, err)
+	}
+}
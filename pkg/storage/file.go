@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// File is the local file system backend.
+type File struct{}
+
+func (File) Open(_ context.Context, u *url.URL) (io.ReadCloser, Info, error) {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info := Info{Name: filepath.Base(u.Path), Size: -1}
+	if fi, err := f.Stat(); err == nil {
+		info.Size, info.ModTime = fi.Size(), fi.ModTime()
+	}
+	return f, info, nil
+}
+
+func (File) Stat(_ context.Context, u *url.URL) (Info, error) {
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Name: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (File) Remove(_ context.Context, u *url.URL) error {
+	return os.Remove(u.Path)
+}
+
+// List lists the regular files in the directory u names.
+func (File) List(_ context.Context, u *url.URL) ([]Object, error) {
+	entries, err := os.ReadDir(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		objects = append(objects, Object{
+			Location: filepath.Join(u.Path, e.Name()),
+			Info:     Info{Name: e.Name(), Size: fi.Size(), ModTime: fi.ModTime()},
+		})
+	}
+	return objects, nil
+}
+
+// Create writes to a hidden temporary file next to u, which Close syncs and
+// renames into place, so a crashed or failed run never leaves a truncated
+// file under the final name. Abort removes the temporary file.
+func (File) Create(_ context.Context, u *url.URL) (Writer, error) {
+	return createFile(u.Path, false)
+}
+
+// CreateExclusive is Create, but Close hard-links the file into place, which
+// fails if a file exists there.
+func (File) CreateExclusive(_ context.Context, u *url.URL) (Writer, error) {
+	return createFile(u.Path, true)
+}
+
+func createFile(name string, exclusive bool) (Writer, error) {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create output dir: %w", err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".partial-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: f, path: name, exclusive: exclusive}, nil
+}
+
+type fileWriter struct {
+	*os.File
+	path      string
+	exclusive bool
+}
+
+func (w *fileWriter) Close() error {
+	err := w.Chmod(0o644)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = w.publish()
+	}
+	if err != nil {
+		_ = os.Remove(w.Name())
+		return err
+	}
+	// persist the rename itself; not every platform can sync a directory
+	if d, err := os.Open(filepath.Dir(w.path)); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func (w *fileWriter) publish() error {
+	if !w.exclusive {
+		return os.Rename(w.Name(), w.path)
+	}
+	if err := os.Link(w.Name(), w.path); err != nil {
+		return err
+	}
+	_ = os.Remove(w.Name()) // published already; Close retries on failure
+	return nil
+}
+
+func (w *fileWriter) Abort() error {
+	_ = w.File.Close()
+	return os.Remove(w.Name())
+}
@@ -0,0 +1,346 @@
+// Package gcsfake is an in-process Google Cloud Storage server for tests. It
+// speaks just enough of the JSON API for multipart and resumable uploads
+// with a does-not-exist precondition, media and metadata reads, deletes and
+// object listings, keeps objects in memory and ignores credentials.
+package gcsfake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiPrefix    = "/storage/v1/b/"
+	uploadPrefix = "/upload/storage/v1/b/"
+)
+
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	objects    map[string]object
+	uploads    map[string]*upload
+	nextID     int
+	generation int64
+}
+
+type object struct {
+	data       []byte
+	modTime    time.Time
+	generation int64
+}
+
+type upload struct {
+	bucket, name string
+	exclusive    bool
+	data         []byte
+}
+
+// New starts a server; callers Close it.
+func New() *Server {
+	s := &Server{objects: map[string]object{}, uploads: map[string]*upload{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint is the JSON API endpoint to configure clients with.
+func (s *Server) Endpoint() string {
+	return s.URL + "/storage/v1/"
+}
+
+// Put stores an object as if it had been uploaded at modTime.
+func (s *Server) Put(bucket, name string, data []byte, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.storeLocked(bucket, name, bytes.Clone(data), modTime)
+}
+
+// Object returns a stored object.
+func (s *Server) Object(bucket, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[bucket+"/"+name]
+	return obj.data, ok
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if rest, ok := strings.CutPrefix(r.URL.Path, uploadPrefix); ok && r.Method == http.MethodPost {
+		bucket, _, _ := strings.Cut(rest, "/")
+		switch {
+		case q.Has("upload_id"):
+			s.uploadChunk(w, r, q.Get("upload_id"))
+		case q.Get("uploadType") == "resumable":
+			s.startUpload(w, r, bucket)
+		case q.Get("uploadType") == "multipart":
+			s.uploadMultipart(w, r, bucket)
+		default:
+			writeError(w, http.StatusNotImplemented, "notImplemented", "upload type "+q.Get("uploadType")+" is not supported")
+		}
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, apiPrefix)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "notImplemented", r.URL.Path+" is not supported")
+		return
+	}
+	bucket, rest, _ := strings.Cut(rest, "/")
+	if rest == "o" && r.Method == http.MethodGet {
+		s.list(w, bucket, q)
+		return
+	}
+	name, ok := strings.CutPrefix(rest, "o/")
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "notImplemented", "bucket operations are not supported")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, exists := s.objects[bucket+"/"+name]
+	switch {
+	case !exists:
+		writeError(w, http.StatusNotFound, "notFound", "no such object: "+bucket+"/"+name)
+
+	case r.Method == http.MethodGet && q.Get("alt") == "media":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.generation, 10))
+		w.Header().Set("X-Goog-Metageneration", "1")
+		http.ServeContent(w, r, name, obj.modTime, bytes.NewReader(obj.data))
+
+	case r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, resource(bucket, name, obj))
+
+	case r.Method == http.MethodDelete:
+		delete(s.objects, bucket+"/"+name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotImplemented, "notImplemented", r.Method+" is not supported")
+	}
+}
+
+// startUpload opens a resumable upload session and hands its URL back in
+// the Location header.
+func (s *Server) startUpload(w http.ResponseWriter, r *http.Request, bucket string) {
+	var meta struct{ Name string }
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	up := &upload{bucket: bucket, name: objectName(r, meta.Name), exclusive: r.URL.Query().Get("ifGenerationMatch") == "0"}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.uploads[id] = up
+	w.Header().Set("Location", s.URL+uploadPrefix+url.PathEscape(bucket)+"/o?uploadType=resumable&upload_id="+id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// uploadChunk appends one chunk to a resumable upload. Chunks of unknown
+// total size ("bytes 0-9/*") are acknowledged as incomplete; the chunk that
+// names the total finalizes the object.
+func (s *Server) uploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up := s.uploads[id]
+	if up == nil {
+		writeError(w, http.StatusNotFound, "notFound", "unknown upload "+id)
+		return
+	}
+	up.data = append(up.data, body...)
+
+	contentRange := r.Header.Get("Content-Range")
+	if strings.HasSuffix(contentRange, "/*") {
+		// the status the client asked to get as 200 with X-GUploader-No-308
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(up.data)-1))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	total, err := strconv.Atoi(contentRange[strings.LastIndex(contentRange, "/")+1:])
+	if err != nil || total != len(up.data) {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Content-Range %q does not match %d uploaded bytes", contentRange, len(up.data)))
+		return
+	}
+	delete(s.uploads, id)
+	s.finishLocked(w, up)
+}
+
+// uploadMultipart stores an object sent as one multipart/related request:
+// its JSON metadata, then its data.
+func (s *Server) uploadMultipart(w http.ResponseWriter, r *http.Request, bucket string) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var parts [2][]byte
+	for i := range parts {
+		part, err := mr.NextPart()
+		if err == nil {
+			parts[i], err = io.ReadAll(part)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("multipart part %d: %v", i, err))
+			return
+		}
+	}
+	var meta struct{ Name string }
+	if err := json.Unmarshal(parts[0], &meta); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finishLocked(w, &upload{
+		bucket:    bucket,
+		name:      objectName(r, meta.Name),
+		exclusive: r.URL.Query().Get("ifGenerationMatch") == "0",
+		data:      parts[1],
+	})
+}
+
+// finishLocked stores a completed upload, unless it is conditional on the
+// object not existing and it does.
+func (s *Server) finishLocked(w http.ResponseWriter, up *upload) {
+	if _, exists := s.objects[up.bucket+"/"+up.name]; exists && up.exclusive {
+		writeError(w, http.StatusPreconditionFailed, "conditionNotMet", "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+	obj := s.storeLocked(up.bucket, up.name, up.data, time.Now())
+	writeJSON(w, http.StatusOK, resource(up.bucket, up.name, obj))
+}
+
+func (s *Server) storeLocked(bucket, name string, data []byte, modTime time.Time) object {
+	s.generation++
+	obj := object{data: data, modTime: modTime, generation: s.generation}
+	s.objects[bucket+"/"+name] = obj
+	return obj
+}
+
+// list answers objects.list in a single page, folding names below the
+// delimiter into prefixes.
+func (s *Server) list(w http.ResponseWriter, bucket string, q url.Values) {
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	result := struct {
+		Kind     string           `json:"kind"`
+		Items    []objectResource `json:"items,omitempty"`
+		Prefixes []string         `json:"prefixes,omitempty"`
+	}{Kind: "storage#objects"}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[string]bool{}
+	for _, full := range s.keysLocked() {
+		b, name, _ := strings.Cut(full, "/")
+		rest, ok := strings.CutPrefix(name, prefix)
+		if b != bucket || !ok {
+			continue
+		}
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			if p := prefix + rest[:i+len(delimiter)]; !seen[p] {
+				seen[p] = true
+				result.Prefixes = append(result.Prefixes, p)
+			}
+			continue
+		}
+		result.Items = append(result.Items, resource(bucket, name, s.objects[full]))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// objectResource is the JSON API's object metadata, as far as clients here
+// read it.
+type objectResource struct {
+	Kind           string `json:"kind"`
+	Bucket         string `json:"bucket"`
+	Name           string `json:"name"`
+	Size           string `json:"size"`
+	Generation     string `json:"generation"`
+	Metageneration string `json:"metageneration"`
+	Updated        string `json:"updated"`
+	ContentType    string `json:"contentType"`
+}
+
+func resource(bucket, name string, obj object) objectResource {
+	return objectResource{
+		Kind:           "storage#object",
+		Bucket:         bucket,
+		Name:           name,
+		Size:           strconv.Itoa(len(obj.data)),
+		Generation:     strconv.FormatInt(obj.generation, 10),
+		Metageneration: "1",
+		Updated:        obj.modTime.UTC().Format(time.RFC3339Nano),
+		ContentType:    "application/octet-stream",
+	}
+}
+
+// objectName prefers the name query parameter over the one in the
+// metadata, as the service does.
+func objectName(r *http.Request, fromMetadata string) string {
+	if name := r.URL.Query().Get("name"); name != "" {
+		return name
+	}
+	return fromMetadata
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, reason, message string) {
+	type detail struct {
+		Domain  string `json:"domain"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	type body struct {
+		Code    int      `json:"code"`
+		Message string   `json:"message"`
+		Errors  []detail `json:"errors"`
+	}
+	writeJSON(w, status, struct {
+		Error body `json:"error"`
+	}{body{Code: status, Message: message, Errors: []detail{{Domain: "global", Reason: reason, Message: message}}}})
+}
+
+// Keys lists the stored object paths as "bucket/name", sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keysLocked()
+}
+
+func (s *Server) keysLocked() []string {
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
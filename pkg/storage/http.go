@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPOptions configure the http:// and https:// input backend.
+type HTTPOptions struct {
+	// Header is sent with every request, e.g. an Authorization token.
+	Header http.Header
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// Retries bounds the reconnects of one download, each resuming with a
+	// Range request where the previous connection dropped.
+	Retries int
+	// RetryDelay is the first pause between attempts, doubled on each
+	// further attempt; 0 means one second.
+	RetryDelay time.Duration
+}
+
+// HTTP reads inputs from web servers, e.g. presigned URLs. It cannot create
+// outputs.
+type HTTP struct {
+	opts HTTPOptions
+}
+
+func NewHTTP(opts HTTPOptions) *HTTP {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	return &HTTP{opts: opts}
+}
+
+// permanentError marks failures another attempt cannot fix.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Open starts the download. A dropped connection is resumed from the last
+// byte read, guarded by If-Range so that an object replaced meanwhile fails
+// the read instead of splicing two versions together.
+func (h *HTTP) Open(ctx context.Context, u *url.URL) (io.ReadCloser, Info, error) {
+	r := &httpReader{h: h, ctx: ctx, url: u.String(), name: redactURL(u), size: -1}
+	err := r.retry(r.start)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info := Info{Name: objectName(u.Path), Size: r.size}
+	info.ModTime, _ = http.ParseTime(r.lastModified)
+	return r, info, nil
+}
+
+func (h *HTTP) Create(_ context.Context, u *url.URL) (Writer, error) {
+	return nil, fmt.Errorf("%s: http outputs are not supported", redactURL(u))
+}
+
+type httpReader struct {
+	h    *HTTP
+	ctx  context.Context
+	url  string
+	name string // URL without its query, which may hold credentials
+
+	body         io.ReadCloser
+	offset, size int64
+	etag         string
+	lastModified string
+	attempts     int
+}
+
+func (r *httpReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == nil || (err == io.EOF && (r.size < 0 || r.offset >= r.size)) {
+		return n, err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	r.body.Close()
+	if rerr := r.retry(r.resume); rerr != nil {
+		return n, fmt.Errorf("%w (after %v)", rerr, err)
+	}
+	return n, nil
+}
+
+func (r *httpReader) Close() error {
+	return r.body.Close()
+}
+
+// retry runs fn until it succeeds, fails for good, or runs out of attempts.
+func (r *httpReader) retry(fn func() error) error {
+	delay := r.h.opts.RetryDelay
+	for {
+		err := fn()
+		if err == nil || errors.As(err, new(permanentError)) || r.attempts >= r.h.opts.Retries || r.ctx.Err() != nil {
+			return err
+		}
+		r.attempts++
+		select {
+		case <-r.ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (r *httpReader) start() error {
+	resp, err := r.get(nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return r.statusError(resp)
+	}
+	r.body, r.size = resp.Body, resp.ContentLength
+	r.etag, r.lastModified = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	return nil
+}
+
+func (r *httpReader) resume() error {
+	validator := r.etag
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = r.lastModified
+	}
+	if validator == "" {
+		return permanentError{fmt.Errorf("get %s: cannot resume at byte %d: the server sent neither ETag nor Last-Modified", r.name, r.offset)}
+	}
+	resp, err := r.get(http.Header{
+		"Range":    {fmt.Sprintf("bytes=%d-", r.offset)},
+		"If-Range": {validator},
+	})
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		resp.Body.Close()
+		return permanentError{fmt.Errorf("get %s: the object changed or ranges are not supported, cannot resume at byte %d", r.name, r.offset)}
+	default:
+		resp.Body.Close()
+		return r.statusError(resp)
+	}
+	if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != r.offset {
+		resp.Body.Close()
+		return permanentError{fmt.Errorf("get %s: resumed at %q instead of byte %d", r.name, resp.Header.Get("Content-Range"), r.offset)}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *httpReader) get(extra http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, permanentError{fmt.Errorf("get %s: %w", r.name, err)}
+	}
+	for k, vs := range r.h.opts.Header {
+		req.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	for k, vs := range extra {
+		req.Header[k] = vs
+	}
+	// Without this the transport asks for gzip and unzips behind our back:
+	// offsets, sizes and checksums would then be of the unzipped stream
+	// while Range counts bytes as served.
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := r.h.opts.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", r.name, errors.Unwrap(err))
+	}
+	return resp, nil
+}
+
+// statusError reports an unexpected status; only server errors and
+// throttling are worth another attempt.
+func (r *httpReader) statusError(resp *http.Response) error {
+	err := fmt.Errorf("get %s: %s", r.name, resp.Status)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return permanentError{err}
+}
+
+// contentRangeStart parses the first byte of "bytes start-end/size".
+func contentRangeStart(v string) (int64, bool) {
+	rest, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+func redactURL(u *url.URL) string {
+	clean := *u
+	clean.User, clean.RawQuery, clean.Fragment = nil, "", ""
+	return clean.String()
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer serves data, cutting the first response off after cut bytes.
+// With gzip set it compresses responses for clients that accept it, ignoring
+// Range, as many web servers do.
+type flakyServer struct {
+	data []byte
+	cut  int
+	etag string
+	gzip bool
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	first := len(s.requests) == 1
+	etag := s.etag
+	s.mu.Unlock()
+
+	body, encoding := s.data, ""
+	if s.gzip && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		var zipped bytes.Buffer
+		zw := gzip.NewWriter(&zipped)
+		zw.Write(s.data)
+		zw.Close()
+		body, encoding = zipped.Bytes(), "Content-Encoding: gzip\r\n"
+	}
+	if first || encoding != "" {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nETag: %s\r\n%s\r\n", len(body), etag, encoding)
+		if first {
+			body = body[:min(s.cut, len(body)-1)]
+		}
+		buf.Write(body)
+		buf.Flush()
+		conn.Close()
+		return
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "dump.sql.gz", time.Time{}, bytes.NewReader(s.data))
+}
+
+func openFlaky(t *testing.T, s *flakyServer) (io.ReadCloser, Info, error) {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	backends := Backends{"http": NewHTTP(HTTPOptions{
+		Header:     http.Header{"Authorization": {"Bearer t0ken"}},
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	})}
+	return backends.Open(context.Background(), srv.URL+"/backups/dump.sql.gz?X-Amz-Signature=secret")
+}
+
+func TestHTTPResumesDroppedDownload(t *testing.T) {
+	s := &flakyServer{data: bytes.Repeat([]byte("0123456789"), 10000), cut: 30000, etag: `"v1"`}
+	r, info, err := openFlaky(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(got, s.data) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(s.data))
+	}
+	if info.Name != "dump.sql.gz" || info.Size != int64(len(s.data)) {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	if len(s.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(s.requests))
+	}
+	resumed := s.requests[1]
+	if resumed.Header.Get("Range") != "bytes=30000-" || resumed.Header.Get("If-Range") != `"v1"` {
+		t.Fatalf("unexpected resume headers: %v", resumed.Header)
+	}
+	for _, req := range s.requests {
+		if req.Header.Get("Authorization") != "Bearer t0ken" {
+			t.Fatalf("custom header missing: %v", req.Header)
+		}
+	}
+}
+
+func TestHTTPResumesWithoutContentEncoding(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	s := &flakyServer{data: data, cut: 40000, etag: `"v1"`, gzip: true}
+	r, info, err := openFlaky(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !bytes.Equal(got, s.data) || info.Size != int64(len(s.data)) {
+		t.Fatalf("got %d bytes of %d, info %+v", len(got), len(s.data), info)
+	}
+	for _, req := range s.requests {
+		if req.Header.Get("Accept-Encoding") != "identity" {
+			t.Fatalf("request accepts %q", req.Header.Get("Accept-Encoding"))
+		}
+	}
+}
+
+func TestHTTPRefusesToResumeChangedObject(t *testing.T) {
+	s := &flakyServer{data: bytes.Repeat([]byte("x"), 50000), cut: 1000, etag: `"v1"`}
+	r, _, err := openFlaky(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s.mu.Lock()
+	s.etag = `"v2"`
+	s.mu.Unlock()
+
+	_, err = io.ReadAll(r)
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Fatalf("expected a changed object to fail, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error leaks the query string: %v", err)
+	}
+}
+
+func TestHTTPReportsMissingObject(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	_, _, err := Default().Open(context.Background(), srv.URL+"/missing.sql")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("INSERT INTO t VALUES (1);\n")
+	spec := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	r, err := VerifyChecksum(bytes.NewReader(data), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("matching checksum failed: %v", err)
+	}
+
+	r, _ = VerifyChecksum(bytes.NewReader(append(data, '-')), spec)
+	if _, err := io.ReadAll(r); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+
+	for _, bad := range []string{"sha256", "crc32:00", "sha256:abc", "md5:" + strings.Repeat("zz", 16)} {
+		if _, _, err := ParseChecksum(bad); err == nil {
+			t.Errorf("ParseChecksum(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Options configure the s3:// backend. Empty fields fall back to the AWS
+// SDK defaults: AWS_* environment variables, shared config files and
+// instance roles.
+type S3Options struct {
+	// Endpoint is the base URL of an S3-compatible service such as MinIO.
+	Endpoint string
+	Region   string
+	// PathStyle addresses objects as endpoint/bucket/key instead of
+	// bucket.endpoint/key, as most self-hosted services expect.
+	PathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PartSize is the size of multipart upload parts, 0 for the SDK
+	// default. Parts are buffered in memory.
+	PartSize int64
+}
+
+// S3 is the s3://bucket/key backend. Uploads stream through multipart
+// uploads, so outputs of any size never touch the local disk.
+type S3 struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+}
+
+func NewS3(ctx context.Context, opts S3Options) (*S3, error) {
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(opts.Region))
+	}
+	if opts.AccessKeyID != "" || opts.SecretAccessKey != "" {
+		loadOpts = append(loadOpts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken)))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		// S3-compatible services rarely care, but request signing needs one
+		cfg.Region = "us-east-1"
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = opts.PathStyle
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+			// third-party services often lag behind the checksums AWS
+			// added by default
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+	})
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		if opts.PartSize > 0 {
+			u.PartSize = opts.PartSize
+		}
+	})
+	return &S3{client: client, uploader: uploader}, nil
+}
+
+func (s *S3) Open(ctx context.Context, u *url.URL) (io.ReadCloser, Info, error) {
+	bucket, key, err := bucketLocation(u)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("get s3://%s/%s: %w", bucket, key, err)
+	}
+	info := Info{Name: objectName(key), Size: -1, ModTime: aws.ToTime(out.LastModified)}
+	if out.ContentLength != nil {
+		info.Size = *out.ContentLength
+	}
+	return out.Body, info, nil
+}
+
+func (s *S3) Stat(ctx context.Context, u *url.URL) (Info, error) {
+	bucket, key, err := bucketLocation(u)
+	if err != nil {
+		return Info{}, err
+	}
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return Info{}, fmt.Errorf("s3://%s/%s: %w", bucket, key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("head s3://%s/%s: %w", bucket, key, err)
+	}
+	return Info{Name: objectName(key), Size: aws.ToInt64(out.ContentLength), ModTime: aws.ToTime(out.LastModified)}, nil
+}
+
+func (s *S3) Remove(ctx context.Context, u *url.URL) error {
+	bucket, key, err := bucketLocation(u)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+		return fmt.Errorf("delete s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+func (s *S3) List(ctx context.Context, u *url.URL) ([]Object, error) {
+	bucket, prefix, err := bucketPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if key == prefix {
+				continue // a directory marker
+			}
+			info := Info{Name: objectName(key), Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)}
+			objects = append(objects, childObject(u, key, info))
+		}
+	}
+	return objects, nil
+}
+
+// Create starts an upload that is fed by the returned writer. The object
+// appears only once Close completes it; Abort, or any failed part, aborts
+// the multipart upload.
+func (s *S3) Create(ctx context.Context, u *url.URL) (Writer, error) {
+	return s.create(ctx, u, false)
+}
+
+// CreateExclusive is Create with If-None-Match: *, so that S3 refuses to
+// complete the upload if the object exists by then.
+func (s *S3) CreateExclusive(ctx context.Context, u *url.URL) (Writer, error) {
+	return s.create(ctx, u, true)
+}
+
+func (s *S3) create(ctx context.Context, u *url.URL, exclusive bool) (Writer, error) {
+	bucket, key, err := bucketLocation(u)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	w := &pipeWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		in := &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		}
+		if exclusive {
+			in.IfNoneMatch = aws.String("*")
+		}
+		_, err := s.uploader.Upload(ctx, in)
+		var resp *awshttp.ResponseError
+		if errors.As(err, &resp) && resp.HTTPStatusCode() == http.StatusPreconditionFailed {
+			err = fmt.Errorf("%w: %w", fs.ErrExist, err)
+		}
+		if err != nil {
+			err = fmt.Errorf("upload s3://%s/%s: %w", bucket, key, err)
+		}
+		// unblock a writer the uploader stopped reading from
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
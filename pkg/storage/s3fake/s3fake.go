@@ -0,0 +1,293 @@
+// Package s3fake is an in-process S3 server for tests. It speaks just
+// enough of the path-style REST API for single and multipart uploads,
+// ranged downloads and ListObjectsV2, keeps objects in memory and ignores signatures.
+package s3fake
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]object
+	uploads map[string]*upload
+	nextID  int
+}
+
+type object struct {
+	data    []byte
+	modTime time.Time
+}
+
+type upload struct {
+	key   string
+	parts map[int][]byte
+}
+
+// New starts a server; callers Close it.
+func New() *Server {
+	s := &Server{objects: map[string]object{}, uploads: map[string]*upload{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Put stores an object as if it had been uploaded at modTime.
+func (s *Server) Put(bucket, key string, data []byte, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = object{data: bytes.Clone(data), modTime: modTime}
+}
+
+// Object returns a stored object.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[bucket+"/"+key]
+	return obj.data, ok
+}
+
+// PendingUploads counts multipart uploads neither completed nor aborted.
+func (s *Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if bucket := strings.TrimSuffix(path, "/"); !strings.Contains(bucket, "/") && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		s.list(w, bucket, r.URL.Query())
+		return
+	}
+	if !strings.Contains(path, "/") {
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "bucket operations are not supported")
+		return
+	}
+	q := r.URL.Query()
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &upload{key: path, parts: map[int][]byte{}}
+		bucket, key, _ := strings.Cut(path, "/")
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		up := s.uploads[q.Get("uploadId")]
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if up == nil || err != nil {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload or part")
+			return
+		}
+		up.parts[n] = body
+		w.Header().Set("ETag", etag(n))
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		id := q.Get("uploadId")
+		up := s.uploads[id]
+		var req struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		if up == nil || xml.Unmarshal(body, &req) != nil {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload")
+			return
+		}
+		var data []byte
+		for _, p := range req.Parts {
+			part, ok := up.parts[p.PartNumber]
+			if !ok {
+				writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", p.PartNumber))
+				return
+			}
+			data = append(data, part...)
+		}
+		if s.refuseExisting(w, r, up.key) {
+			return
+		}
+		delete(s.uploads, id)
+		s.objects[up.key] = object{data: data, modTime: time.Now()}
+		bucket, key, _ := strings.Cut(up.key, "/")
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: etag(len(req.Parts))})
+
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		if s.refuseExisting(w, r, path) {
+			return
+		}
+		s.objects[path] = object{data: body, modTime: time.Now()}
+		w.Header().Set("ETag", etag(0))
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := s.objects[path]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+			return
+		}
+		w.Header().Set("ETag", etag(len(obj.data)))
+		http.ServeContent(w, r, path, obj.modTime, bytes.NewReader(obj.data))
+
+	case r.Method == http.MethodDelete:
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" is not supported")
+	}
+}
+
+// refuseExisting answers a write conditional on If-None-Match: * with 412
+// when the object exists.
+func (s *Server) refuseExisting(w http.ResponseWriter, r *http.Request, path string) bool {
+	if _, ok := s.objects[path]; !ok || r.Header.Get("If-None-Match") != "*" {
+		return false
+	}
+	writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "at least one of the pre-conditions you specified did not hold")
+	return true
+}
+
+// list answers ListObjectsV2 in a single page, folding keys below the
+// delimiter into common prefixes.
+func (s *Server) list(w http.ResponseWriter, bucket string, q url.Values) {
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+		ETag         string
+	}
+	type commonPrefix struct{ Prefix string }
+	result := struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		Delimiter      string `xml:",omitempty"`
+		KeyCount       int
+		IsTruncated    bool
+		Contents       []content
+		CommonPrefixes []commonPrefix
+	}{Name: bucket, Prefix: q.Get("prefix"), Delimiter: q.Get("delimiter")}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[string]bool{}
+	for _, full := range s.keysLocked() {
+		b, key, _ := strings.Cut(full, "/")
+		rest, ok := strings.CutPrefix(key, result.Prefix)
+		if b != bucket || !ok {
+			continue
+		}
+		if i := strings.Index(rest, result.Delimiter); result.Delimiter != "" && i >= 0 {
+			prefix := result.Prefix + rest[:i+len(result.Delimiter)]
+			if !seen[prefix] {
+				seen[prefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{prefix})
+			}
+			continue
+		}
+		obj := s.objects[full]
+		result.Contents = append(result.Contents, content{
+			Key:          key,
+			Size:         len(obj.data),
+			LastModified: obj.modTime.UTC().Format(time.RFC3339Nano),
+			ETag:         etag(len(obj.data)),
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	writeXML(w, result)
+}
+
+// readBody returns the request payload, decoding the aws-chunked framing
+// SDKs use for streamed bodies.
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return body, err
+	}
+	var data []byte
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, fmt.Errorf("truncated aws-chunked body")
+		}
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil || int64(len(rest)) < size {
+			return nil, fmt.Errorf("bad aws-chunked chunk %q", header)
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+}
+
+func etag(n int) string {
+	return fmt.Sprintf("\"%032x\"", n)
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
+
+// Keys lists the stored object paths as "bucket/key", sorted.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keysLocked()
+}
+
+func (s *Server) keysLocked() []string {
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
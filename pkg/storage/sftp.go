@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTPOptions configure the sftp://user@host[:port]/path backend. Host keys
+// are always verified against KnownHostsFile.
+type SFTPOptions struct {
+	// User is used when the URL names none; it defaults to $USER.
+	User string
+	// KeyFile is a private key in OpenSSH or PEM format, decrypted with
+	// KeyPassphrase if it is protected.
+	KeyFile       string
+	KeyPassphrase string
+	// Password is tried after the key, for hosts without key auth.
+	Password string
+	// KnownHostsFile defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string
+	// Timeout bounds connecting and the SSH handshake; 0 means 30s.
+	Timeout time.Duration
+}
+
+// SFTP reads and writes files over SSH. Every Open and Create uses its own
+// connection, closed with the returned reader or writer.
+type SFTP struct {
+	opts SFTPOptions
+}
+
+func NewSFTP(opts SFTPOptions) *SFTP {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return &SFTP{opts: opts}
+}
+
+func (s *SFTP) Open(ctx context.Context, u *url.URL) (io.ReadCloser, Info, error) {
+	conn, err := s.dial(ctx, u)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := conn.client.Open(u.Path)
+	if err != nil {
+		conn.Close()
+		return nil, Info{}, fmt.Errorf("open %s: %w", redactURL(u), err)
+	}
+	info := Info{Name: path.Base(u.Path), Size: -1}
+	if fi, err := f.Stat(); err == nil {
+		info.Size, info.ModTime = fi.Size(), fi.ModTime()
+	}
+	return &sftpReader{File: f, conn: conn}, info, nil
+}
+
+func (s *SFTP) Stat(ctx context.Context, u *url.URL) (Info, error) {
+	conn, err := s.dial(ctx, u)
+	if err != nil {
+		return Info{}, err
+	}
+	defer conn.Close()
+	fi, err := conn.client.Stat(u.Path)
+	if err != nil {
+		return Info{}, fmt.Errorf("stat %s: %w", redactURL(u), err)
+	}
+	return Info{Name: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *SFTP) Remove(ctx context.Context, u *url.URL) error {
+	conn, err := s.dial(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.client.Remove(u.Path); err != nil {
+		return fmt.Errorf("remove %s: %w", redactURL(u), err)
+	}
+	return nil
+}
+
+// List lists the regular files in the remote directory u names.
+func (s *SFTP) List(ctx context.Context, u *url.URL) ([]Object, error) {
+	conn, err := s.dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	entries, err := conn.client.ReadDir(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", redactURL(u), err)
+	}
+	var objects []Object
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		child := *u
+		child.Path = path.Join(u.Path, fi.Name())
+		child.RawPath = ""
+		objects = append(objects, Object{
+			Location: child.String(),
+			Info:     Info{Name: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime()},
+		})
+	}
+	return objects, nil
+}
+
+// Create writes to a hidden temporary file next to u, creating its parent
+// directories, and Close renames it into place. Abort removes it again.
+func (s *SFTP) Create(ctx context.Context, u *url.URL) (Writer, error) {
+	return s.create(ctx, u, false)
+}
+
+// CreateExclusive is Create, but Close hard-links the file into place with
+// the hardlink extension, or else uses the plain SFTP rename; both fail if
+// a file exists at u.
+func (s *SFTP) CreateExclusive(ctx context.Context, u *url.URL) (Writer, error) {
+	return s.create(ctx, u, true)
+}
+
+func (s *SFTP) create(ctx context.Context, u *url.URL, exclusive bool) (Writer, error) {
+	conn, err := s.dial(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.client.MkdirAll(path.Dir(u.Path)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("create %s: %w", redactURL(u), err)
+	}
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	tmp := path.Join(path.Dir(u.Path), "."+path.Base(u.Path)+".partial-"+hex.EncodeToString(suffix[:]))
+	f, err := conn.client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("create %s: %w", redactURL(u), err)
+	}
+	return &sftpWriter{File: f, conn: conn, path: u.Path, exclusive: exclusive}, nil
+}
+
+type sftpConn struct {
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+func (c *sftpConn) Close() error {
+	return errors.Join(c.client.Close(), c.ssh.Close())
+}
+
+func (s *SFTP) dial(ctx context.Context, u *url.URL) (*sftpConn, error) {
+	config, err := s.clientConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	dialer := net.Dialer{Timeout: s.opts.Timeout}
+	tcp, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect %s: %w", addr, err)
+	}
+	_ = tcp.SetDeadline(time.Now().Add(s.opts.Timeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(tcp, addr, config)
+	if err != nil {
+		tcp.Close()
+		return nil, fmt.Errorf("ssh %s: %w", addr, err)
+	}
+	_ = tcp.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	sc, err := sftp.NewClient(client, sftp.UseConcurrentReads(true), sftp.UseConcurrentWrites(true))
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("sftp %s: %w", addr, err)
+	}
+	return &sftpConn{ssh: client, client: sc}, nil
+}
+
+func (s *SFTP) clientConfig(u *url.URL) (*ssh.ClientConfig, error) {
+	user := u.User.Username()
+	if user == "" {
+		user = s.opts.User
+	}
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	var auth []ssh.AuthMethod
+	if s.opts.KeyFile != "" {
+		signer, err := loadSigner(s.opts.KeyFile, s.opts.KeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	password := s.opts.Password
+	if p, ok := u.User.Password(); ok {
+		password = p
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp needs a key file or a password")
+	}
+
+	knownHosts := s.opts.KnownHostsFile
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("locate known_hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         s.opts.Timeout,
+	}, nil
+}
+
+func loadSigner(keyFile, passphrase string) (ssh.Signer, error) {
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read sftp key: %w", err)
+	}
+	var signer ssh.Signer
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pem)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse sftp key %s: %w", keyFile, err)
+	}
+	return signer, nil
+}
+
+type sftpReader struct {
+	*sftp.File
+	conn *sftpConn
+}
+
+func (r *sftpReader) Close() error {
+	return errors.Join(r.File.Close(), r.conn.Close())
+}
+
+type sftpWriter struct {
+	*sftp.File
+	conn      *sftpConn
+	path      string
+	exclusive bool
+}
+
+// Close syncs the temporary file where the server supports it and renames it
+// to the final name. An exclusive writer links it or uses the plain rename,
+// which never replace a file; otherwise an existing file is replaced
+// atomically with the posix-rename extension, or else removed first.
+func (w *sftpWriter) Close() error {
+	err := w.Sync()
+	var status *sftp.StatusError
+	if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		err = nil
+	}
+	if cerr := w.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = w.rename()
+	}
+	if err != nil {
+		_ = w.conn.client.Remove(w.Name())
+	}
+	return errors.Join(err, w.conn.Close())
+}
+
+func (w *sftpWriter) rename() error {
+	if w.exclusive {
+		var err error
+		if _, ok := w.conn.client.HasExtension("hardlink@openssh.com"); ok {
+			if err = w.conn.client.Link(w.Name(), w.path); err == nil {
+				_ = w.conn.client.Remove(w.Name())
+			}
+		} else {
+			err = w.conn.client.Rename(w.Name(), w.path)
+		}
+		if err != nil {
+			// servers report the conflict as a generic failure
+			if _, serr := w.conn.client.Stat(w.path); serr == nil {
+				return fmt.Errorf("rename to %s: %w", w.path, fs.ErrExist)
+			}
+		}
+		return err
+	}
+	if _, ok := w.conn.client.HasExtension("posix-rename@openssh.com"); ok {
+		return w.conn.client.PosixRename(w.Name(), w.path)
+	}
+	if err := w.conn.client.Remove(w.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return w.conn.client.Rename(w.Name(), w.path)
+}
+
+func (w *sftpWriter) Abort() error {
+	_ = w.File.Close()
+	err := w.conn.client.Remove(w.Name())
+	return errors.Join(err, w.conn.Close())
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpServer accepts one user key and serves the local file system over
+// the sftp subsystem.
+type sftpServer struct {
+	addr       string
+	keyFile    string
+	knownHosts string
+}
+
+func newSFTPServer(t *testing.T) *sftpServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPub, userPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := ssh.NewPublicKey(userPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(userPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sftpServer{keyFile: filepath.Join(dir, "id_ed25519"), knownHosts: filepath.Join(dir, "known_hosts")}
+	if err := os.WriteFile(s.keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), allowed.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.addr = ln.Addr().String()
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(s.knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+	return s
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if server, err := sftp.NewServer(ch); err == nil {
+						_ = server.Serve()
+						server.Close()
+					}
+				}
+			}
+		}()
+	}
+}
+
+func (s *sftpServer) backends(opts SFTPOptions) Backends {
+	if opts.KeyFile == "" {
+		opts.KeyFile = s.keyFile
+	}
+	if opts.KnownHostsFile == "" {
+		opts.KnownHostsFile = s.knownHosts
+	}
+	return Backends{"sftp": NewSFTP(opts)}
+}
+
+func TestSFTPRoundTrip(t *testing.T) {
+	srv := newSFTPServer(t)
+	backends := srv.backends(SFTPOptions{})
+	ctx := context.Background()
+	remote := filepath.Join(t.TempDir(), "backups", "db.sql.gz")
+	location := "sftp://backup@" + srv.addr + remote
+
+	data := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 50000)
+	w, err := backends.Create(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(remote); !os.IsNotExist(err) {
+		t.Fatalf("upload visible before Close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, info, err := backends.Open(ctx, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || info.Name != "db.sql.gz" || info.Size != int64(len(data)) {
+		t.Fatalf("read back %d bytes with info %+v, want %d", len(got), info, len(data))
+	}
+
+	objects, err := backends.List(ctx, "sftp://backup@"+srv.addr+filepath.Dir(remote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Location != location || objects[0].Size != int64(len(data)) {
+		t.Fatalf("listing %+v, want only %s", objects, location)
+	}
+	if ok, err := backends.Exists(ctx, location+".missing"); ok || err != nil {
+		t.Fatalf("Exists(missing) = %v, %v", ok, err)
+	}
+
+	w, err = backends.Create(ctx, location+".partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data[:100])
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(remote)); len(entries) != 1 {
+		t.Fatalf("aborted upload left a file: %d entries", len(entries))
+	}
+
+	if err := backends.Remove(ctx, location); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(remote); !os.IsNotExist(err) {
+		t.Fatalf("removed file still exists: %v", err)
+	}
+}
+
+func TestSFTPCreateExclusive(t *testing.T) {
+	srv := newSFTPServer(t)
+	remote := filepath.Join(t.TempDir(), "db.sql")
+	exclusiveCreate(t, srv.backends(SFTPOptions{}), "sftp://backup@"+srv.addr+remote, func() {
+		if err := os.WriteFile(remote, []byte("other run"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if got, _ := os.ReadFile(remote); string(got) != "other run" {
+		t.Fatalf("existing file replaced with %q", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(remote)); len(entries) != 1 {
+		t.Fatalf("expected only the existing file, found %d entries", len(entries))
+	}
+}
+
+func TestSFTPRejectsUnknownHostKey(t *testing.T) {
+	srv := newSFTPServer(t)
+	other := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	backends := srv.backends(SFTPOptions{KnownHostsFile: other})
+	_, _, err := backends.Open(context.Background(), "sftp://backup@"+srv.addr+"/etc/hostname")
+	if err == nil || !strings.Contains(err.Error(), "key is unknown") {
+		t.Fatalf("expected an unknown host key error, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+)
+
+// StdioPath names standard input as an input and standard output as an
+// output.
+const StdioPath = "-"
+
+// Stdio is the backend behind StdioPath. Neither stream can seek, so
+// readers must detect formats from what they have buffered.
+type Stdio struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+func (s Stdio) Open(_ context.Context, _ *url.URL) (io.ReadCloser, Info, error) {
+	return io.NopCloser(s.In), Info{Name: "stdin", Size: -1}, nil
+}
+
+// Stat reports nothing: stdin has no metadata, and stdout holds no object an
+// output could clobber.
+func (Stdio) Stat(_ context.Context, _ *url.URL) (Info, error) {
+	return Info{}, fmt.Errorf("stdio: %w", fs.ErrNotExist)
+}
+
+// Create writes straight through to Out. What was written before Abort has
+// already left the process, so consumers must check the exit status.
+func (s Stdio) Create(_ context.Context, _ *url.URL) (Writer, error) {
+	return stdoutWriter{s.Out}, nil
+}
+
+type stdoutWriter struct {
+	io.Writer
+}
+
+func (stdoutWriter) Close() error { return nil }
+func (stdoutWriter) Abort() error { return nil }
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Info describes an opened object. Size is -1 when unknown.
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Writer receives the content of an object being created. Close publishes
+// it; Abort discards everything written so far.
+type Writer interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// Backend opens and creates the objects of one URL scheme.
+type Backend interface {
+	Open(ctx context.Context, u *url.URL) (io.ReadCloser, Info, error)
+	Create(ctx context.Context, u *url.URL) (Writer, error)
+}
+
+// Object is one listed object, addressed by a location Backends.Open
+// accepts.
+type Object struct {
+	Location string
+	Info
+}
+
+// Lister is implemented by backends that can enumerate the objects directly
+// inside a directory or under a "dir/" key prefix. Subdirectories are not
+// descended into.
+type Lister interface {
+	List(ctx context.Context, u *url.URL) ([]Object, error)
+}
+
+// Stater is implemented by backends that can look an object up without
+// opening it. A missing object is reported with an error matching
+// fs.ErrNotExist.
+type Stater interface {
+	Stat(ctx context.Context, u *url.URL) (Info, error)
+}
+
+// ExclusiveCreator is implemented by backends that can create an object
+// without replacing one. Close fails with an error matching fs.ErrExist,
+// and publishes nothing, when an object exists at the location by then.
+type ExclusiveCreator interface {
+	CreateExclusive(ctx context.Context, u *url.URL) (Writer, error)
+}
+
+// Remover is implemented by backends that can delete objects.
+type Remover interface {
+	Remove(ctx context.Context, u *url.URL) error
+}
+
+// Backends maps URL schemes to backends. Plain paths use the "file" scheme.
+type Backends map[string]Backend
+
+// Default returns the backends that need no configuration.
+func Default() Backends {
+	web := NewHTTP(HTTPOptions{})
+	return Backends{"file": File{}, "stdio": Stdio{In: os.Stdin, Out: os.Stdout}, "http": web, "https": web}
+}
+
+// Schemes lists the registered URL schemes.
+func (b Backends) Schemes() []string {
+	schemes := make([]string, 0, len(b))
+	for s := range b {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open opens the object at location.
+func (b Backends) Open(ctx context.Context, location string) (io.ReadCloser, Info, error) {
+	u, backend, err := b.resolve(location)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return backend.Open(ctx, u)
+}
+
+// Create starts writing the object at location.
+func (b Backends) Create(ctx context.Context, location string) (Writer, error) {
+	u, backend, err := b.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Create(ctx, u)
+}
+
+// CreateExclusive starts writing the object at location like Create, but
+// never replaces an existing object where the backend supports it.
+func (b Backends) CreateExclusive(ctx context.Context, location string) (Writer, error) {
+	u, backend, err := b.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	if creator, ok := backend.(ExclusiveCreator); ok {
+		return creator.CreateExclusive(ctx, u)
+	}
+	return backend.Create(ctx, u)
+}
+
+// List lists the objects inside the directory or prefix at location, sorted
+// by location.
+func (b Backends) List(ctx context.Context, location string) ([]Object, error) {
+	u, backend, err := b.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := backend.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("%s locations cannot be listed", u.Scheme)
+	}
+	objects, err := lister.List(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Location < objects[j].Location })
+	return objects, nil
+}
+
+// Stat looks up the object at location.
+func (b Backends) Stat(ctx context.Context, location string) (Info, error) {
+	u, backend, err := b.resolve(location)
+	if err != nil {
+		return Info{}, err
+	}
+	stater, ok := backend.(Stater)
+	if !ok {
+		return Info{}, fmt.Errorf("%s locations cannot be looked up", u.Scheme)
+	}
+	return stater.Stat(ctx, u)
+}
+
+// Exists reports whether an object exists at location.
+func (b Backends) Exists(ctx context.Context, location string) (bool, error) {
+	_, err := b.Stat(ctx, location)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Remove deletes the object at location.
+func (b Backends) Remove(ctx context.Context, location string) error {
+	u, backend, err := b.resolve(location)
+	if err != nil {
+		return err
+	}
+	remover, ok := backend.(Remover)
+	if !ok {
+		return fmt.Errorf("%s locations cannot be removed", u.Scheme)
+	}
+	return remover.Remove(ctx, u)
+}
+
+func (b Backends) resolve(location string) (*url.URL, Backend, error) {
+	u, err := Parse(location)
+	if err != nil {
+		return nil, nil, err
+	}
+	backend, ok := b[u.Scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported storage scheme %q (known: %s)", u.Scheme, strings.Join(b.Schemes(), ", "))
+	}
+	return u, backend, nil
+}
+
+// Parse turns location into a URL. StdioPath maps to the "stdio" scheme;
+// anything else without a "scheme://" prefix is a local path, relative
+// paths included.
+func Parse(location string) (*url.URL, error) {
+	if location == StdioPath {
+		return &url.URL{Scheme: "stdio"}, nil
+	}
+	scheme, _, ok := strings.Cut(location, "://")
+	if !ok || scheme == "" || strings.ContainsAny(scheme, `/\`) {
+		return &url.URL{Scheme: "file", Path: location}, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parse location %q: %w", location, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	return u, nil
+}
+
+// LocalPath returns the file system path of a plain path or file:// URL.
+func LocalPath(location string) (string, bool) {
+	u, err := Parse(location)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// objectName returns the last element of an object key or path.
+func objectName(key string) string {
+	return path.Base("/" + key)
+}
+
+// bucketLocation splits the scheme://bucket/key URLs of object stores.
+func bucketLocation(u *url.URL) (bucket, key string, err error) {
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("%s location %q must be %s://bucket/key", u.Scheme, u.Redacted(), u.Scheme)
+	}
+	return u.Host, key, nil
+}
+
+// bucketPrefix returns the bucket and the "dir/" key prefix listed for a
+// scheme://bucket[/dir] URL.
+func bucketPrefix(u *url.URL) (bucket, prefix string, err error) {
+	if u.Host == "" {
+		return "", "", fmt.Errorf("%s location %q must be %s://bucket/prefix", u.Scheme, u.Redacted(), u.Scheme)
+	}
+	prefix = strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return u.Host, prefix, nil
+}
+
+// childObject returns the object named key in the bucket of u.
+func childObject(u *url.URL, key string, info Info) Object {
+	child := *u
+	child.Path = "/" + key
+	child.RawPath = ""
+	return Object{Location: child.String(), Info: info}
+}
+
+var errUploadAborted = errors.New("upload aborted")
+
+// pipeWriter feeds an upload running in another goroutine, which reports
+// its result on done. Abort fails the upload's reads, so it never commits.
+type pipeWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *pipeWriter) Close() error {
+	_ = w.pw.Close()
+	return <-w.done
+}
+
+func (w *pipeWriter) Abort() error {
+	_ = w.pw.CloseWithError(errUploadAborted)
+	<-w.done // fails with errUploadAborted once the upload is cleaned up
+	return nil
+}
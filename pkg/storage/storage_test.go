@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d00p1/filtrate-backups/pkg/storage/s3fake"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct{ in, scheme, host, path string }{
+		{"./dump.sql", "file", "", "./dump.sql"},
+		{"/data/dump.sql", "file", "", "/data/dump.sql"},
+		{"file:///data/x.sql", "file", "", "/data/x.sql"},
+		{"s3://bucket/a/b.sql", "s3", "bucket", "/a/b.sql"},
+		{"S3://bucket/key", "s3", "bucket", "/key"},
+	}
+	for _, tc := range cases {
+		u, err := Parse(tc.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tc.in, err)
+		}
+		if u.Scheme != tc.scheme || u.Host != tc.host || u.Path != tc.path {
+			t.Errorf("Parse(%q) = %s %s %s", tc.in, u.Scheme, u.Host, u.Path)
+		}
+	}
+	if _, ok := LocalPath("s3://bucket/key"); ok {
+		t.Fatal("s3 URL reported as local")
+	}
+}
+
+func TestFileAbortRemovesOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out", "db.sql")
+	w, err := Default().Create(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("aborted output still exists: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 0 {
+		t.Fatalf("aborted output left %s behind", entries[0].Name())
+	}
+}
+
+func TestFileCreatePublishesOnClose(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.sql")
+	if err := os.WriteFile(path, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Default().Create(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("filtered")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "previous" {
+		t.Fatalf("output replaced before Close: %q", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "filtered" {
+		t.Fatalf("published output is %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected only the output, found %d entries", len(entries))
+	}
+}
+
+// exclusiveCreate creates location exclusively and has another object
+// appear there before Close, which must then fail.
+func exclusiveCreate(t *testing.T, backends Backends, location string, appear func()) {
+	t.Helper()
+	w, err := backends.CreateExclusive(context.Background(), location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("filtered")); err != nil {
+		t.Fatal(err)
+	}
+	appear()
+	if err := w.Close(); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Close over an object that appeared = %v, want fs.ErrExist", err)
+	}
+}
+
+func TestFileCreateExclusive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.sql")
+	exclusiveCreate(t, Default(), path, func() {
+		if err := os.WriteFile(path, []byte("other run"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if got, _ := os.ReadFile(path); string(got) != "other run" {
+		t.Fatalf("existing file replaced with %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("expected only the existing file, found %d entries", len(entries))
+	}
+
+	w, err := Default().CreateExclusive(context.Background(), filepath.Join(dir, "new.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("filtered"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "new.sql")); string(got) != "filtered" {
+		t.Fatalf("published %q", got)
+	}
+}
+
+func newFakeS3(t *testing.T) (*s3fake.Server, Backends) {
+	t.Helper()
+	fake := s3fake.New()
+	t.Cleanup(fake.Close)
+	backend, err := NewS3(context.Background(), S3Options{
+		Endpoint:        fake.URL,
+		Region:          "us-east-1",
+		PathStyle:       true,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		PartSize:        5 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, Backends{"s3": backend}
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	fake, backends := newFakeS3(t)
+	ctx := context.Background()
+
+	// large enough for three parts
+	data := bytes.Repeat([]byte("0123456789abcdef"), 11<<20/16)
+	w, err := backends.Create(ctx, "s3://backups/daily/db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fake.Object("backups", "daily/db.sql"); !bytes.Equal(got, data) {
+		t.Fatalf("stored %d bytes, want %d", len(got), len(data))
+	}
+
+	mtime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fake.Put("backups", "in/dump.sql.gz", []byte("payload"), mtime)
+	r, info, err := backends.Open(ctx, "s3://backups/in/dump.sql.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "payload" || info.Name != "dump.sql.gz" || info.Size != 7 || !info.ModTime.Equal(mtime) {
+		t.Fatalf("unexpected object %q with info %+v", got, info)
+	}
+
+	if _, _, err := backends.Open(ctx, "s3://backups/missing.sql"); err == nil {
+		t.Fatal("expected missing object to fail")
+	}
+	if ok, err := backends.Exists(ctx, "s3://backups/in/dump.sql.gz"); !ok || err != nil {
+		t.Fatalf("Exists(existing) = %v, %v", ok, err)
+	}
+	if ok, err := backends.Exists(ctx, "s3://backups/missing.sql"); ok || err != nil {
+		t.Fatalf("Exists(missing) = %v, %v", ok, err)
+	}
+	if err := backends.Remove(ctx, "s3://backups/in/dump.sql.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.Object("backups", "in/dump.sql.gz"); ok {
+		t.Fatal("removed object still exists")
+	}
+}
+
+func TestS3CreateExclusive(t *testing.T) {
+	fake, backends := newFakeS3(t)
+	exclusiveCreate(t, backends, "s3://backups/out/db.sql", func() {
+		fake.Put("backups", "out/db.sql", []byte("other run"), time.Now())
+	})
+	if got, _ := fake.Object("backups", "out/db.sql"); string(got) != "other run" {
+		t.Fatalf("existing object replaced with %q", got)
+	}
+	if fake.PendingUploads() != 0 {
+		t.Fatal("refused upload left a pending multipart upload")
+	}
+}
+
+func TestS3AbortLeavesNoObject(t *testing.T) {
+	fake, backends := newFakeS3(t)
+
+	w, err := backends.Create(context.Background(), "s3://backups/partial.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("x"), 6<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.Object("backups", "partial.sql"); ok {
+		t.Fatal("aborted upload was completed")
+	}
+	if n := fake.PendingUploads(); n != 0 {
+		t.Fatalf("%d multipart uploads left behind", n)
+	}
+}
+
+func TestFileList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.tar.gz", "a.tar.gz", "sub/c.tar.gz"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := Default().List(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Location != filepath.Join(dir, "a.tar.gz") || objects[1].Name != "b.tar.gz" || objects[1].Size != 8 {
+		t.Fatalf("unexpected listing %+v", objects)
+	}
+	if _, err := Default().List(context.Background(), "https://example.com/backups/"); err == nil {
+		t.Fatal("expected https listing to fail")
+	}
+	if ok, err := Default().Exists(context.Background(), filepath.Join(dir, "missing")); ok || err != nil {
+		t.Fatalf("Exists(missing) = %v, %v", ok, err)
+	}
+	if ok, err := Default().Exists(context.Background(), StdioPath); ok || err != nil {
+		t.Fatalf("Exists(stdout) = %v, %v", ok, err)
+	}
+}
+
+func TestS3List(t *testing.T) {
+	fake, backends := newFakeS3(t)
+	mtime := time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)
+	for _, key := range []string{"daily/", "daily/db-1.tar.gz", "daily/db-2.tar.gz", "daily/old/db-0.tar.gz", "dailyx/db.tar.gz"} {
+		fake.Put("backups", key, []byte(key), mtime)
+	}
+
+	for _, location := range []string{"s3://backups/daily", "s3://backups/daily/"} {
+		objects, err := backends.List(context.Background(), location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != 2 || objects[0].Location != "s3://backups/daily/db-1.tar.gz" || objects[1].Name != "db-2.tar.gz" ||
+			objects[1].Size != 17 || !objects[1].ModTime.Equal(mtime) {
+			t.Fatalf("List(%q) = %+v", location, objects)
+		}
+	}
+}